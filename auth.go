@@ -0,0 +1,297 @@
+package whatsappws
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	scopeSend  = "send"
+	scopeRead  = "read"
+	scopeAdmin = "admin"
+)
+
+// Identity is the authenticated caller of an HTTP or WebSocket request.
+type Identity struct {
+	Name   string   `json:"name"`
+	UserID int      `json:"user_id"`
+	Scopes []string `json:"scopes"`
+}
+
+// HasScope reports whether the identity was granted the scope. The admin scope grants everything.
+func (id *Identity) HasScope(scope string) bool {
+	for _, granted := range id.Scopes {
+		if granted == scope || granted == scopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+type apiKey struct {
+	Key    string   `json:"key"`
+	Name   string   `json:"name"`
+	UserID *int     `json:"user_id"`
+	Scopes []string `json:"scopes"`
+
+	identity Identity
+}
+
+// AuthConfig is read from the file passed with -auth-config.
+type AuthConfig struct {
+	APIKeys []apiKey `json:"api_keys"`
+}
+
+// tokenClaims is the payload of an HMAC signed bearer token.
+type tokenClaims struct {
+	Subject   string   `json:"sub"`
+	UserID    int      `json:"uid"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// Authenticator checks static API keys and HMAC signed bearer tokens. If neither is configured,
+// authentication is disabled and every request is allowed.
+type Authenticator struct {
+	keys           []apiKey
+	secret         []byte
+	allowedOrigins []string
+}
+
+type identityContextKey struct{}
+
+var errUnauthorized = errors.New("missing or invalid credentials")
+
+func newAuthenticator(configPath, secret, origins string) (*Authenticator, error) {
+	auth := &Authenticator{}
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read auth config: %w", err)
+		}
+		var config AuthConfig
+		if err = json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse auth config: %w", err)
+		}
+		auth.keys = config.APIKeys
+		for i := range auth.keys {
+			key := &auth.keys[i]
+			key.identity = Identity{Name: key.Name, UserID: -1, Scopes: key.Scopes}
+			if key.UserID != nil {
+				key.identity.UserID = *key.UserID
+			}
+		}
+	}
+	if secret != "" {
+		auth.secret = []byte(secret)
+	}
+	for _, origin := range strings.Split(origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			auth.allowedOrigins = append(auth.allowedOrigins, origin)
+		}
+	}
+	return auth, nil
+}
+
+// Enabled reports whether any credentials are configured.
+func (a *Authenticator) Enabled() bool {
+	return len(a.keys) > 0 || len(a.secret) > 0
+}
+
+// Authenticate finds the credentials of the request in the Authorization header (Bearer), the
+// X-API-Key header or, on the WebSocket routes, the token query parameter. The query parameter is
+// needed for WebSocket connections from browsers, which can't set headers, and isn't accepted
+// elsewhere so that credentials don't end up in the logs of other requests.
+func (a *Authenticator) Authenticate(r *http.Request) (*Identity, error) {
+	credential := r.Header.Get("X-API-Key")
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		credential = strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	if credential == "" && isWebSocketPath(r.URL.Path) {
+		credential = r.URL.Query().Get("token")
+	}
+	if credential == "" {
+		return nil, errUnauthorized
+	}
+
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(a.keys[i].Key), []byte(credential)) == 1 {
+			return &a.keys[i].identity, nil
+		}
+	}
+	if len(a.secret) > 0 && strings.Contains(credential, ".") {
+		return a.verifyToken(credential)
+	}
+	return nil, errUnauthorized
+}
+
+// isWebSocketPath reports whether the path is /ws or /sessions/{id}/ws.
+func isWebSocketPath(urlPath string) bool {
+	matched, _ := path.Match("/sessions/*/ws", urlPath)
+	return urlPath == "/ws" || matched
+}
+
+func (a *Authenticator) sign(payload string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IssueToken creates a bearer token in the form <base64url(claims)>.<base64url(hmac-sha256)>.
+func (a *Authenticator) IssueToken(claims tokenClaims) (string, error) {
+	if len(a.secret) == 0 {
+		return "", errors.New("no token secret configured")
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + a.sign(payload), nil
+}
+
+func (a *Authenticator) verifyToken(token string) (*Identity, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(a.sign(parts[0])), []byte(parts[1])) {
+		return nil, errUnauthorized
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errUnauthorized
+	}
+	var claims tokenClaims
+	if err = json.Unmarshal(data, &claims); err != nil {
+		return nil, errUnauthorized
+	}
+	if claims.ExpiresAt != 0 && time.Now().Unix() > claims.ExpiresAt {
+		return nil, errors.New("token expired")
+	}
+	return &Identity{Name: claims.Subject, UserID: claims.UserID, Scopes: claims.Scopes}, nil
+}
+
+// CheckOrigin is used by the WebSocket upgrader and the CORS headers.
+func (a *Authenticator) CheckOrigin(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range a.allowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authenticator) allowsAnyOrigin() bool {
+	for _, allowed := range a.allowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// requireScope wraps a handler so that it only runs for callers with the given scope. The identity
+// is stored in the request context, see requestIdentity.
+func (a *Authenticator) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" || !a.Enabled() {
+			next(w, r)
+			return
+		}
+		identity := requestIdentity(r)
+		if identity == nil {
+			var err error
+			identity, err = a.Authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), identityContextKey{}, identity))
+		}
+		if !identity.HasScope(scope) {
+			http.Error(w, fmt.Sprintf("Missing %s scope", scope), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireScopeByMethod is like requireScope for routes that both read and change something: GET
+// requests need readScope and other methods need writeScope.
+func (a *Authenticator) requireScopeByMethod(readScope, writeScope string, next http.HandlerFunc) http.HandlerFunc {
+	read, write := a.requireScope(readScope, next), a.requireScope(writeScope, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			read(w, r)
+		} else {
+			write(w, r)
+		}
+	}
+}
+
+// requestIdentity returns the identity authenticated by requireScope, or nil if authentication is
+// disabled.
+func requestIdentity(r *http.Request) *Identity {
+	identity, _ := r.Context().Value(identityContextKey{}).(*Identity)
+	return identity
+}
+
+// boundUserID returns the user ID of the authenticated identity. The user ID sent by the client is
+// only trusted when authentication is disabled.
+func boundUserID(identity *Identity, clientUserID int) int {
+	if identity == nil {
+		return clientUserID
+	}
+	return identity.UserID
+}
+
+// setCORSHeaders sets the CORS headers for the origins allowed with -allowed-origins.
+func (a *Authenticator) setCORSHeaders(w http.ResponseWriter, r *http.Request, methods string) {
+	origin := r.Header.Get("Origin")
+	if a.allowsAnyOrigin() {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if origin != "" && a.CheckOrigin(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+}
+
+// RunTokenCommand implements the token subcommand, which prints a bearer token signed with
+// -auth-secret.
+func RunTokenCommand(secret string, args []string) int {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	subject := fs.String("subject", "", "Name of the token holder")
+	scopes := fs.String("scopes", scopeRead, "Comma-separated scopes (send, read, admin)")
+	userID := fs.Int("user-id", -1, "User ID to store with sent messages, -1 for none")
+	ttl := fs.Duration("ttl", 24*time.Hour, "Token lifetime, 0 for no expiry")
+	_ = fs.Parse(args)
+
+	claims := tokenClaims{Subject: *subject, UserID: *userID, Scopes: strings.Split(*scopes, ",")}
+	if *ttl > 0 {
+		claims.ExpiresAt = time.Now().Add(*ttl).Unix()
+	}
+	auth := &Authenticator{secret: []byte(secret)}
+	token, err := auth.IssueToken(claims)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to issue token: %v\n", err)
+		return 1
+	}
+	fmt.Println(token)
+	return 0
+}
@@ -0,0 +1,43 @@
+package whatsappws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthenticateTokenParameter(t *testing.T) {
+	auth, err := newAuthenticator("", "secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.IssueToken(tokenClaims{Subject: "crm", Scopes: []string{scopeRead}, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		target string
+		ok     bool
+	}{
+		{"/ws?token=" + token, true},
+		{"/sessions/" + testPhone + "/ws?token=" + token, true},
+		{"/status?token=" + token, false},
+		{"/sessions/" + testPhone + "/status?token=" + token, false},
+		{"/sessions/" + testPhone + "/ws/other?token=" + token, false},
+	} {
+		identity, err := auth.Authenticate(httptest.NewRequest(http.MethodGet, test.target, nil))
+		if test.ok && (err != nil || identity.Name != "crm") {
+			t.Errorf("%s: identity is %+v, error %v", test.target, identity, err)
+		} else if !test.ok && err != errUnauthorized {
+			t.Errorf("%s: error is %v, want %v", test.target, err, errUnauthorized)
+		}
+	}
+
+	// Headers are accepted on every route.
+	r := httptest.NewRequest(http.MethodGet, "/status", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if _, err = auth.Authenticate(r); err != nil {
+		t.Errorf("bearer token on /status: %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+package whatsappws
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// ChatLogMessage is a message stored in the chat log. UserID is -1 for messages that weren't sent
+// on behalf of a user. SenderJID is the author of the message, which differs from RemoteJID in
+// groups. Revoked is set for messages deleted for everyone.
+type ChatLogMessage struct {
+	MessageID       string     `json:"message_id"`
+	DeviceJID       string     `json:"device_jid"`
+	RemoteJID       string     `json:"remote_jid"`
+	Type            string     `json:"type"`
+	Content         string     `json:"content"`
+	Timestamp       time.Time  `json:"timestamp"`
+	Sent            bool       `json:"sent"`
+	FileName        string     `json:"file_name"`
+	UserID          int        `json:"user_id"`
+	SenderJID       string     `json:"sender_jid,omitempty"`
+	QuotedMessageID string     `json:"quoted_message_id,omitempty"`
+	ReadAt          *time.Time `json:"read_at,omitempty"`
+	DeliveredAt     *time.Time `json:"delivered_at,omitempty"`
+	PlayedAt        *time.Time `json:"played_at,omitempty"`
+	EditedAt        *time.Time `json:"edited_at,omitempty"`
+	Revoked         bool       `json:"revoked,omitempty"`
+	// RawMessage is the serialized waProto.Message, which replies quote. It's only read by
+	// GetMessage.
+	RawMessage []byte `json:"-"`
+}
+
+// ChatLogReceipt is the receipt of a single group participant.
+type ChatLogReceipt struct {
+	MessageID   string    `json:"message_id"`
+	DeviceJID   string    `json:"device_jid"`
+	RemoteJID   string    `json:"remote_jid"`
+	Participant string    `json:"participant"`
+	Type        string    `json:"type"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// ChatLogEdit is a previous content of an edited message, replaced at EditedAt.
+type ChatLogEdit struct {
+	MessageID string    `json:"message_id"`
+	DeviceJID string    `json:"device_jid"`
+	RemoteJID string    `json:"remote_jid"`
+	Content   string    `json:"content"`
+	EditedAt  time.Time `json:"edited_at"`
+}
+
+// ChatLogReaction is the reaction of a reactor to a message. An empty Reaction removes it.
+type ChatLogReaction struct {
+	MessageID  string    `json:"message_id"`
+	DeviceJID  string    `json:"device_jid"`
+	RemoteJID  string    `json:"remote_jid"`
+	ReactorJID string    `json:"reactor_jid"`
+	Reaction   string    `json:"reaction"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// ChatLogPoll is a poll and its options in order. SelectableCount is 0 if any number of options
+// can be selected.
+type ChatLogPoll struct {
+	MessageID       string    `json:"message_id"`
+	DeviceJID       string    `json:"device_jid"`
+	RemoteJID       string    `json:"remote_jid"`
+	Name            string    `json:"name"`
+	Options         []string  `json:"options"`
+	SelectableCount int       `json:"selectable_count"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ChatLogPollVote is the latest vote of a voter in a poll. Options is empty if the vote was
+// retracted.
+type ChatLogPollVote struct {
+	MessageID string    `json:"message_id"`
+	DeviceJID string    `json:"device_jid"`
+	RemoteJID string    `json:"remote_jid"`
+	VoterJID  string    `json:"voter_jid"`
+	Options   []string  `json:"options"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ChatLogGroup is a joined group as last fetched from WhatsApp.
+type ChatLogGroup struct {
+	DeviceJID        string    `json:"device_jid"`
+	GroupJID         string    `json:"group_jid"`
+	Name             string    `json:"name"`
+	Topic            string    `json:"topic"`
+	OwnerJID         string    `json:"owner_jid"`
+	Announce         bool      `json:"announce"`
+	Locked           bool      `json:"locked"`
+	ParticipantCount int       `json:"participant_count"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// ChatLogGroupParticipant is a participant of a joined group.
+type ChatLogGroupParticipant struct {
+	ParticipantJID string `json:"participant_jid"`
+	Admin          bool   `json:"admin"`
+	SuperAdmin     bool   `json:"super_admin"`
+}
+
+// ChatLogGroupChange is a change of the info of a group. Nil fields are unchanged.
+type ChatLogGroupChange struct {
+	Name     *string
+	Topic    *string
+	Announce *bool
+	Locked   *bool
+}
+
+// ChatLogMedia is the media of a received message, with what's needed to download it. Path is
+// relative to the data directory and empty until the file is downloaded. Status is one of the
+// media status constants.
+type ChatLogMedia struct {
+	MessageID     string
+	DeviceJID     string
+	RemoteJID     string
+	SenderJID     string
+	FromMe        bool
+	MediaType     string
+	MimeType      string
+	FileName      string
+	DirectPath    string
+	MediaKey      []byte
+	FileEncSHA256 []byte
+	FileSHA256    []byte
+	FileLength    int64
+	Thumbnail     []byte
+	Path          string
+	Status        string
+	Error         string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DownloadedAt  *time.Time
+}
+
+const (
+	mediaPending    = "pending"
+	mediaDownloaded = "downloaded"
+	mediaRetry      = "retry"
+	mediaFailed     = "failed"
+)
+
+// ChatLogChat is a chat with its last message. UnreadCount is the number of received messages that
+// weren't read yet. Name is only known for groups.
+type ChatLogChat struct {
+	RemoteJID   string         `json:"remote_jid"`
+	Name        string         `json:"name,omitempty"`
+	UnreadCount int            `json:"unread_count"`
+	LastMessage ChatLogMessage `json:"last_message"`
+}
+
+// ChatLogCursor is the position of a message in the history of a chat.
+type ChatLogCursor struct {
+	Timestamp time.Time
+	ID        int64
+}
+
+// ChatLogChatCursor is the position of a chat in the chat list, by the timestamp of its last
+// message and then its JID.
+type ChatLogChatCursor struct {
+	Timestamp time.Time
+	RemoteJID string
+}
+
+// ChatLogMessageQuery selects messages of a chat. Since is inclusive and Until exclusive; zero
+// times, an empty Types and a nil Before don't filter.
+type ChatLogMessageQuery struct {
+	DeviceJID string
+	RemoteJID string
+	Types     []string
+	Since     time.Time
+	Until     time.Time
+	Before    *ChatLogCursor
+	Limit     int
+}
+
+// ChatLogSearchQuery searches the content of messages. RemoteJID is optional for searches, and a
+// nil Sent searches both sent and received messages.
+type ChatLogSearchQuery struct {
+	ChatLogMessageQuery
+	Text string
+	Sent *bool
+}
+
+// ChatLogSearchResult is a message that matched a search. Snippet is the matching part of the
+// content, with the matches between highlightStart and highlightEnd.
+type ChatLogSearchResult struct {
+	ChatLogMessage
+	Snippet string `json:"snippet"`
+}
+
+const (
+	highlightStart = "<mark>"
+	highlightEnd   = "</mark>"
+)
+
+// searchHighlighter returns a regexp that matches the words of the search text, ignoring case, for
+// the stores that highlight the snippets themselves.
+func searchHighlighter(text string) *regexp.Regexp {
+	words := strings.Fields(text)
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(words, "|"))
+}
+
+// highlight wraps the matches of the highlighter in highlightStart and highlightEnd.
+func highlight(highlighter *regexp.Regexp, content string) string {
+	return highlighter.ReplaceAllString(content, highlightStart+"$0"+highlightEnd)
+}
+
+var (
+	errMessageNotFound = errors.New("message not found")
+	errPollNotFound    = errors.New("poll not found")
+	errMediaNotFound   = errors.New("media not found")
+)
+
+// ChatLogStore persists messages, the last message of every chat, read state, receipts and the
+// joined groups, and reads back, and searches, chats and their history.
+type ChatLogStore interface {
+	// InsertMessage stores a message.
+	InsertMessage(msg *ChatLogMessage) error
+	// SetLastMessage makes the message the last message of its chat.
+	SetLastMessage(msg *ChatLogMessage) error
+	// SetLastMessageIfNewer is like SetLastMessage, but keeps the stored last message if it's
+	// newer. It's used for history syncs, which can arrive after newer live messages.
+	SetLastMessageIfNewer(msg *ChatLogMessage) error
+	// MessageExists reports whether a message is already stored.
+	MessageExists(messageID, deviceJID, remoteJID string) (bool, error)
+	// GetMessage returns a stored message, or errMessageNotFound.
+	GetMessage(messageID, deviceJID, remoteJID string) (*ChatLogMessage, error)
+	// EditMessage replaces the content of a stored message, and of the last message of its chat if
+	// it's the same message. The previous content is kept in the edit history. It returns
+	// errMessageNotFound if the message isn't stored.
+	EditMessage(messageID, deviceJID, remoteJID, content string, editedAt time.Time) error
+	// RevokeMessage flags a stored message, and the last message of its chat if it's the same
+	// message, as deleted for everyone. It returns errMessageNotFound if the message isn't stored.
+	RevokeMessage(messageID, deviceJID, remoteJID string) error
+	// MarkRead records that a received message was read by us.
+	MarkRead(messageID, deviceJID, remoteJID string, timestamp time.Time) error
+	// MarkReceipt records the first delivered, read or played receipt of a sent message. A read or
+	// played receipt also implies delivery.
+	MarkReceipt(messageID, deviceJID, remoteJID, receiptType string, timestamp time.Time) error
+	// InsertReceipt records the receipt of a single group participant.
+	InsertReceipt(receipt *ChatLogReceipt) error
+	// SetReaction stores the reaction of a reactor to a message, replacing their previous reaction,
+	// or removes it if the reaction is empty.
+	SetReaction(reaction *ChatLogReaction) error
+	// InsertPoll stores a poll and its options, unless it's already stored.
+	InsertPoll(poll *ChatLogPoll) error
+	// GetPoll returns a stored poll, or errPollNotFound.
+	GetPoll(messageID, deviceJID, remoteJID string) (*ChatLogPoll, error)
+	// SetPollVote stores the vote of a voter, unless a newer vote of the voter is stored.
+	SetPollVote(vote *ChatLogPollVote) error
+	// PollVotes returns the latest vote of every voter in a poll.
+	PollVotes(messageID, deviceJID, remoteJID string) ([]ChatLogPollVote, error)
+	// SetGroup stores the info of a group, replacing the stored info. The invite link is kept.
+	SetGroup(group *ChatLogGroup) error
+	// SetGroupInviteLink stores the current invite link of a stored group.
+	SetGroupInviteLink(deviceJID, groupJID, inviteLink string) error
+	// UpdateGroup applies a change of the group info to a stored group.
+	UpdateGroup(deviceJID, groupJID string, change *ChatLogGroupChange) error
+	// SetGroupParticipants replaces the participants of a group.
+	SetGroupParticipants(deviceJID, groupJID string, participants []ChatLogGroupParticipant) error
+	// ChangeGroupParticipants adds ("join"), removes ("leave"), promotes or demotes participants of
+	// a group, and updates the participant count of the stored group.
+	ChangeGroupParticipants(deviceJID, groupJID, change string, participantJIDs []string) error
+	// InsertMedia stores the media of a message, unless it's already stored.
+	InsertMedia(media *ChatLogMedia) error
+	// GetMedia returns the stored media of a message, or errMediaNotFound. remoteJID is optional.
+	GetMedia(deviceJID, remoteJID, messageID string) (*ChatLogMedia, error)
+	// UpdateMedia stores the direct path, path, status, error and download time of stored media.
+	UpdateMedia(media *ChatLogMedia) error
+	// Chats returns up to limit chats of a device after the before cursor, most recent first, and
+	// the cursor of the next page, or nil if there are no more chats. A nil before starts with the
+	// most recent chat.
+	Chats(deviceJID string, before *ChatLogChatCursor, limit int) ([]ChatLogChat, *ChatLogChatCursor, error)
+	// ChatMessages returns the messages of a chat matching the query, newest first, and the cursor of
+	// the next page, or nil if there are no more messages.
+	ChatMessages(query *ChatLogMessageQuery) ([]ChatLogMessage, *ChatLogCursor, error)
+	// SearchMessages returns the messages matching a search, newest first, and the cursor of the
+	// next page, or nil if there are no more results.
+	SearchMessages(query *ChatLogSearchQuery) ([]ChatLogSearchResult, *ChatLogCursor, error)
+}
+
+// newChatLogStore returns the chat log store for the -chatlog-db-dialect.
+func newChatLogStore(dialect string, db *sql.DB, log waLog.Logger) (ChatLogStore, error) {
+	switch dialect {
+	case "postgres":
+		return newPostgresChatLog(db, log), nil
+	case "sqlite3":
+		return newSQLiteChatLog(db, log)
+	default:
+		return nil, fmt.Errorf("unsupported chat log dialect %q", dialect)
+	}
+}
+
+// receiptColumns maps receipt types to the columns of messages they set. A read receipt implies
+// delivery, and a played receipt implies that the message was also read.
+var receiptColumns = map[string][]string{
+	"delivered": {"delivered_at"},
+	"read":      {"read_at", "delivered_at"},
+	"played":    {"played_at", "read_at", "delivered_at"},
+}
+
+// chatsPage cuts the chats, of which one more than the limit is read to tell whether there's a
+// next page, to the limit, and returns the cursor of the next page.
+func chatsPage(chats []ChatLogChat, limit int) ([]ChatLogChat, *ChatLogChatCursor) {
+	if len(chats) <= limit {
+		return chats, nil
+	}
+	chats = chats[:limit]
+	last := chats[limit-1].LastMessage
+	return chats, &ChatLogChatCursor{Timestamp: last.Timestamp, RemoteJID: last.RemoteJID}
+}
+
+// storeMessage stores a message and makes it the last message of its chat.
+func (s *Session) storeMessage(msg *ChatLogMessage) {
+	if err := s.srv.chatLog.InsertMessage(msg); err != nil {
+		s.log.Errorf("Error inserting into messages: %v", err)
+	}
+	if err := s.srv.chatLog.SetLastMessage(msg); err != nil {
+		s.log.Errorf("Error inserting into last_messages: %v", err)
+	}
+}
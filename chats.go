@@ -0,0 +1,226 @@
+package whatsappws
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// ChatsPage is a page of chats, most recent first. NextCursor is passed as before to get the next
+// page, and is empty on the last page.
+type ChatsPage struct {
+	Chats      []ChatLogChat `json:"chats"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// MessagesPage is a page of the history of a chat, newest first. NextCursor is passed as before to
+// get the next page, and is empty on the last page.
+type MessagesPage struct {
+	Messages   []ChatLogMessage `json:"messages"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// encodeCursor returns an opaque cursor for the API.
+func encodeCursor(cursor *ChatLogCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d", cursor.Timestamp.UnixNano(), cursor.ID)))
+}
+
+func decodeCursor(s string) (*ChatLogCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	timestamp, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return nil, errInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	cursor := &ChatLogCursor{Timestamp: time.Unix(0, nanos).UTC()}
+	if cursor.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, errInvalidCursor
+	}
+	return cursor, nil
+}
+
+// encodeChatCursor returns an opaque cursor of the chat list for the API.
+func encodeChatCursor(cursor *ChatLogChatCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%s", cursor.Timestamp.UnixNano(), cursor.RemoteJID)))
+}
+
+func decodeChatCursor(s string) (*ChatLogChatCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	timestamp, remoteJID, ok := strings.Cut(string(raw), ".")
+	if !ok || remoteJID == "" {
+		return nil, errInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return &ChatLogChatCursor{Timestamp: time.Unix(0, nanos).UTC(), RemoteJID: remoteJID}, nil
+}
+
+// parsePageSize reads the limit parameter.
+func parsePageSize(values url.Values) (int, error) {
+	limit := values.Get("limit")
+	if limit == "" {
+		return defaultPageSize, nil
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 || n > maxPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	return n, nil
+}
+
+// parseTimeFilter reads an RFC 3339 time or a date, which is midnight UTC.
+func parseTimeFilter(values url.Values, name string) (time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: must be an RFC 3339 time or a date", name)
+	}
+	return t, nil
+}
+
+// parseMessageQuery reads the limit, since, until, type and before parameters of a message query.
+func parseMessageQuery(values url.Values) (query ChatLogMessageQuery, err error) {
+	if query.Limit, err = parsePageSize(values); err != nil {
+		return query, err
+	}
+	if query.Since, err = parseTimeFilter(values, "since"); err != nil {
+		return query, err
+	}
+	if query.Until, err = parseTimeFilter(values, "until"); err != nil {
+		return query, err
+	}
+	for _, types := range values["type"] {
+		for _, msgType := range strings.Split(types, ",") {
+			if msgType = strings.TrimSpace(msgType); msgType != "" {
+				query.Types = append(query.Types, msgType)
+			}
+		}
+	}
+	if before := values.Get("before"); before != "" {
+		if query.Before, err = decodeCursor(before); err != nil {
+			return query, err
+		}
+	}
+	return query, nil
+}
+
+// serveChats lists the chats of the session from the last message of every chat, most recent
+// first, paginated with limit and the before cursor.
+func (srv *Server) serveChats(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "GET")
+	switch r.Method {
+	case "OPTIONS":
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodGet:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	sess := srv.requestSession(r)
+	if sess == nil || sess.device.ID == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	limit, err := parsePageSize(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var before *ChatLogChatCursor
+	if s := r.URL.Query().Get("before"); s != "" {
+		if before, err = decodeChatCursor(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	chats, next, err := srv.chatLog.Chats(sess.device.ID.String(), before, limit)
+	if err != nil {
+		srv.handleError(w, http.StatusInternalServerError, "Failed to read chats", err)
+		return
+	}
+	page := ChatsPage{Chats: chats}
+	if next != nil {
+		page.NextCursor = encodeChatCursor(next)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// serveChat serves GET /chats/{jid}/messages, the history of a chat, newest first. It's filtered
+// by the since, until and type parameters and paginated with limit and the before cursor.
+func (srv *Server) serveChat(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "GET")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	jid, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/chats/"), "/")
+	if action != "messages" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	sess := srv.requestSession(r)
+	if sess == nil || sess.device.ID == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	chat, err := parseJID(jid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query, err := parseMessageQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.DeviceJID = sess.device.ID.String()
+	query.RemoteJID = chat.String()
+
+	messages, next, err := srv.chatLog.ChatMessages(&query)
+	if err != nil {
+		srv.handleError(w, http.StatusInternalServerError, "Failed to read messages", err)
+		return
+	}
+	page := MessagesPage{Messages: messages}
+	if next != nil {
+		page.NextCursor = encodeCursor(next)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
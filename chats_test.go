@@ -0,0 +1,73 @@
+package whatsappws
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestChatsPagination(t *testing.T) {
+	for _, store := range []struct {
+		name    string
+		chatLog ChatLogStore
+	}{{"sql", nil}, {"memory", NewMemoryChatLog()}} {
+		t.Run(store.name, func(t *testing.T) {
+			srv, _ := newTestServer(t, store.chatLog)
+			deviceJID := testPhone + "@s.whatsapp.net"
+			start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			setLast := func(deviceJID, remoteJID string, timestamp time.Time) {
+				t.Helper()
+				err := srv.chatLog.SetLastMessage(&ChatLogMessage{
+					MessageID: "MSG" + timestamp.Format("150405"),
+					DeviceJID: deviceJID,
+					RemoteJID: remoteJID,
+					Type:      "text",
+					Content:   "Hello",
+					Timestamp: timestamp,
+					UserID:    -1,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Chats with the same timestamp are ordered by JID.
+			setLast(deviceJID, "905550000001@s.whatsapp.net", start.Add(time.Minute))
+			setLast(deviceJID, "905550000002@s.whatsapp.net", start.Add(time.Minute))
+			setLast(deviceJID, "905550000003@s.whatsapp.net", start)
+			setLast("905559998877@s.whatsapp.net", "905550000004@s.whatsapp.net", start)
+
+			chats := func(query string) ChatsPage {
+				t.Helper()
+				var page ChatsPage
+				decodeResponse(t, serve(srv, http.MethodGet, "/chats?"+query, "", nil), http.StatusOK, &page)
+				return page
+			}
+			jids := func(page ChatsPage) []string {
+				var jids []string
+				for _, chat := range page.Chats {
+					jids = append(jids, chat.RemoteJID)
+				}
+				return jids
+			}
+
+			first := chats("limit=2")
+			if got := jids(first); len(got) != 2 || got[0] != "905550000002@s.whatsapp.net" || got[1] != "905550000001@s.whatsapp.net" {
+				t.Fatalf("first page is %v", got)
+			}
+			if first.NextCursor == "" {
+				t.Fatal("first page has no next cursor")
+			}
+			// A chat of the first page that gets a new message doesn't push another chat of the
+			// first page onto the second.
+			setLast(deviceJID, "905550000001@s.whatsapp.net", start.Add(2*time.Minute))
+			second := chats("limit=2&before=" + first.NextCursor)
+			if got := jids(second); len(got) != 1 || got[0] != "905550000003@s.whatsapp.net" || second.NextCursor != "" {
+				t.Errorf("second page is %v with cursor %q", got, second.NextCursor)
+			}
+
+			if w := serve(srv, http.MethodGet, "/chats?before=invalid", "", nil); w.Code != http.StatusBadRequest {
+				t.Errorf("status with an invalid cursor is %d, want 400", w.Code)
+			}
+		})
+	}
+}
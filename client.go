@@ -0,0 +1,54 @@
+package whatsappws
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// WAClient is the part of *whatsmeow.Client that the handlers use. FakeClient implements it for
+// tests. Pairing (QR channel, pre-pair callback) is only done with the real client, see
+// SessionManager.start.
+type WAClient interface {
+	Connect() error
+	Disconnect()
+	Logout() error
+	IsConnected() bool
+	IsLoggedIn() bool
+	AddEventHandler(handler whatsmeow.EventHandler) uint32
+
+	GenerateMessageID() types.MessageID
+	SendMessage(ctx context.Context, to types.JID, message *waProto.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error)
+	BuildEdit(chat types.JID, id types.MessageID, newContent *waProto.Message) *waProto.Message
+	BuildRevoke(chat, sender types.JID, id types.MessageID) *waProto.Message
+	BuildReaction(chat, sender types.JID, id types.MessageID, reaction string) *waProto.Message
+	BuildPollCreation(name string, optionNames []string, selectableOptionCount int) *waProto.Message
+	Upload(ctx context.Context, plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error)
+	DownloadMediaWithPath(directPath string, encFileHash, fileHash, mediaKey []byte, fileLength int, mediaType whatsmeow.MediaType, mmsType string) ([]byte, error)
+	SendMediaRetryReceipt(message *types.MessageInfo, mediaKey []byte) error
+	MarkRead(ids []types.MessageID, timestamp time.Time, chat, sender types.JID) error
+	IsOnWhatsApp(phones []string) ([]types.IsOnWhatsAppResponse, error)
+	SendPresence(state types.Presence) error
+	SetStatusMessage(msg string) error
+
+	GetJoinedGroups() ([]*types.GroupInfo, error)
+	GetGroupInfo(jid types.JID) (*types.GroupInfo, error)
+	CreateGroup(req whatsmeow.ReqCreateGroup) (*types.GroupInfo, error)
+	UpdateGroupParticipants(jid types.JID, participantChanges map[types.JID]whatsmeow.ParticipantChange) (*waBinary.Node, error)
+	SetGroupName(jid types.JID, name string) error
+	SetGroupTopic(jid types.JID, previousID, newID, topic string) error
+	SetGroupPhoto(jid types.JID, avatar []byte) (string, error)
+	GetGroupInviteLink(jid types.JID, reset bool) (string, error)
+	JoinGroupWithLink(code string) (types.JID, error)
+
+	ParseWebMessage(chatJID types.JID, webMsg *waProto.WebMessageInfo) (*events.Message, error)
+	DecryptPollVote(vote *events.Message) (*waProto.PollVoteMessage, error)
+	DecryptReaction(reaction *events.Message) (*waProto.ReactionMessage, error)
+}
+
+var _ WAClient = (*whatsmeow.Client)(nil)
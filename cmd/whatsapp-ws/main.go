@@ -0,0 +1,148 @@
+// Copyright (c) 2021 Tulir Asokan
+// Modified by (c) 2024 huzairuje
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+
+	whatsappws "whatsapp-ws"
+)
+
+var (
+	logLevel         = "INFO"                                                                                                                 // Log level
+	debugLogs        = flag.Bool("debug", false, "Enable debug logs?")                                                                        // Enable debug logs
+	dbDialect        = flag.String("db-dialect", "sqlite3", "Database dialect (sqlite3 or postgres)")                                         // Session database dialect
+	dbAddress        = flag.String("db-address", "file:mdtest.db?sslmode=disable", "Database address")                                        // Session database address
+	requestFullSync  = flag.Bool("request-full-sync", false, "Request full (1 year) history sync when logging in?")                           // Request full history sync when logging in
+	wsPort           = flag.String("ws-port", "8080", "WebSocket port")                                                                       // WebSocket port
+	chatLogDBDialect = flag.String("chatlog-db-dialect", "postgres", "Chat log database dialect (sqlite3 or postgres)")                       // Chat log database dialect
+	chatLogDBAddress = flag.String("chatlog-db-address", "postgresql://local@localhost/testing?sslmode=disable", "Chat log database address") // Chat log database address
+	autoMigrate      = flag.Bool("auto-migrate", true, "Apply chat log database migrations on startup?")                                      // Migrate chat log database on startup
+	dirPtr           = flag.String("data-dir", "/opt/whatsapp/data", "Directory to serve files from and download media into")                 // Directory to serve files and media from
+	authConfig       = flag.String("auth-config", "", "Path to a JSON file with API keys")                                                    // API keys
+	authSecret       = flag.String("auth-secret", "", "Secret for HMAC signed bearer tokens")                                                 // Bearer token secret
+	allowedOrigins   = flag.String("allowed-origins", "*", "Comma-separated origins allowed for CORS and WebSocket connections")              // Allowed origins
+	webhookConfig    = flag.String("webhook-config", "", "Path to a JSON file with webhook endpoints")                                        // Webhook endpoints
+)
+
+func main() {
+	waBinary.IndentXML = true
+	flag.Parse()
+
+	if *debugLogs {
+		logLevel = "DEBUG"
+	}
+	if *requestFullSync {
+		store.DeviceProps.RequireFullSync = proto.Bool(true)
+	}
+	log := waLog.Stdout("Main", logLevel, true)
+
+	if flag.Arg(0) == "token" {
+		os.Exit(whatsappws.RunTokenCommand(*authSecret, flag.Args()[1:]))
+	}
+
+	// Connect to chatlog database
+	db, err := sql.Open(*chatLogDBDialect, *chatLogDBAddress)
+	if err != nil {
+		log.Errorf("Failed to connect chatlog database: %v", err)
+		return
+	}
+	defer db.Close()
+	if *chatLogDBDialect == "sqlite3" {
+		// SQLite only allows one writer at a time.
+		db.SetMaxOpenConns(1)
+	}
+	if flag.Arg(0) == "migrate" {
+		if err = whatsappws.MigrateChatLog(db, *chatLogDBDialect, log); err != nil {
+			log.Errorf("Failed to migrate chatlog database: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	dbLog := waLog.Stdout("Database", logLevel, true)
+	storeContainer, err := sqlstore.New(*dbDialect, *dbAddress, dbLog)
+	if err != nil {
+		log.Errorf("Failed to connect to session database: %v", err)
+		return
+	}
+
+	srv, err := whatsappws.NewServer(whatsappws.Config{
+		Logger:         log,
+		LogLevel:       logLevel,
+		SessionStore:   storeContainer,
+		ChatLogDB:      db,
+		ChatLogDialect: *chatLogDBDialect,
+		AutoMigrate:    *autoMigrate,
+		DataDir:        *dirPtr,
+		AuthConfig:     *authConfig,
+		AuthSecret:     *authSecret,
+		AllowedOrigins: *allowedOrigins,
+		WebhookConfig:  *webhookConfig,
+	})
+	if err != nil {
+		log.Errorf("Failed to create server: %v", err)
+		return
+	}
+	if err = srv.Start(); err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+
+	go func() {
+		log.Infof("Starting WebSocket server")
+		err := http.ListenAndServe(":"+*wsPort, srv.Handler())
+		if err != nil {
+			log.Errorf("Failed to start WebSocket server: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	c := make(chan os.Signal, 1)
+	input := make(chan string)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer close(input)
+		scan := bufio.NewScanner(os.Stdin)
+		for scan.Scan() {
+			line := strings.TrimSpace(scan.Text())
+			if len(line) > 0 {
+				input <- line
+			}
+		}
+	}()
+	for {
+		select {
+		case <-c:
+			log.Infof("Interrupt received, exiting")
+			srv.Close()
+			return
+		case cmd := <-input:
+			if len(cmd) == 0 {
+				log.Infof("Stdin closed, exiting")
+				srv.Close()
+				return
+			}
+			srv.HandleConsoleInput(cmd)
+		}
+	}
+}
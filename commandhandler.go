@@ -1,4 +1,4 @@
-package main
+package whatsappws
 
 import (
 	"bytes"
@@ -20,51 +20,41 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-func handleIsLoggedIn() {
-	log.Infof("Checking if logged in...")
-	log.Infof("Logged in: %t", cli.IsLoggedIn())
+func (s *Session) handleIsLoggedIn() (interface{}, error) {
+	s.log.Infof("Checking if logged in...")
+	loggedIn := s.cli.IsLoggedIn()
+	s.log.Infof("Logged in: %t", loggedIn)
+	return map[string]bool{"logged_in": loggedIn}, nil
 }
 
-func handleCheckUser(args []string) {
-	log.Infof("Checking users: %v", args)
+func (s *Session) handleCheckUser(args []string) (interface{}, error) {
 	if len(args) < 1 {
-		log.Errorf("Usage: checkuser <phone numbers...>")
-		return
+		return nil, errors.New("usage: checkuser <phone numbers...>")
 	}
 
-	resp, err := cli.IsOnWhatsApp(args)
+	resp, err := s.newHandleCheckUser(args)
 	if err != nil {
-		log.Errorf("Failed to check if users are on WhatsApp: %v", err)
-		return
+		return nil, err
 	}
 
 	for _, item := range resp {
-		logMessage := fmt.Sprintf("%s: on WhatsApp: %t, JID: %s", item.Query, item.IsIn, item.JID)
-
-		if item.VerifiedName != nil {
-			logMessage += fmt.Sprintf(", business name: %s", item.VerifiedName.Details.GetVerifiedName())
-		}
-
-		log.Infof(logMessage)
-
 		// Send response to websocket
-		if wsConn != nil {
-			wsConn.WriteJSON(item)
-		}
+		s.srv.hub.Broadcast(s, item)
 	}
+	return resp, nil
 }
 
-func newHandleCheckUser(args []string) (response []types.IsOnWhatsAppResponse) {
-	log.Infof("Checking users: %v", args)
+func (s *Session) newHandleCheckUser(args []string) (response []types.IsOnWhatsAppResponse, err error) {
+	s.log.Infof("Checking users: %v", args)
 	if len(args) < 1 {
-		log.Errorf("Usage: checkuser <phone numbers...>")
-		return nil
+		s.log.Errorf("Usage: checkuser <phone numbers...>")
+		return nil, nil
 	}
 
-	resp, err := cli.IsOnWhatsApp(args)
+	resp, err := s.cli.IsOnWhatsApp(args)
 	if err != nil {
-		log.Errorf("Failed to check if users are on WhatsApp: %v", err)
-		return nil
+		s.log.Errorf("Failed to check if users are on WhatsApp: %v", err)
+		return nil, fmt.Errorf("failed to check if users are on WhatsApp: %w", err)
 	}
 
 	for _, item := range resp {
@@ -73,267 +63,384 @@ func newHandleCheckUser(args []string) (response []types.IsOnWhatsAppResponse) {
 		if item.VerifiedName != nil {
 			logMessage += fmt.Sprintf(", business name: %s", item.VerifiedName.Details.GetVerifiedName())
 		}
-		log.Infof(logMessage)
+		s.log.Infof(logMessage)
 		response = append(response, item)
 	}
-	return response
+	return response, nil
 }
 
-func handleSendTextMessage(args []string, userID int) {
-	if len(args) < 2 {
-		log.Errorf("Usage: send <jid> <text>")
-		return
-	}
+// sendResult is the result of the send commands.
+type sendResult struct {
+	MessageID string    `json:"message_id"`
+	Recipient string    `json:"recipient"`
+	Timestamp time.Time `json:"timestamp"`
+}
 
-	recipient, ok := parseJID(args[0])
-	if !ok {
-		return
-	}
+// textOptions are the optional parts of a text message: the message it replies to and the JIDs it
+// mentions.
+type textOptions struct {
+	QuotedMessageID string
+	Mentions        []types.JID
+}
 
-	msg := &waProto.Message{
-		Conversation: proto.String(strings.Join(args[1:], " ")),
+func parseTextOptions(quotedMessageID string, mentions []string) (textOptions, error) {
+	opts := textOptions{QuotedMessageID: quotedMessageID}
+	for _, mention := range mentions {
+		jid, err := parseJID(mention)
+		if err != nil {
+			return opts, fmt.Errorf("invalid mention: %w", err)
+		}
+		opts.Mentions = append(opts.Mentions, jid)
 	}
-	log.Infof("Sending message to %s: %s", recipient, msg.GetConversation())
+	return opts, nil
+}
 
-	resp, err := cli.SendMessage(context.Background(), recipient, msg)
+// quotedMessage looks up the message a reply to the chat quotes in the chat log.
+func (s *Session) quotedMessage(chat types.JID, messageID string) (*ChatLogMessage, error) {
+	quoted, err := s.srv.chatLog.GetMessage(messageID, s.device.ID.String(), chat.String())
 	if err != nil {
-		log.Errorf("Error sending message: %v", err)
-		return
+		return nil, fmt.Errorf("quoted message %s: %w", messageID, err)
 	}
+	return quoted, nil
+}
 
-	log.Infof("Message sent (server timestamp: %s)", resp.Timestamp)
-
-	if err := insertMessages(resp.ID, cli.Store.ID.String(), recipient.String(), msg.GetConversation(), "text", resp.Timestamp, true, "", userID); err != nil {
-		log.Errorf("Error inserting into messages: %v", err)
+// quotedMessageProto returns the message quoted by a reply. Text is rebuilt from the content,
+// which includes edits; other messages are quoted as they were stored. Those stored without the
+// raw message aren't quoted, the reply only refers to their ID.
+func quotedMessageProto(quoted *ChatLogMessage) *waProto.Message {
+	if quoted.Type == "text" {
+		return &waProto.Message{Conversation: proto.String(quoted.Content)}
 	}
+	if len(quoted.RawMessage) == 0 {
+		return nil
+	}
+	var msg waProto.Message
+	if err := proto.Unmarshal(quoted.RawMessage, &msg); err != nil {
+		return nil
+	}
+	return &msg
+}
 
-	if err := insertLastMessages(resp.ID, cli.Store.ID.String(), recipient.String(), msg.GetConversation(), "text", resp.Timestamp, true, "", userID); err != nil {
-		log.Errorf("Error inserting into last_messages: %v", err)
+// rawMessage serializes a message for ChatLogMessage.RawMessage.
+func rawMessage(msg *waProto.Message) []byte {
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		return nil
 	}
+	return raw
+}
 
-	if wsConn != nil {
-		m := Message{resp.ID, recipient.String(), "text", msg.GetConversation(), true, ""}
-		wsConn.WriteJSON(m)
+// buildTextMessage returns a plain text message, or an extended text message with the quoted
+// message and mentions in its context info.
+func (s *Session) buildTextMessage(recipient types.JID, text string, opts textOptions) (*waProto.Message, error) {
+	if opts.QuotedMessageID == "" && len(opts.Mentions) == 0 {
+		return &waProto.Message{Conversation: proto.String(text)}, nil
 	}
+	contextInfo := &waProto.ContextInfo{}
+	for _, jid := range opts.Mentions {
+		contextInfo.MentionedJid = append(contextInfo.MentionedJid, jid.String())
+	}
+	if opts.QuotedMessageID != "" {
+		quoted, err := s.quotedMessage(recipient, opts.QuotedMessageID)
+		if err != nil {
+			return nil, err
+		}
+		// The participant is the author of the quoted message.
+		participant := quoted.SenderJID
+		if quoted.Sent {
+			participant = s.device.ID.ToNonAD().String()
+		} else if participant == "" {
+			participant = quoted.RemoteJID
+		}
+		contextInfo.StanzaId = proto.String(quoted.MessageID)
+		contextInfo.Participant = proto.String(participant)
+		contextInfo.QuotedMessage = quotedMessageProto(quoted)
+	}
+	return &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:        proto.String(text),
+			ContextInfo: contextInfo,
+		},
+	}, nil
 }
 
-func handleSendNewTextMessage(textMsg string, jid string) {
-	recipient, ok := parseJID(jid)
-	if !ok {
-		return
+func (s *Session) handleSendTextMessage(args []string, userID int, opts textOptions) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, errors.New("usage: send <jid> <text>")
 	}
 
-	msg := &waProto.Message{
-		Conversation: proto.String(textMsg),
+	recipient, err := parseJID(args[0])
+	if err != nil {
+		return nil, err
 	}
-	log.Infof("Sending message to %s: %s", recipient, msg.GetConversation())
 
-	resp, err := cli.SendMessage(context.Background(), recipient, msg)
+	text := strings.Join(args[1:], " ")
+	msg, err := s.buildTextMessage(recipient, text, opts)
 	if err != nil {
-		log.Errorf("Error sending message: %v", err)
-		return
+		return nil, err
 	}
+	s.log.Infof("Sending message to %s: %s", recipient, text)
 
-	log.Infof("Message sent (server timestamp: %s)", resp.Timestamp)
-}
+	resp, err := s.cli.SendMessage(context.Background(), recipient, msg)
+	if err != nil {
+		s.log.Errorf("Error sending message: %v", err)
+		return nil, fmt.Errorf("error sending message: %w", err)
+	}
 
-func handleSendNewTextMessageBulk(textMsg string, jids []string) {
-	var wg sync.WaitGroup
-	for _, jid := range jids {
-		wg.Add(1)
-		go func(jid string) {
-			defer wg.Done()
+	s.log.Infof("Message sent (server timestamp: %s)", resp.Timestamp)
 
-			recipient, ok := parseJID(jid)
-			if !ok {
-				return
-			}
+	s.storeMessage(&ChatLogMessage{
+		MessageID:       resp.ID,
+		DeviceJID:       s.device.ID.String(),
+		RemoteJID:       recipient.String(),
+		Type:            "text",
+		Content:         text,
+		Timestamp:       resp.Timestamp,
+		Sent:            true,
+		UserID:          userID,
+		SenderJID:       s.device.ID.ToNonAD().String(),
+		QuotedMessageID: opts.QuotedMessageID,
+	})
 
-			msg := &waProto.Message{
-				Conversation: proto.String(textMsg),
-			}
-			log.Infof("Sending message to %s: %s", recipient, msg.GetConversation())
+	m := Message{resp.ID, recipient.String(), "text", text, true, "", s.ID()}
+	s.publishMessage(m)
 
-			resp, err := cli.SendMessage(context.Background(), recipient, msg)
-			if err != nil {
-				log.Errorf("Error sending message: %v", err)
-				return
-			}
+	return sendResult{MessageID: resp.ID, Recipient: recipient.String(), Timestamp: resp.Timestamp}, nil
+}
 
-			log.Infof("Message sent (server timestamp: %s)", resp.Timestamp)
-		}(jid)
-	}
-	wg.Wait()
+// sendAndStore sends a message directly, without the outbox, then stores and publishes it with the
+// given chat log type and content.
+func (s *Session) sendAndStore(recipient types.JID, msg *waProto.Message, msgType, content string, userID int) (*sendResult, error) {
+	resp, err := s.cli.SendMessage(context.Background(), recipient, msg)
+	if err != nil {
+		return nil, fmt.Errorf("error sending %s message: %w", msgType, err)
+	}
+	s.log.Infof("Sent %s message %s to %s (server timestamp: %s)", msgType, resp.ID, recipient, resp.Timestamp)
+
+	s.storeMessage(&ChatLogMessage{
+		MessageID:  resp.ID,
+		DeviceJID:  s.device.ID.String(),
+		RemoteJID:  recipient.String(),
+		Type:       msgType,
+		Content:    content,
+		Timestamp:  resp.Timestamp,
+		Sent:       true,
+		UserID:     userID,
+		SenderJID:  s.device.ID.ToNonAD().String(),
+		RawMessage: rawMessage(msg),
+	})
+
+	m := Message{resp.ID, recipient.String(), msgType, content, true, "", s.ID()}
+	s.publishMessage(m)
+	return &sendResult{MessageID: resp.ID, Recipient: recipient.String(), Timestamp: resp.Timestamp}, nil
 }
 
-func handleMarkRead(args []string) {
+func (s *Session) handleMarkRead(args []string) (interface{}, error) {
 	if len(args) < 2 {
-		log.Errorf("Usage: markread <message_id> <remote_jid>")
-		return
+		return nil, errors.New("usage: markread <message_id> <remote_jid>")
 	}
 
 	messageID := args[0]
 	remoteJID := args[1]
 
 	if remoteJID == "" {
-		log.Errorf("Invalid remote JID")
-		return
+		return nil, errors.New("invalid remote JID")
 	}
 
-	sender, ok := parseJID(remoteJID)
-	if !ok {
-		return
+	sender, err := parseJID(remoteJID)
+	if err != nil {
+		return nil, err
 	}
 
 	timestamp := time.Now()
 
-	if err := cli.MarkRead([]string{messageID}, timestamp, sender, sender); err != nil {
-		log.Errorf("Error marking read: %v", err)
-		return
+	if err := s.cli.MarkRead([]string{messageID}, timestamp, sender, sender); err != nil {
+		s.log.Errorf("Error marking read: %v", err)
+		return nil, fmt.Errorf("error marking read: %w", err)
 	}
-	log.Infof("MarkRead sent: %s %s %s", messageID, timestamp, sender)
+	s.log.Infof("MarkRead sent: %s %s %s", messageID, timestamp, sender)
 
-	if err := markMessageRead(messageID, remoteJID, timestamp); err != nil {
-		log.Errorf("Error marking message as read: %v", err)
+	if err := s.srv.chatLog.MarkRead(messageID, s.device.ID.String(), remoteJID, timestamp); err != nil {
+		s.log.Errorf("Error marking message as read: %v", err)
 	}
+	return map[string]interface{}{"message_id": messageID, "read_at": timestamp}, nil
 }
 
-func handleSendImage(JID string, userID int, data []byte, captionMsg string) error {
-	recipient, ok := parseJID(JID)
-	if !ok {
-		return fmt.Errorf("invalid JID")
+func (s *Session) handleSendImage(JID string, userID int, data []byte, captionMsg string) error {
+	recipient, err := parseJID(JID)
+	if err != nil {
+		return err
 	}
 
-	uploaded, err := cli.Upload(context.Background(), data, whatsmeow.MediaImage)
+	uploaded, err := s.cli.Upload(context.Background(), data, whatsmeow.MediaImage)
 	if err != nil {
 		return fmt.Errorf("failed to upload file: %v", err)
 	}
 
 	msg := createImageMessage(uploaded, &data, captionMsg)
-	resp, err := cli.SendMessage(context.Background(), recipient, msg)
+	resp, err := s.cli.SendMessage(context.Background(), recipient, msg)
 	if err != nil {
 		return fmt.Errorf("error sending image message: %v", err)
 	}
 
-	log.Infof("Image message sent (server timestamp: %s)", resp.Timestamp)
+	s.log.Infof("Image message sent (server timestamp: %s)", resp.Timestamp)
 
-	if err := insertMessages(resp.ID, cli.Store.ID.String(), recipient.String(), "", "media", resp.Timestamp, true, "", userID); err != nil {
+	stored := &ChatLogMessage{
+		MessageID:  resp.ID,
+		DeviceJID:  s.device.ID.String(),
+		RemoteJID:  recipient.String(),
+		Type:       "media",
+		Timestamp:  resp.Timestamp,
+		Sent:       true,
+		UserID:     userID,
+		RawMessage: rawMessage(msg),
+	}
+	if err := s.srv.chatLog.InsertMessage(stored); err != nil {
 		return fmt.Errorf("error inserting into messages: %v", err)
 	}
 
-	if err := insertLastMessages(resp.ID, cli.Store.ID.String(), recipient.String(), "", "media", resp.Timestamp, true, "", userID); err != nil {
+	if err := s.srv.chatLog.SetLastMessage(stored); err != nil {
 		return fmt.Errorf("error inserting into last_messages: %v", err)
 	}
 
-	saveImageToDisk(msg, data, resp.ID)
+	s.saveImageToDisk(msg, data, resp.ID)
 
-	if wsConn != nil {
-		m := Message{resp.ID, recipient.String(), "media", "", true, ""}
-		wsConn.WriteJSON(m)
-	}
+	m := Message{resp.ID, recipient.String(), "media", "", true, "", s.ID()}
+	s.publishMessage(m)
 
 	return nil
 }
 
-func handleSendDocument(JID string, fileName string, userID int, data []byte, captionMsg string) error {
-	recipient, ok := parseJID(JID)
-	if !ok {
-		return fmt.Errorf("invalid JID")
+func (s *Session) handleSendDocument(JID string, fileName string, userID int, data []byte, captionMsg string) error {
+	recipient, err := parseJID(JID)
+	if err != nil {
+		return err
 	}
 
-	uploaded, err := cli.Upload(context.Background(), data, whatsmeow.MediaDocument)
+	uploaded, err := s.cli.Upload(context.Background(), data, whatsmeow.MediaDocument)
 	if err != nil {
 		return fmt.Errorf("failed to upload file: %v", err)
 	}
 
 	msg := createDocumentMessage(fileName, uploaded, &data, captionMsg)
-	resp, err := cli.SendMessage(context.Background(), recipient, msg)
+	resp, err := s.cli.SendMessage(context.Background(), recipient, msg)
 	if err != nil {
 		return fmt.Errorf("error sending document message: %v", err)
 	}
 
-	log.Infof("Document message sent (server timestamp: %s)", resp.Timestamp)
+	s.log.Infof("Document message sent (server timestamp: %s)", resp.Timestamp)
 
-	if err := insertMessages(resp.ID, cli.Store.ID.String(), recipient.String(), "", "media", resp.Timestamp, true, fileName, userID); err != nil {
+	stored := &ChatLogMessage{
+		MessageID:  resp.ID,
+		DeviceJID:  s.device.ID.String(),
+		RemoteJID:  recipient.String(),
+		Type:       "media",
+		Timestamp:  resp.Timestamp,
+		Sent:       true,
+		FileName:   fileName,
+		UserID:     userID,
+		RawMessage: rawMessage(msg),
+	}
+	if err := s.srv.chatLog.InsertMessage(stored); err != nil {
 		return fmt.Errorf("error inserting into messages: %v", err)
 	}
 
-	if err := insertLastMessages(resp.ID, cli.Store.ID.String(), recipient.String(), "", "media", resp.Timestamp, true, fileName, userID); err != nil {
+	if err := s.srv.chatLog.SetLastMessage(stored); err != nil {
 		return fmt.Errorf("error inserting into last_messages: %v", err)
 	}
 
-	saveDocumentToDisk(msg, data, resp.ID)
+	s.saveDocumentToDisk(msg, data, resp.ID)
 
-	if wsConn != nil {
-		m := Message{resp.ID, recipient.String(), "media", "", true, fileName}
-		wsConn.WriteJSON(m)
-	}
+	m := Message{resp.ID, recipient.String(), "media", "", true, fileName, s.ID()}
+	s.publishMessage(m)
 
 	return nil
 }
 
-func saveImageToDisk(msg *waProto.Message, data []byte, ID string) {
+func (s *Session) saveImageToDisk(msg *waProto.Message, data []byte, ID string) {
 	exts, err := mime.ExtensionsByType(msg.GetImageMessage().GetMimetype())
 	if err != nil {
-		log.Errorf("Error getting file extension: %v", err)
+		s.log.Errorf("Error getting file extension: %v", err)
 		return
 	}
 
 	if len(exts) == 0 {
-		log.Errorf("No file extension found for mimetype: %s", msg.GetImageMessage().GetMimetype())
+		s.log.Errorf("No file extension found for mimetype: %s", msg.GetImageMessage().GetMimetype())
 		return
 	}
 
 	extension := exts[0]
-	path := fmt.Sprintf("%s%s", ID, extension)
+	path, err := s.sentMediaPath(ID, extension)
+	if err != nil {
+		s.log.Errorf("Error saving file to disk: %v", err)
+		return
+	}
 
 	err = os.WriteFile(path, data, 0644)
 	if err != nil {
-		log.Errorf("Error saving file to disk: %v", err)
+		s.log.Errorf("Error saving file to disk: %v", err)
 		return
 	}
 
 	img, err := imaging.Decode(bytes.NewReader(data))
 	if err != nil {
-		log.Errorf("Error decoding image: %v", err)
+		s.log.Errorf("Error decoding image: %v", err)
 		return
 	}
 
 	thumbnail := imaging.Thumbnail(img, 100, 100, imaging.Lanczos)
 
-	thumbnailPath := fmt.Sprintf("%s%s", ID, ".jpg")
+	thumbnailPath := strings.TrimSuffix(path, extension) + ".jpg"
 	err = imaging.Save(thumbnail, thumbnailPath, imaging.JPEGQuality(20))
 	if err != nil {
-		log.Errorf("Error saving thumbnail to disk: %v", err)
+		s.log.Errorf("Error saving thumbnail to disk: %v", err)
 		return
 	}
 
-	log.Infof("Saved file to %s", path)
-	log.Infof("Saved thumbnail to %s", thumbnailPath)
+	s.log.Infof("Saved file to %s", path)
+	s.log.Infof("Saved thumbnail to %s", thumbnailPath)
 }
 
-func saveDocumentToDisk(msg *waProto.Message, data []byte, ID string) {
-	exts, err := mime.ExtensionsByType(msg.GetDocumentMessage().GetMimetype())
+// sentMediaPath returns the path of a sent file in the data directory, next to the downloaded
+// media of the session, and creates its directory.
+func (s *Session) sentMediaPath(ID, extension string) (string, error) {
+	path := filepath.Join(s.srv.dataDir, "media", safeFileName(s.device.ID.User), safeFileName(ID)+extension)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create media directory: %w", err)
+	}
+	return path, nil
+}
+
+func (s *Session) saveDocumentToDisk(msg *waProto.Message, data []byte, ID string) {
+	s.saveFileToDisk(msg.GetDocumentMessage().GetMimetype(), data, ID)
+}
+
+func (s *Session) saveFileToDisk(mimeType string, data []byte, ID string) {
+	exts, err := mime.ExtensionsByType(mimeType)
 	if err != nil {
-		log.Errorf("Error getting file extension: %v", err)
+		s.log.Errorf("Error getting file extension: %v", err)
 		return
 	}
 
 	if len(exts) == 0 {
-		log.Errorf("No file extension found for mimetype: %s", msg.GetDocumentMessage().GetMimetype())
+		s.log.Errorf("No file extension found for mimetype: %s", mimeType)
 		return
 	}
 
 	extension := exts[0]
-	path := fmt.Sprintf("%s%s", ID, extension)
+	path, err := s.sentMediaPath(ID, extension)
+	if err != nil {
+		s.log.Errorf("Error saving file to disk: %v", err)
+		return
+	}
 
 	err = os.WriteFile(path, data, 0644)
 	if err != nil {
-		log.Errorf("Error saving file to disk: %v", err)
+		s.log.Errorf("Error saving file to disk: %v", err)
 		return
 	}
 
-	log.Infof("Saved file to %s", path)
+	s.log.Infof("Saved file to %s", path)
 }
 
 func createImageMessage(uploaded whatsmeow.UploadResponse, data *[]byte, captionMsg string) *waProto.Message {
@@ -421,7 +528,7 @@ func validateStringArrayAsStringArray(stringInput string) ([]string, error) {
 	return stringSlice, nil
 }
 
-func newHandleSendImage(JIDS []string, data []byte, captionMsg string) ([]Message, error) {
+func (s *Session) newHandleSendImage(JIDS []string, data []byte, captionMsg string) ([]Message, error) {
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	var sliceM []Message
@@ -432,15 +539,15 @@ func newHandleSendImage(JIDS []string, data []byte, captionMsg string) ([]Messag
 		go func(jid string) {
 			defer wg.Done()
 
-			recipient, ok := parseJID(jid)
-			if !ok {
+			recipient, err := parseJID(jid)
+			if err != nil {
 				mu.Lock()
-				errs = append(errs, fmt.Errorf("invalid JID: %s", jid))
+				errs = append(errs, err)
 				mu.Unlock()
 				return
 			}
 
-			uploaded, err := cli.Upload(context.Background(), data, whatsmeow.MediaImage)
+			uploaded, err := s.cli.Upload(context.Background(), data, whatsmeow.MediaImage)
 			if err != nil {
 				mu.Lock()
 				errs = append(errs, fmt.Errorf("failed to upload file: %v", err))
@@ -449,7 +556,7 @@ func newHandleSendImage(JIDS []string, data []byte, captionMsg string) ([]Messag
 			}
 
 			msg := createImageMessage(uploaded, &data, captionMsg)
-			resp, err := cli.SendMessage(context.Background(), recipient, msg)
+			resp, err := s.cli.SendMessage(context.Background(), recipient, msg)
 			if err != nil {
 				mu.Lock()
 				errs = append(errs, fmt.Errorf("error sending image message: %v", err))
@@ -457,9 +564,10 @@ func newHandleSendImage(JIDS []string, data []byte, captionMsg string) ([]Messag
 				return
 			}
 
-			log.Infof("Image message sent (server timestamp: %s)", resp.Timestamp)
+			s.log.Infof("Image message sent (server timestamp: %s)", resp.Timestamp)
 
-			m := Message{resp.ID, recipient.String(), "media", "", true, ""}
+			m := Message{resp.ID, recipient.String(), "media", "", true, "", s.ID()}
+			s.publishMessage(m)
 			mu.Lock()
 			sliceM = append(sliceM, m)
 			mu.Unlock()
@@ -476,7 +584,7 @@ func newHandleSendImage(JIDS []string, data []byte, captionMsg string) ([]Messag
 	return sliceM, nil
 }
 
-func newHandleSendDocument(JID []string, fileName string, data []byte, captionMsg string) ([]Message, error) {
+func (s *Session) newHandleSendDocument(JID []string, fileName string, data []byte, captionMsg string) ([]Message, error) {
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	var sliceM []Message
@@ -487,15 +595,15 @@ func newHandleSendDocument(JID []string, fileName string, data []byte, captionMs
 		go func(jid, fileName string) {
 			defer wg.Done()
 
-			recipient, ok := parseJID(jid)
-			if !ok {
+			recipient, err := parseJID(jid)
+			if err != nil {
 				mu.Lock()
-				errs = append(errs, fmt.Errorf("invalid JID: %s", jid))
+				errs = append(errs, err)
 				mu.Unlock()
 				return
 			}
 
-			uploaded, err := cli.Upload(context.Background(), data, whatsmeow.MediaDocument)
+			uploaded, err := s.cli.Upload(context.Background(), data, whatsmeow.MediaDocument)
 			if err != nil {
 				mu.Lock()
 				errs = append(errs, fmt.Errorf("failed to upload file: %v", err))
@@ -504,7 +612,7 @@ func newHandleSendDocument(JID []string, fileName string, data []byte, captionMs
 			}
 
 			msg := createDocumentMessage(fileName, uploaded, &data, captionMsg)
-			resp, err := cli.SendMessage(context.Background(), recipient, msg)
+			resp, err := s.cli.SendMessage(context.Background(), recipient, msg)
 			if err != nil {
 				mu.Lock()
 				errs = append(errs, fmt.Errorf("error sending document message: %v", err))
@@ -512,9 +620,10 @@ func newHandleSendDocument(JID []string, fileName string, data []byte, captionMs
 				return
 			}
 
-			log.Infof("Document message sent (server timestamp: %s)", resp.Timestamp)
+			s.log.Infof("Document message sent (server timestamp: %s)", resp.Timestamp)
 
-			m := Message{resp.ID, recipient.String(), "media", "", true, fileName}
+			m := Message{resp.ID, recipient.String(), "media", "", true, fileName, s.ID()}
+			s.publishMessage(m)
 			mu.Lock()
 			sliceM = append(sliceM, m)
 			mu.Unlock()
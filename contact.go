@@ -0,0 +1,159 @@
+package whatsappws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Contact is a contact card to send. It's converted into a vCard, with a WhatsApp ID for every
+// phone number so that recipients can message the contact directly.
+type Contact struct {
+	Name         string   `json:"name"`
+	Phones       []string `json:"phones"`
+	Organization string   `json:"organization,omitempty"`
+	Email        string   `json:"email,omitempty"`
+}
+
+func (c *Contact) validate() error {
+	if c.Name == "" {
+		return errors.New("contact name is required")
+	} else if len(c.Phones) == 0 {
+		return fmt.Errorf("contact %s needs at least one phone number", c.Name)
+	}
+	for _, phone := range c.Phones {
+		if phoneDigits(phone) == "" {
+			return fmt.Errorf("invalid phone number %q of contact %s", phone, c.Name)
+		}
+	}
+	return nil
+}
+
+// phoneDigits returns the digits of a phone number, which are its WhatsApp ID.
+func phoneDigits(phone string) string {
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	return digits.String()
+}
+
+var vCardEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+
+// vCard returns the contact as a vCard 3.0.
+func (c *Contact) vCard() string {
+	var card strings.Builder
+	card.WriteString("BEGIN:VCARD\nVERSION:3.0\n")
+	fmt.Fprintf(&card, "N:;%s;;;\n", vCardEscaper.Replace(c.Name))
+	fmt.Fprintf(&card, "FN:%s\n", vCardEscaper.Replace(c.Name))
+	if c.Organization != "" {
+		fmt.Fprintf(&card, "ORG:%s\n", vCardEscaper.Replace(c.Organization))
+	}
+	for _, phone := range c.Phones {
+		fmt.Fprintf(&card, "TEL;type=CELL;type=VOICE;waid=%s:+%s\n", phoneDigits(phone), phoneDigits(phone))
+	}
+	if c.Email != "" {
+		fmt.Fprintf(&card, "EMAIL:%s\n", vCardEscaper.Replace(c.Email))
+	}
+	card.WriteString("END:VCARD")
+	return card.String()
+}
+
+// contactsMessage returns a ContactMessage for a single contact, or a ContactsArrayMessage for
+// several, and the chat log content listing their names.
+func contactsMessage(contacts []Contact) (*waProto.Message, string) {
+	names := make([]string, len(contacts))
+	messages := make([]*waProto.ContactMessage, len(contacts))
+	for i := range contacts {
+		names[i] = contacts[i].Name
+		messages[i] = &waProto.ContactMessage{
+			DisplayName: proto.String(contacts[i].Name),
+			Vcard:       proto.String(contacts[i].vCard()),
+		}
+	}
+	if len(contacts) == 1 {
+		return &waProto.Message{ContactMessage: messages[0]}, names[0]
+	}
+	return &waProto.Message{
+		ContactsArrayMessage: &waProto.ContactsArrayMessage{
+			DisplayName: proto.String(fmt.Sprintf("%d contacts", len(contacts))),
+			Contacts:    messages,
+		},
+	}, strings.Join(names, "\n")
+}
+
+func validateContacts(contacts []Contact) error {
+	if len(contacts) == 0 {
+		return errors.New("at least one contact is required")
+	}
+	for i := range contacts {
+		if err := contacts[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendContacts sends one or more contact cards.
+func (s *Session) sendContacts(recipient string, contacts []Contact, userID int) (*sendResult, error) {
+	jid, err := parseJID(recipient)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateContacts(contacts); err != nil {
+		return nil, err
+	}
+	msg, content := contactsMessage(contacts)
+	return s.sendAndStore(jid, msg, "contact", content, userID)
+}
+
+func (s *Session) handleSendContact(args []string, userID int) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errors.New("usage: contact <jid> <name> <phone> [phone...]")
+	}
+	return s.sendContacts(args[0], []Contact{{Name: args[1], Phones: args[2:]}}, userID)
+}
+
+// serveSendContact sends contact cards: POST /send-contact
+func (srv *Server) serveSendContact(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "POST")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	sess := srv.requestSession(r)
+	if sess == nil || !sess.cli.IsLoggedIn() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var body struct {
+		Recipient string    `json:"recipient" validate:"required"`
+		Contacts  []Contact `json:"contacts" validate:"required"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+	if _, err := parseJID(body.Recipient); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err = validateContacts(body.Contacts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := sess.sendContacts(body.Recipient, body.Contacts, boundUserID(requestIdentity(r), -1))
+	if err != nil {
+		srv.handleError(w, http.StatusInternalServerError, "Failed to send contact", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,59 @@
+package whatsappws
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSendContact(t *testing.T) {
+	chatLog := NewMemoryChatLog()
+	srv, fake := newTestServer(t, chatLog)
+
+	w := serveJSON(t, srv, http.MethodPost, "/send-contact", map[string]interface{}{
+		"recipient": "905550000000",
+		"contacts": []Contact{{
+			Name:         "Doe, John",
+			Phones:       []string{"+90 555 000 00 01", "(555) 000-0002"},
+			Organization: "Acme; Inc.",
+			Email:        "john@example.com",
+		}},
+	})
+	decodeResponse(t, w, http.StatusOK, nil)
+	w = serveJSON(t, srv, http.MethodPost, "/send-contact", map[string]interface{}{
+		"recipient": "905550000000",
+		"contacts":  []Contact{{Name: "Alice", Phones: []string{"905550000003"}}, {Name: "Bob", Phones: []string{"905550000004"}}},
+	})
+	decodeResponse(t, w, http.StatusOK, nil)
+
+	sent := fake.SentMessages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(sent))
+	}
+	contact := sent[0].Message.GetContactMessage()
+	wantVCard := "BEGIN:VCARD\nVERSION:3.0\n" +
+		"N:;Doe\\, John;;;\nFN:Doe\\, John\nORG:Acme\\; Inc.\n" +
+		"TEL;type=CELL;type=VOICE;waid=905550000001:+905550000001\n" +
+		"TEL;type=CELL;type=VOICE;waid=5550000002:+5550000002\n" +
+		"EMAIL:john@example.com\nEND:VCARD"
+	if contact.GetDisplayName() != "Doe, John" || contact.GetVcard() != wantVCard {
+		t.Errorf("sent contact is %q with vCard:\n%s", contact.GetDisplayName(), contact.GetVcard())
+	}
+	array := sent[1].Message.GetContactsArrayMessage()
+	if contacts := array.GetContacts(); array.GetDisplayName() != "2 contacts" || len(contacts) != 2 ||
+		contacts[0].GetDisplayName() != "Alice" || contacts[1].GetDisplayName() != "Bob" ||
+		contacts[1].GetVcard() != "BEGIN:VCARD\nVERSION:3.0\nN:;Bob;;;\nFN:Bob\nTEL;type=CELL;type=VOICE;waid=905550000004:+905550000004\nEND:VCARD" {
+		t.Errorf("sent contacts are %v", array)
+	}
+
+	messages := chatLog.Messages()
+	if len(messages) != 2 || messages[0].Type != "contact" || messages[0].Content != "Doe, John" || messages[1].Content != "Alice\nBob" {
+		t.Errorf("stored messages are %+v", messages)
+	}
+
+	w = serveJSON(t, srv, http.MethodPost, "/send-contact", map[string]interface{}{
+		"recipient": "905550000000", "contacts": []Contact{{Name: "Nobody", Phones: []string{"none"}}},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status of a contact without a valid phone number is %d, want 400", w.Code)
+	}
+}
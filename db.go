@@ -1,57 +1,733 @@
-package main
+package whatsappws
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
 )
 
-// InsertMessageHistory inserts a message history record into the database.
-func insertMessages(messageID, deviceJID, remoteJID, messageContent, messageType string, timestamp time.Time, sent bool, fileName string, userIDInteger int) error {
-	var userID *int
-	if userIDInteger == -1 {
-		userID = nil
-	} else {
-		userID = &userIDInteger
+// sqlChatLog stores the chat log in PostgreSQL or SQLite. The queries are shared by both dialects,
+// dialect specific queries check the dialect field.
+//
+// Timestamps are stored in UTC. SQLite stores them as text, so they only compare correctly if they
+// have the same offset.
+type sqlChatLog struct {
+	db      *sql.DB
+	dialect string
+	log     waLog.Logger
+	// fts is set on SQLite if messages are indexed in messages_fts. Searches use LIKE otherwise.
+	fts bool
+}
+
+func newPostgresChatLog(db *sql.DB, log waLog.Logger) *sqlChatLog {
+	return &sqlChatLog{db: db, dialect: "postgres", log: log}
+}
+
+func newSQLiteChatLog(db *sql.DB, log waLog.Logger) (*sqlChatLog, error) {
+	s := &sqlChatLog{db: db, dialect: "sqlite3", log: log}
+	var err error
+	if s.fts, err = setUpSQLiteSearch(db, log); err != nil {
+		return nil, fmt.Errorf("failed to set up message search: %w", err)
 	}
-	_, err := db.Exec(`
-		INSERT INTO messages (message_id, device_jid, remote_jid, type, content, timestamp, sent, file_name, user_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-    `, messageID, deviceJID, remoteJID, messageType, messageContent, timestamp, sent, fileName, userID)
+	return s, nil
+}
+
+func nullableUserID(userID int) *int {
+	if userID == -1 {
+		return nil
+	}
+	return &userID
+}
+
+// InsertMessage inserts a message history record into the database.
+func (s *sqlChatLog) InsertMessage(msg *ChatLogMessage) error {
+	_, err := s.db.Exec(`
+		INSERT INTO messages (message_id, device_jid, remote_jid, type, content, timestamp, sent, file_name, user_id, sender_jid, quoted_message_id, raw_message)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+    `, msg.MessageID, msg.DeviceJID, msg.RemoteJID, msg.Type, msg.Content, msg.Timestamp.UTC(), msg.Sent, msg.FileName, nullableUserID(msg.UserID), msg.SenderJID, msg.QuotedMessageID, msg.RawMessage)
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
-	log.Infof("Inserted into messages: %d, %s, %s, %s, %s", messageID, deviceJID, remoteJID, messageContent, timestamp)
+	s.log.Infof("Inserted into messages: %s, %s, %s, %s, %s", msg.MessageID, msg.DeviceJID, msg.RemoteJID, msg.Content, msg.Timestamp)
 	return nil
 }
 
-// InsertOrUpdateLastMessage inserts or updates the last message for a remote JID in the database.
-func insertLastMessages(messageID, deviceJID, remoteJID, messageContent, messageType string, timestamp time.Time, sent bool, fileName string, userIDInteger int) error {
-	var userID *int
-	if userIDInteger == -1 {
-		userID = nil
-	} else {
-		userID = &userIDInteger
+// SetLastMessage inserts or updates the last message for a remote JID in the database.
+func (s *sqlChatLog) SetLastMessage(msg *ChatLogMessage) error {
+	_, err := s.db.Exec(`
+		INSERT INTO last_messages (message_id, device_jid, remote_jid, type, content, timestamp, sent, file_name, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (device_jid, remote_jid)
+		DO UPDATE SET message_id = $1, device_jid = $2, type = $4, content = $5, timestamp = $6, sent = $7, file_name = $8, user_id = $9, revoked = false
+	`, msg.MessageID, msg.DeviceJID, msg.RemoteJID, msg.Type, msg.Content, msg.Timestamp.UTC(), msg.Sent, msg.FileName, nullableUserID(msg.UserID))
+	if err != nil {
+		return fmt.Errorf("%w", err)
 	}
-	_, err := db.Exec(`
+	s.log.Infof("Inserted into last_messages: %s, %s, %s, %s, %s", msg.MessageID, msg.DeviceJID, msg.RemoteJID, msg.Content, msg.Timestamp)
+	return nil
+}
+
+// SetLastMessageIfNewer is like SetLastMessage, but keeps the stored last message if it's newer.
+func (s *sqlChatLog) SetLastMessageIfNewer(msg *ChatLogMessage) error {
+	_, err := s.db.Exec(`
 		INSERT INTO last_messages (message_id, device_jid, remote_jid, type, content, timestamp, sent, file_name, user_id)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		ON CONFLICT (remote_jid)
-		DO UPDATE SET message_id = $1, device_jid = $2, type = $4, content = $5, timestamp = $6, sent = $7, file_name = $8, user_id = $9
-	`, messageID, deviceJID, remoteJID, messageType, messageContent, timestamp, sent, fileName, userID)
+		ON CONFLICT (device_jid, remote_jid)
+		DO UPDATE SET message_id = $1, device_jid = $2, type = $4, content = $5, timestamp = $6, sent = $7, file_name = $8, user_id = $9, revoked = false
+		WHERE last_messages.timestamp <= $6
+	`, msg.MessageID, msg.DeviceJID, msg.RemoteJID, msg.Type, msg.Content, msg.Timestamp.UTC(), msg.Sent, msg.FileName, nullableUserID(msg.UserID))
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// MessageExists reports whether a message is already stored.
+func (s *sqlChatLog) MessageExists(messageID, deviceJID, remoteJID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM messages WHERE message_id = $1 AND device_jid = $2 AND remote_jid = $3)
+	`, messageID, deviceJID, remoteJID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("%w", err)
+	}
+	return exists, nil
+}
+
+const messageColumns = `message_id, device_jid, remote_jid, type, content, timestamp, sent, file_name, user_id, sender_jid, quoted_message_id, read_at, delivered_at, played_at, edited_at, revoked`
+
+// scanChatLogMessage scans the messageColumns, followed by the extra columns.
+func scanChatLogMessage(row scannable, extra ...interface{}) (*ChatLogMessage, error) {
+	var msg ChatLogMessage
+	var userID sql.NullInt64
+	var readAt, deliveredAt, playedAt, editedAt sql.NullTime
+	dest := []interface{}{&msg.MessageID, &msg.DeviceJID, &msg.RemoteJID, &msg.Type, &msg.Content, &msg.Timestamp, &msg.Sent, &msg.FileName, &userID,
+		&msg.SenderJID, &msg.QuotedMessageID, &readAt, &deliveredAt, &playedAt, &editedAt, &msg.Revoked}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	msg.UserID = -1
+	if userID.Valid {
+		msg.UserID = int(userID.Int64)
+	}
+	for _, t := range []struct {
+		src sql.NullTime
+		dst **time.Time
+	}{{readAt, &msg.ReadAt}, {deliveredAt, &msg.DeliveredAt}, {playedAt, &msg.PlayedAt}, {editedAt, &msg.EditedAt}} {
+		if t.src.Valid {
+			ts := t.src.Time
+			*t.dst = &ts
+		}
+	}
+	return &msg, nil
+}
+
+// GetMessage returns a stored message, or errMessageNotFound.
+func (s *sqlChatLog) GetMessage(messageID, deviceJID, remoteJID string) (*ChatLogMessage, error) {
+	var raw []byte
+	msg, err := scanChatLogMessage(s.db.QueryRow(`
+		SELECT `+messageColumns+`, raw_message FROM messages WHERE message_id = $1 AND device_jid = $2 AND remote_jid = $3
+	`, messageID, deviceJID, remoteJID), &raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errMessageNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	msg.RawMessage = raw
+	return msg, nil
+}
+
+// EditMessage replaces the content of a stored message and of the last message of its chat, and
+// keeps the previous content in message_edits, in one transaction.
+func (s *sqlChatLog) EditMessage(messageID, deviceJID, remoteJID, content string, editedAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+		INSERT INTO message_edits (device_jid, remote_jid, message_id, content, edited_at)
+		SELECT device_jid, remote_jid, message_id, content, $1 FROM messages
+		WHERE message_id = $2 AND device_jid = $3 AND remote_jid = $4
+	`, editedAt.UTC(), messageID, deviceJID, remoteJID)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	} else if n, _ := res.RowsAffected(); n == 0 {
+		return errMessageNotFound
+	}
+	_, err = tx.Exec(`
+		UPDATE messages SET content = $1, edited_at = $2 WHERE message_id = $3 AND device_jid = $4 AND remote_jid = $5
+	`, content, editedAt.UTC(), messageID, deviceJID, remoteJID)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE last_messages SET content = $1 WHERE message_id = $2 AND device_jid = $3 AND remote_jid = $4
+	`, content, messageID, deviceJID, remoteJID)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	s.log.Infof("Edited message: %s, %s, %s", messageID, remoteJID, content)
+	return nil
+}
+
+// RevokeMessage flags a stored message and the last message of its chat as deleted for everyone,
+// in one transaction.
+func (s *sqlChatLog) RevokeMessage(messageID, deviceJID, remoteJID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+		UPDATE messages SET revoked = true WHERE message_id = $1 AND device_jid = $2 AND remote_jid = $3
+	`, messageID, deviceJID, remoteJID)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	} else if n, _ := res.RowsAffected(); n == 0 {
+		return errMessageNotFound
+	}
+	_, err = tx.Exec(`
+		UPDATE last_messages SET revoked = true WHERE message_id = $1 AND device_jid = $2 AND remote_jid = $3
+	`, messageID, deviceJID, remoteJID)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	s.log.Infof("Revoked message: %s, %s", messageID, remoteJID)
+	return nil
+}
+
+func (s *sqlChatLog) MarkRead(messageID, deviceJID, remoteJID string, timestamp time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE messages SET read_at = $1 WHERE message_id = $2 AND device_jid = $3 AND remote_jid = $4
+	`, timestamp.UTC(), messageID, deviceJID, remoteJID)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	s.log.Infof("Marked message as read: %s, %s, %s", messageID, remoteJID, timestamp)
+	return nil
+}
+
+// MarkReceipt records the first delivered, read or played receipt of a sent message, and the
+// receipts it implies.
+func (s *sqlChatLog) MarkReceipt(messageID, deviceJID, remoteJID, receiptType string, timestamp time.Time) error {
+	columns, ok := receiptColumns[receiptType]
+	if !ok {
+		return fmt.Errorf("unknown receipt type %q", receiptType)
+	}
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = column + " = COALESCE(" + column + ", $1)"
+	}
+	_, err := s.db.Exec(`
+		UPDATE messages SET `+strings.Join(assignments, ", ")+`
+		WHERE message_id = $2 AND device_jid = $3 AND remote_jid = $4 AND sent = true
+	`, timestamp.UTC(), messageID, deviceJID, remoteJID)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// InsertReceipt records the receipt of a single group participant.
+func (s *sqlChatLog) InsertReceipt(receipt *ChatLogReceipt) error {
+	_, err := s.db.Exec(`
+		INSERT INTO message_receipts (device_jid, remote_jid, message_id, participant, type, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT DO NOTHING
+	`, receipt.DeviceJID, receipt.RemoteJID, receipt.MessageID, receipt.Participant, receipt.Type, receipt.Timestamp.UTC())
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// SetReaction stores or removes the reaction of a reactor to a message.
+func (s *sqlChatLog) SetReaction(reaction *ChatLogReaction) error {
+	var err error
+	if reaction.Reaction == "" {
+		_, err = s.db.Exec(`
+			DELETE FROM reactions WHERE device_jid = $1 AND remote_jid = $2 AND message_id = $3 AND reactor_jid = $4
+		`, reaction.DeviceJID, reaction.RemoteJID, reaction.MessageID, reaction.ReactorJID)
+	} else {
+		_, err = s.db.Exec(`
+			INSERT INTO reactions (device_jid, remote_jid, message_id, reactor_jid, reaction, timestamp)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (device_jid, remote_jid, message_id, reactor_jid)
+			DO UPDATE SET reaction = $5, timestamp = $6
+		`, reaction.DeviceJID, reaction.RemoteJID, reaction.MessageID, reaction.ReactorJID, reaction.Reaction, reaction.Timestamp.UTC())
+	}
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// InsertPoll stores a poll and its options, unless it's already stored.
+func (s *sqlChatLog) InsertPoll(poll *ChatLogPoll) error {
+	res, err := s.db.Exec(`
+		INSERT INTO polls (device_jid, remote_jid, message_id, name, selectable_count, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT DO NOTHING
+	`, poll.DeviceJID, poll.RemoteJID, poll.MessageID, poll.Name, poll.SelectableCount, poll.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	} else if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	for i, option := range poll.Options {
+		_, err = s.db.Exec(`
+			INSERT INTO poll_options (device_jid, remote_jid, message_id, position, name)
+			VALUES ($1, $2, $3, $4, $5)
+		`, poll.DeviceJID, poll.RemoteJID, poll.MessageID, i, option)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+	s.log.Infof("Inserted into polls: %s, %s, %s, %v", poll.MessageID, poll.RemoteJID, poll.Name, poll.Options)
+	return nil
+}
+
+// GetPoll returns a stored poll, or errPollNotFound.
+func (s *sqlChatLog) GetPoll(messageID, deviceJID, remoteJID string) (*ChatLogPoll, error) {
+	poll := ChatLogPoll{MessageID: messageID, DeviceJID: deviceJID, RemoteJID: remoteJID}
+	err := s.db.QueryRow(`
+		SELECT name, selectable_count, created_at FROM polls WHERE device_jid = $1 AND remote_jid = $2 AND message_id = $3
+	`, deviceJID, remoteJID, messageID).Scan(&poll.Name, &poll.SelectableCount, &poll.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errPollNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	rows, err := s.db.Query(`
+		SELECT name FROM poll_options WHERE device_jid = $1 AND remote_jid = $2 AND message_id = $3 ORDER BY position
+	`, deviceJID, remoteJID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var option string
+		if err = rows.Scan(&option); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		poll.Options = append(poll.Options, option)
+	}
+	return &poll, rows.Err()
+}
+
+// SetPollVote stores the vote of a voter, unless a newer vote of the voter is stored.
+func (s *sqlChatLog) SetPollVote(vote *ChatLogPollVote) error {
+	options, err := json.Marshal(vote.Options)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO poll_votes (device_jid, remote_jid, message_id, voter_jid, options, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (device_jid, remote_jid, message_id, voter_jid)
+		DO UPDATE SET options = $5, timestamp = $6
+		WHERE poll_votes.timestamp <= $6
+	`, vote.DeviceJID, vote.RemoteJID, vote.MessageID, vote.VoterJID, string(options), vote.Timestamp.UTC())
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// PollVotes returns the latest vote of every voter in a poll.
+func (s *sqlChatLog) PollVotes(messageID, deviceJID, remoteJID string) ([]ChatLogPollVote, error) {
+	rows, err := s.db.Query(`
+		SELECT voter_jid, options, timestamp FROM poll_votes
+		WHERE device_jid = $1 AND remote_jid = $2 AND message_id = $3
+		ORDER BY timestamp
+	`, deviceJID, remoteJID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer rows.Close()
+	var votes []ChatLogPollVote
+	for rows.Next() {
+		vote := ChatLogPollVote{MessageID: messageID, DeviceJID: deviceJID, RemoteJID: remoteJID}
+		var options string
+		if err = rows.Scan(&vote.VoterJID, &options, &vote.Timestamp); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		if err = json.Unmarshal([]byte(options), &vote.Options); err != nil {
+			return nil, fmt.Errorf("invalid options of vote by %s: %w", vote.VoterJID, err)
+		}
+		votes = append(votes, vote)
+	}
+	return votes, rows.Err()
+}
+
+// SetGroup stores the info of a group, replacing the stored info. The invite link is kept.
+func (s *sqlChatLog) SetGroup(group *ChatLogGroup) error {
+	_, err := s.db.Exec(`
+		INSERT INTO groups (device_jid, group_jid, name, topic, owner_jid, announce, locked, participant_count, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (device_jid, group_jid)
+		DO UPDATE SET name = $3, topic = $4, owner_jid = $5, announce = $6, locked = $7, participant_count = $8, created_at = $9, updated_at = $10
+	`, group.DeviceJID, group.GroupJID, group.Name, group.Topic, group.OwnerJID, group.Announce, group.Locked, group.ParticipantCount, group.CreatedAt.UTC(), group.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	s.log.Infof("Inserted into groups: %s, %s, %s", group.DeviceJID, group.GroupJID, group.Name)
+	return nil
+}
+
+// SetGroupInviteLink stores the current invite link of a stored group.
+func (s *sqlChatLog) SetGroupInviteLink(deviceJID, groupJID, inviteLink string) error {
+	_, err := s.db.Exec(`
+		UPDATE groups SET invite_link = $1 WHERE device_jid = $2 AND group_jid = $3
+	`, inviteLink, deviceJID, groupJID)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// UpdateGroup applies a change of the group info to a stored group.
+func (s *sqlChatLog) UpdateGroup(deviceJID, groupJID string, change *ChatLogGroupChange) error {
+	_, err := s.db.Exec(`
+		UPDATE groups SET name = COALESCE($1, name), topic = COALESCE($2, topic), announce = COALESCE($3, announce),
+			locked = COALESCE($4, locked), updated_at = $5
+		WHERE device_jid = $6 AND group_jid = $7
+	`, change.Name, change.Topic, change.Announce, change.Locked, time.Now().UTC(), deviceJID, groupJID)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// SetGroupParticipants replaces the participants of a group.
+func (s *sqlChatLog) SetGroupParticipants(deviceJID, groupJID string, participants []ChatLogGroupParticipant) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
-	log.Infof("Inserted into last_messages: %d, %s, %s, %s, %s", messageID, deviceJID, remoteJID, messageContent, timestamp)
+	defer tx.Rollback()
+	if _, err = tx.Exec(`DELETE FROM group_participants WHERE device_jid = $1 AND group_jid = $2`, deviceJID, groupJID); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	for _, participant := range participants {
+		_, err = tx.Exec(`
+			INSERT INTO group_participants (device_jid, group_jid, participant_jid, admin, super_admin)
+			VALUES ($1, $2, $3, $4, $5)
+		`, deviceJID, groupJID, participant.ParticipantJID, participant.Admin, participant.SuperAdmin)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
 	return nil
 }
 
-func markMessageRead(messageID, remoteJID string, timestamp time.Time) error {
-	_, err := db.Exec(`
-		UPDATE messages SET read_at = $1 WHERE message_id = $2 AND remote_jid = $3
-	`, timestamp, messageID, remoteJID)
+// ChangeGroupParticipants adds, removes, promotes or demotes participants of a group, and updates
+// the participant count of the stored group.
+func (s *sqlChatLog) ChangeGroupParticipants(deviceJID, groupJID, change string, participantJIDs []string) error {
+	var query string
+	switch change {
+	case "join":
+		query = `INSERT INTO group_participants (device_jid, group_jid, participant_jid) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+	case "leave":
+		query = `DELETE FROM group_participants WHERE device_jid = $1 AND group_jid = $2 AND participant_jid = $3`
+	case "promote":
+		query = `UPDATE group_participants SET admin = true WHERE device_jid = $1 AND group_jid = $2 AND participant_jid = $3`
+	case "demote":
+		query = `UPDATE group_participants SET admin = false, super_admin = false WHERE device_jid = $1 AND group_jid = $2 AND participant_jid = $3`
+	default:
+		return fmt.Errorf("unknown participant change %q", change)
+	}
+	for _, participantJID := range participantJIDs {
+		if _, err := s.db.Exec(query, deviceJID, groupJID, participantJID); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+	_, err := s.db.Exec(`
+		UPDATE groups SET participant_count = (
+			SELECT COUNT(*) FROM group_participants WHERE device_jid = $1 AND group_jid = $2
+		), updated_at = $3
+		WHERE device_jid = $1 AND group_jid = $2
+	`, deviceJID, groupJID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// Chats returns the chats of a device from last_messages, most recent first. Group events aren't
+// counted as unread.
+func (s *sqlChatLog) Chats(deviceJID string, before *ChatLogChatCursor, limit int) ([]ChatLogChat, *ChatLogChatCursor, error) {
+	var args sqlArgs
+	conditions := []string{"l.device_jid = " + args.add(deviceJID)}
+	if before != nil {
+		timestamp := args.add(before.Timestamp.UTC())
+		conditions = append(conditions, fmt.Sprintf("(l.timestamp < %s OR (l.timestamp = %s AND l.remote_jid < %s))", timestamp, timestamp, args.add(before.RemoteJID)))
+	}
+	rows, err := s.db.Query(`
+		SELECT l.message_id, l.remote_jid, l.type, l.content, l.timestamp, l.sent, l.file_name, l.user_id, l.revoked, COALESCE(g.name, ''),
+			(SELECT COUNT(*) FROM messages m
+			WHERE m.device_jid = l.device_jid AND m.remote_jid = l.remote_jid AND m.sent = false AND m.read_at IS NULL AND m.type <> 'group_event')
+		FROM last_messages l
+		LEFT JOIN groups g ON g.device_jid = l.device_jid AND g.group_jid = l.remote_jid
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY l.timestamp DESC, l.remote_jid DESC
+		LIMIT `+args.add(limit+1), args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+	defer rows.Close()
+	chats := []ChatLogChat{}
+	for rows.Next() {
+		var chat ChatLogChat
+		msg := &chat.LastMessage
+		var userID sql.NullInt64
+		err = rows.Scan(&msg.MessageID, &msg.RemoteJID, &msg.Type, &msg.Content, &msg.Timestamp, &msg.Sent, &msg.FileName, &userID, &msg.Revoked,
+			&chat.Name, &chat.UnreadCount)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w", err)
+		}
+		msg.DeviceJID = deviceJID
+		msg.UserID = -1
+		if userID.Valid {
+			msg.UserID = int(userID.Int64)
+		}
+		chat.RemoteJID = msg.RemoteJID
+		chats = append(chats, chat)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+	chats, next := chatsPage(chats, limit)
+	return chats, next, nil
+}
+
+// sqlArgs collects the arguments of a query that is built at runtime.
+type sqlArgs []interface{}
+
+// add appends an argument and returns its placeholder.
+func (a *sqlArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// qualifiedMessageColumns are the messageColumns of messages aliased as m.
+var qualifiedMessageColumns = "m." + strings.ReplaceAll(messageColumns, ", ", ", m.")
+
+// conditions returns the conditions of the query on messages aliased as m, except for the chat,
+// which is optional for searches.
+func (q *ChatLogMessageQuery) conditions(args *sqlArgs) []string {
+	conditions := []string{"m.device_jid = " + args.add(q.DeviceJID)}
+	if len(q.Types) > 0 {
+		placeholders := make([]string, len(q.Types))
+		for i, msgType := range q.Types {
+			placeholders[i] = args.add(msgType)
+		}
+		conditions = append(conditions, "m.type IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if !q.Since.IsZero() {
+		conditions = append(conditions, "m.timestamp >= "+args.add(q.Since.UTC()))
+	}
+	if !q.Until.IsZero() {
+		conditions = append(conditions, "m.timestamp < "+args.add(q.Until.UTC()))
+	}
+	if q.Before != nil {
+		timestamp := args.add(q.Before.Timestamp.UTC())
+		conditions = append(conditions, fmt.Sprintf("(m.timestamp < %s OR (m.timestamp = %s AND m.id < %s))", timestamp, timestamp, args.add(q.Before.ID)))
+	}
+	return conditions
+}
+
+// ChatMessages returns the messages of a chat matching the query, newest first. Messages with the
+// same timestamp are ordered by id, which the cursor includes.
+func (s *sqlChatLog) ChatMessages(query *ChatLogMessageQuery) ([]ChatLogMessage, *ChatLogCursor, error) {
+	var args sqlArgs
+	conditions := append(query.conditions(&args), "m.remote_jid = "+args.add(query.RemoteJID))
+	rows, err := s.db.Query(`
+		SELECT `+qualifiedMessageColumns+`, m.id FROM messages m
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY m.timestamp DESC, m.id DESC
+		LIMIT `+args.add(query.Limit+1), args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+	defer rows.Close()
+	messages := []ChatLogMessage{}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		msg, err := scanChatLogMessage(rows, &id)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w", err)
+		}
+		messages = append(messages, *msg)
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+	// One more message than the limit is selected to tell whether there's a next page.
+	var next *ChatLogCursor
+	if len(messages) > query.Limit {
+		messages = messages[:query.Limit]
+		next = &ChatLogCursor{Timestamp: messages[query.Limit-1].Timestamp, ID: ids[query.Limit-1]}
+	}
+	return messages, next, nil
+}
+
+// SearchMessages searches the content of messages with the messages_content_search_idx index on
+// Postgres and the messages_fts table on SQLite, newest first. Without messages_fts, every word
+// must be contained in the content, ignoring the case of ASCII letters, and the whole content is
+// highlighted as the snippet.
+func (s *sqlChatLog) SearchMessages(query *ChatLogSearchQuery) ([]ChatLogSearchResult, *ChatLogCursor, error) {
+	var args sqlArgs
+	var from, snippet, match string
+	var highlighter *regexp.Regexp
+	switch {
+	case s.dialect == "postgres":
+		text := args.add(query.Text)
+		from = "messages m"
+		snippet = fmt.Sprintf("ts_headline('simple', m.content, plainto_tsquery('simple', %s), 'StartSel=%s, StopSel=%s, MaxFragments=2')", text, highlightStart, highlightEnd)
+		match = fmt.Sprintf("to_tsvector('simple', m.content) @@ plainto_tsquery('simple', %s)", text)
+	case s.fts:
+		from = "messages_fts JOIN messages m ON m.id = messages_fts.rowid"
+		snippet = fmt.Sprintf("snippet(messages_fts, 0, '%s', '%s', '…', 16)", highlightStart, highlightEnd)
+		match = "messages_fts MATCH " + args.add(ftsQuery(query.Text))
+	default:
+		from = "messages m"
+		snippet = "m.content"
+		words := strings.Fields(query.Text)
+		for i, word := range words {
+			words[i] = "m.content LIKE " + args.add("%"+likeEscaper.Replace(word)+"%") + ` ESCAPE '\'`
+		}
+		match = strings.Join(words, " AND ")
+		highlighter = searchHighlighter(query.Text)
+	}
+	conditions := append([]string{match}, query.conditions(&args)...)
+	if query.RemoteJID != "" {
+		conditions = append(conditions, "m.remote_jid = "+args.add(query.RemoteJID))
+	}
+	if query.Sent != nil {
+		conditions = append(conditions, "m.sent = "+args.add(*query.Sent))
+	}
+	rows, err := s.db.Query(`
+		SELECT `+qualifiedMessageColumns+`, m.id, `+snippet+` FROM `+from+`
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY m.timestamp DESC, m.id DESC
+		LIMIT `+args.add(query.Limit+1), args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+	defer rows.Close()
+	results := []ChatLogSearchResult{}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var snippet string
+		msg, err := scanChatLogMessage(rows, &id, &snippet)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w", err)
+		}
+		if highlighter != nil {
+			snippet = highlight(highlighter, snippet)
+		}
+		results = append(results, ChatLogSearchResult{ChatLogMessage: *msg, Snippet: snippet})
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+	var next *ChatLogCursor
+	if len(results) > query.Limit {
+		results = results[:query.Limit]
+		next = &ChatLogCursor{Timestamp: results[query.Limit-1].Timestamp, ID: ids[query.Limit-1]}
+	}
+	return results, next, nil
+}
+
+// ftsQuery turns search text into an FTS5 query that matches messages containing every word, or a
+// word starting with it. The words are quoted so that FTS5 operators are searched for literally.
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"*`
+	}
+	return strings.Join(words, " ")
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns, with \ as the escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// InsertMedia stores the media of a message, unless it's already stored.
+func (s *sqlChatLog) InsertMedia(media *ChatLogMedia) error {
+	_, err := s.db.Exec(`
+		INSERT INTO media (device_jid, remote_jid, message_id, sender_jid, from_me, media_type, mimetype, file_name, direct_path, media_key,
+			file_enc_sha256, file_sha256, file_length, thumbnail, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		ON CONFLICT (device_jid, remote_jid, message_id) DO NOTHING
+	`, media.DeviceJID, media.RemoteJID, media.MessageID, media.SenderJID, media.FromMe, media.MediaType, media.MimeType, media.FileName, media.DirectPath, media.MediaKey,
+		media.FileEncSHA256, media.FileSHA256, media.FileLength, media.Thumbnail, media.Status, media.CreatedAt.UTC(), media.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// GetMedia returns the stored media of a message, or errMediaNotFound. Without remoteJID, the
+// newest media with the message ID is returned.
+func (s *sqlChatLog) GetMedia(deviceJID, remoteJID, messageID string) (*ChatLogMedia, error) {
+	media := ChatLogMedia{DeviceJID: deviceJID, MessageID: messageID}
+	var downloadedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT remote_jid, sender_jid, from_me, media_type, mimetype, file_name, direct_path, media_key, file_enc_sha256, file_sha256, file_length,
+			thumbnail, path, status, error, created_at, updated_at, downloaded_at
+		FROM media
+		WHERE device_jid = $1 AND message_id = $2 AND ($3 = '' OR remote_jid = $3)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, deviceJID, messageID, remoteJID).Scan(&media.RemoteJID, &media.SenderJID, &media.FromMe, &media.MediaType, &media.MimeType, &media.FileName,
+		&media.DirectPath, &media.MediaKey, &media.FileEncSHA256, &media.FileSHA256, &media.FileLength, &media.Thumbnail, &media.Path, &media.Status,
+		&media.Error, &media.CreatedAt, &media.UpdatedAt, &downloadedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errMediaNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	if downloadedAt.Valid {
+		media.DownloadedAt = &downloadedAt.Time
+	}
+	return &media, nil
+}
+
+// UpdateMedia stores the direct path, path, status, error and download time of stored media.
+func (s *sqlChatLog) UpdateMedia(media *ChatLogMedia) error {
+	var downloadedAt *time.Time
+	if media.DownloadedAt != nil {
+		t := media.DownloadedAt.UTC()
+		downloadedAt = &t
+	}
+	_, err := s.db.Exec(`
+		UPDATE media SET direct_path = $1, path = $2, status = $3, error = $4, updated_at = $5, downloaded_at = $6
+		WHERE device_jid = $7 AND remote_jid = $8 AND message_id = $9
+	`, media.DirectPath, media.Path, media.Status, media.Error, media.UpdatedAt.UTC(), downloadedAt, media.DeviceJID, media.RemoteJID, media.MessageID)
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
-	log.Infof("Marked message as read: %d, %s, %s", messageID, remoteJID, timestamp)
 	return nil
 }
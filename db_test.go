@@ -0,0 +1,126 @@
+package whatsappws
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// newTestSQLChatLog returns a SQL chat log on a migrated in-memory SQLite database.
+func newTestSQLChatLog(t *testing.T) *sqlChatLog {
+	t.Helper()
+	db := openTestDB(t)
+	if err := MigrateChatLog(db, "sqlite3", waLog.Noop); err != nil {
+		t.Fatal(err)
+	}
+	store, err := newSQLiteChatLog(db, waLog.Noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSQLMarkReceipt(t *testing.T) {
+	store := newTestSQLChatLog(t)
+	deviceJID := testPhone + "@s.whatsapp.net"
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) *time.Time {
+		ts := start.Add(time.Duration(minutes) * time.Minute)
+		return &ts
+	}
+
+	for n, test := range []struct {
+		receipts                []string
+		delivered, read, played *time.Time
+	}{
+		{[]string{"delivered"}, at(1), nil, nil},
+		{[]string{"read"}, at(1), at(1), nil},
+		{[]string{"played"}, at(1), at(1), at(1)},
+		{[]string{"delivered", "read", "played"}, at(1), at(2), at(3)},
+		// Later receipts don't replace the first one.
+		{[]string{"read", "delivered", "read"}, at(1), at(1), nil},
+	} {
+		id := fmt.Sprintf("MSG%d", n)
+		err := store.InsertMessage(&ChatLogMessage{
+			MessageID: id,
+			DeviceJID: deviceJID,
+			RemoteJID: testChat.String(),
+			Type:      "text",
+			Content:   "Hello",
+			Timestamp: start,
+			Sent:      true,
+			UserID:    -1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, receiptType := range test.receipts {
+			if err = store.MarkReceipt(id, deviceJID, testChat.String(), receiptType, *at(i + 1)); err != nil {
+				t.Fatalf("%v: %s receipt failed: %v", test.receipts, receiptType, err)
+			}
+		}
+		msg, err := store.GetMessage(id, deviceJID, testChat.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, column := range []struct {
+			name      string
+			got, want *time.Time
+		}{{"delivered_at", msg.DeliveredAt, test.delivered}, {"read_at", msg.ReadAt, test.read}, {"played_at", msg.PlayedAt, test.played}} {
+			if (column.got == nil) != (column.want == nil) || column.got != nil && !column.got.Equal(*column.want) {
+				t.Errorf("%v: %s is %v, want %v", test.receipts, column.name, column.got, column.want)
+			}
+		}
+	}
+
+	if err := store.MarkReceipt("MISSING", deviceJID, testChat.String(), "seen", start); err == nil {
+		t.Error("unknown receipt type was accepted")
+	}
+	// Postgres rejects an UPDATE that assigns a column twice, SQLite doesn't.
+	for receiptType, columns := range receiptColumns {
+		seen := make(map[string]bool)
+		for _, column := range columns {
+			if seen[column] {
+				t.Errorf("%s receipts set %s twice", receiptType, column)
+			}
+			seen[column] = true
+		}
+	}
+}
+
+func TestSQLEditMessageRollsBack(t *testing.T) {
+	store := newTestSQLChatLog(t)
+	deviceJID := testPhone + "@s.whatsapp.net"
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	msg := &ChatLogMessage{MessageID: "EDITED", DeviceJID: deviceJID, RemoteJID: testChat.String(), Type: "text", Content: "Helo", Timestamp: start, UserID: -1}
+	if err := store.InsertMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EditMessage("MISSING", deviceJID, testChat.String(), "Hello", start); err != errMessageNotFound {
+		t.Errorf("edit of a missing message returned %v", err)
+	}
+
+	// The last statements of the edit and the revoke fail without last_messages.
+	if _, err := store.db.Exec(`ALTER TABLE last_messages RENAME TO last_messages_gone`); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EditMessage("EDITED", deviceJID, testChat.String(), "Hello", start.Add(time.Minute)); err == nil {
+		t.Fatal("edit succeeded without last_messages")
+	}
+	if err := store.RevokeMessage("EDITED", deviceJID, testChat.String()); err == nil {
+		t.Fatal("revoke succeeded without last_messages")
+	}
+	var edits int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM message_edits`).Scan(&edits); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.GetMessage("EDITED", deviceJID, testChat.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edits != 0 || stored.Content != "Helo" || stored.EditedAt != nil || stored.Revoked {
+		t.Errorf("failed edit and revoke left %d edits and message %+v", edits, stored)
+	}
+}
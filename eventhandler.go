@@ -1,48 +1,48 @@
-package main
+package whatsappws
 
 import (
-	"encoding/json"
 	"fmt"
-	"mime"
-	"os"
 	"strings"
-	"sync/atomic"
+	"time"
 
 	"go.mau.fi/whatsmeow/appstate"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
 )
 
-func handleAppStateSyncComplete(evt *events.AppStateSyncComplete) {
-	if len(cli.Store.PushName) > 0 && evt.Name == appstate.WAPatchCriticalBlock {
-		err := cli.SendPresence(types.PresenceAvailable)
+func (s *Session) handleAppStateSyncComplete(evt *events.AppStateSyncComplete) {
+	if len(s.device.PushName) > 0 && evt.Name == appstate.WAPatchCriticalBlock {
+		err := s.cli.SendPresence(types.PresenceAvailable)
 		if err != nil {
-			log.Warnf("Failed to send available presence: %v", err)
+			s.log.Warnf("Failed to send available presence: %v", err)
 		} else {
-			log.Infof("Marked self as available")
+			s.log.Infof("Marked self as available")
 		}
 	}
 }
 
-func handleConnectedOrPushNameSetting(evt interface{}) {
-	if len(cli.Store.PushName) == 0 {
+func (s *Session) handleConnectedOrPushNameSetting(evt interface{}) {
+	if len(s.device.PushName) == 0 {
 		return
 	}
 	// Send presence available when connecting and when the pushname is changed.
 	// This makes sure that outgoing messages always have the right pushname.
-	err := cli.SendPresence(types.PresenceAvailable)
+	err := s.cli.SendPresence(types.PresenceAvailable)
 	if err != nil {
-		log.Warnf("Failed to send available presence: %v", err)
+		s.log.Warnf("Failed to send available presence: %v", err)
 	} else {
-		log.Infof("Marked self as available")
+		s.log.Infof("Marked self as available")
 	}
 }
 
-func handleStreamReplaced(evt *events.StreamReplaced) {
-	os.Exit(0)
+func (s *Session) handleStreamReplaced(evt *events.StreamReplaced) {
+	// Another client took over this account. Only this session stops, the others keep running.
+	s.log.Warnf("Session %s was replaced by another connection, disconnecting", s.ID())
+	s.cli.Disconnect()
 }
 
-func handleMessage(evt *events.Message) {
+func (s *Session) handleMessage(evt *events.Message) {
 	metaParts := []string{fmt.Sprintf("pushname: %s", evt.Info.PushName), fmt.Sprintf("timestamp: %s", evt.Info.Timestamp)}
 	if evt.Info.Type != "" {
 		metaParts = append(metaParts, fmt.Sprintf("type: %s", evt.Info.Type))
@@ -66,193 +66,276 @@ func handleMessage(evt *events.Message) {
 		metaParts = append(metaParts, "edit")
 	}
 
-	log.Infof("Received message %s from %s (%s): %+v", evt.Info.ID, evt.Info.SourceString(), strings.Join(metaParts, ", "), evt.Message)
+	s.log.Infof("Received message %s from %s (%s): %+v", evt.Info.ID, evt.Info.SourceString(), strings.Join(metaParts, ", "), evt.Message)
 
 	if evt.Message.GetProtocolMessage() != nil {
+		s.handleProtocolMessage(evt)
 		return
 	}
 
 	if evt.Message.GetPollUpdateMessage() != nil {
-		decrypted, err := cli.DecryptPollVote(evt)
+		decrypted, err := s.cli.DecryptPollVote(evt)
 		if err != nil {
-			log.Errorf("Failed to decrypt vote: %v", err)
+			s.log.Errorf("Failed to decrypt vote: %v", err)
 		} else {
-			log.Infof("Selected options in decrypted vote:")
-			for _, option := range decrypted.SelectedOptions {
-				log.Infof("- %X", option)
-			}
+			s.handlePollVote(evt, decrypted)
 		}
+		return
 	} else if evt.Message.GetEncReactionMessage() != nil {
-		decrypted, err := cli.DecryptReaction(evt)
+		decrypted, err := s.cli.DecryptReaction(evt)
 		if err != nil {
-			log.Errorf("Failed to decrypt encrypted reaction: %v", err)
+			s.log.Errorf("Failed to decrypt encrypted reaction: %v", err)
 		} else {
-			log.Infof("Decrypted reaction: %+v", decrypted)
+			s.handleReaction(evt, decrypted)
 		}
+		return
+	} else if evt.Message.GetReactionMessage() != nil {
+		s.handleReaction(evt, evt.Message.GetReactionMessage())
+		return
 	}
 
-	var extension string
-	var fileName string
-
-	if img := evt.Message.GetImageMessage(); img != nil {
-		data, err := cli.Download(img)
-		if err != nil {
-			log.Errorf("Failed to download image: %v", err)
-			return
-		}
-		exts, _ := mime.ExtensionsByType(img.GetMimetype())
-		extension = exts[0]
-		path := fmt.Sprintf("%s%s", evt.Info.ID, extension)
-		_ = os.WriteFile(path, data, 0644)
-
-		path = fmt.Sprintf("%s%s", evt.Info.ID, ".jpg")
-		err = os.WriteFile(path, img.GetJpegThumbnail(), 0644)
+	msg := s.chatLogMessage(evt)
+	if msg == nil {
+		return
+	}
 
-		if err != nil {
-			log.Errorf("Failed to save image: %v", err)
-			return
-		}
-		log.Infof("Saved image message to %s", path)
+	if poll := pollCreation(evt.Message); poll != nil {
+		s.storePoll(evt.Info.ID, evt.Info.Chat, poll, evt.Info.Timestamp)
 	}
-	if doc := evt.Message.GetDocumentMessage(); doc != nil {
-		data, err := cli.Download(doc)
-		if err != nil {
-			log.Errorf("Failed to download document: %v", err)
-			return
-		}
-		exts, _ := mime.ExtensionsByType(doc.GetMimetype())
-		extension = exts[0]
-		path := fmt.Sprintf("%s%s", evt.Info.ID, extension)
-		err = os.WriteFile(path, data, 0644)
-		if err != nil {
-			log.Errorf("Failed to save document: %v", err)
-			return
-		}
 
-		fileName = doc.GetFileName()
+	media := s.storeMedia(&evt.Info, evt.Message)
 
-		path = fmt.Sprintf("%s%s", evt.Info.ID, ".jpg")
-		err = os.WriteFile(path, doc.GetJpegThumbnail(), 0644)
+	s.storeMessage(msg)
 
-		if err != nil {
-			log.Errorf("Failed to save document: %v", err)
-			return
-		}
-		log.Infof("Saved document message to %s", path)
+	m := Message{msg.MessageID, msg.RemoteJID, msg.Type, msg.Content, msg.Sent, msg.FileName, s.ID()}
+	s.publishMessage(m)
+
+	if media != nil {
+		s.downloadMediaInBackground(media)
 	}
+}
 
-	if audio := evt.Message.GetAudioMessage(); audio != nil {
-		data, err := cli.Download(audio)
-		if err != nil {
-			log.Errorf("Failed to download audio: %v", err)
-			return
-		}
-		exts, _ := mime.ExtensionsByType(audio.GetMimetype())
-		extension = exts[0]
-		path := fmt.Sprintf("%s%s", evt.Info.ID, extension)
-		err = os.WriteFile(path, data, 0644)
-		if err != nil {
-			log.Errorf("Failed to save audio: %v", err)
-			return
-		}
+// ReceiptEvent is pushed to WebSocket clients when a sent message is delivered, read or played.
+type ReceiptEvent struct {
+	MessageIDs []string  `json:"message_ids"`
+	Chat       string    `json:"chat"`
+	Sender     string    `json:"sender"`
+	Type       string    `json:"type"`
+	Timestamp  time.Time `json:"timestamp"`
+}
 
-		log.Infof("Saved audio message to %s", path)
+// chatLogMessage returns the chat log row of a received message or a message from a history sync,
+// or nil if the message isn't stored as a message of its own. That's the case for protocol
+// messages, reactions and poll votes, which change other messages, messages between our own
+// devices, status updates and messages of a type that isn't stored.
+func (s *Session) chatLogMessage(evt *events.Message) *ChatLogMessage {
+	switch {
+	case evt.Message == nil || evt.Message.GetProtocolMessage() != nil:
+		return nil
+	case evt.Message.GetPollUpdateMessage() != nil || evt.Message.GetReactionMessage() != nil || evt.Message.GetEncReactionMessage() != nil:
+		return nil
+	case evt.Info.Category == "peer":
+		// Bunlar ilk login olunduğunda alınan sistem mesajları, veritabanına yazmayalım.
+		// Örn: [Main INFO] Received message xxxxxxxxxxxxxxxxxxxxxxxxxxxxx from xxxxxxxxx@s.whatsapp.net (pushname: xxxxxx, timestamp: 2023-06-21 12:16:33 +0300 +03, type: text, category: peer): protocolMessage:{type:INITIAL_SECURITY_NOTIFICATION_SETTING_SYNC initialSecurityNotificationSettingSync:{securityNotificationEnabled:false}}
+		return nil
+	case evt.Info.Chat.String() == "status@broadcast":
+		return nil
 	}
+	content, msgType, fileName := classifyMessage(evt.Message)
+	if msgType == "" {
+		s.log.Debugf("Not storing message %s of an unknown type", evt.Info.ID)
+		return nil
+	}
+	return &ChatLogMessage{
+		MessageID:       evt.Info.ID,
+		DeviceJID:       s.device.ID.String(),
+		RemoteJID:       evt.Info.Chat.String(),
+		Type:            msgType,
+		Content:         content,
+		Timestamp:       evt.Info.Timestamp,
+		Sent:            evt.Info.IsFromMe,
+		FileName:        fileName,
+		UserID:          -1,
+		SenderJID:       evt.Info.Sender.ToNonAD().String(),
+		QuotedMessageID: quotedMessageID(evt.Message),
+		RawMessage:      rawMessage(evt.Message),
+	}
+}
 
-	var msgContent string
-	var msgType string
-
+// classifyMessage returns the content, type and file name stored in the chat log for a message.
+// The type is empty for messages that aren't stored.
+func classifyMessage(msg *waProto.Message) (content, msgType, fileName string) {
 	switch {
-	case evt.Message.GetConversation() != "":
-		msgContent = evt.Message.GetConversation()
+	case msg.GetConversation() != "":
+		content = msg.GetConversation()
 		msgType = "text"
-	case evt.Message.GetExtendedTextMessage() != nil:
-		msgContent = evt.Message.GetExtendedTextMessage().GetText()
+	case msg.GetExtendedTextMessage() != nil:
+		content = msg.GetExtendedTextMessage().GetText()
 		msgType = "text"
-	case evt.Message.GetImageMessage() != nil:
-		msgContent = evt.Message.GetImageMessage().GetCaption()
+	case msg.GetImageMessage() != nil:
+		content = msg.GetImageMessage().GetCaption()
 		msgType = "media"
-	case evt.Message.GetDocumentMessage() != nil:
-		msgContent = evt.Message.GetDocumentMessage().GetCaption()
+	case msg.GetDocumentMessage() != nil:
+		content = msg.GetDocumentMessage().GetCaption()
 		msgType = "media"
-	case evt.Message.GetVideoMessage() != nil:
-		msgContent = evt.Message.GetVideoMessage().GetCaption()
+		fileName = msg.GetDocumentMessage().GetFileName()
+	case msg.GetVideoMessage() != nil:
+		content = msg.GetVideoMessage().GetCaption()
 		msgType = "media"
+	case msg.GetAudioMessage() != nil, msg.GetStickerMessage() != nil:
+		msgType = "media"
+	case pollCreation(msg) != nil:
+		content = pollCreation(msg).GetName()
+		msgType = "poll"
+	case msg.GetLocationMessage() != nil:
+		loc := msg.GetLocationMessage()
+		content = locationContent(loc.GetDegreesLatitude(), loc.GetDegreesLongitude(), loc.GetName(), loc.GetAddress())
+		msgType = "location"
+	case msg.GetLiveLocationMessage() != nil:
+		loc := msg.GetLiveLocationMessage()
+		content = locationContent(loc.GetDegreesLatitude(), loc.GetDegreesLongitude(), loc.GetCaption())
+		msgType = "live_location"
+	case msg.GetContactMessage() != nil:
+		content = msg.GetContactMessage().GetDisplayName()
+		msgType = "contact"
+	case msg.GetContactsArrayMessage() != nil:
+		names := make([]string, 0, len(msg.GetContactsArrayMessage().GetContacts()))
+		for _, contact := range msg.GetContactsArrayMessage().GetContacts() {
+			names = append(names, contact.GetDisplayName())
+		}
+		content = strings.Join(names, "\n")
+		msgType = "contact"
 	}
+	return
+}
 
-	remoteJid := evt.Info.MessageSource.Chat.String()
+// quotedMessageID returns the ID of the message a message replies to, if any.
+func quotedMessageID(msg *waProto.Message) string {
+	var contextInfo *waProto.ContextInfo
+	switch {
+	case msg.GetExtendedTextMessage() != nil:
+		contextInfo = msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		contextInfo = msg.GetImageMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		contextInfo = msg.GetDocumentMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		contextInfo = msg.GetVideoMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		contextInfo = msg.GetAudioMessage().GetContextInfo()
+	case msg.GetLocationMessage() != nil:
+		contextInfo = msg.GetLocationMessage().GetContextInfo()
+	case msg.GetContactMessage() != nil:
+		contextInfo = msg.GetContactMessage().GetContextInfo()
+	}
+	return contextInfo.GetStanzaId()
+}
 
-	if evt.Info.Category == "peer" {
-		// Bunlar ilk login olunduğunda alınan sistem mesajları, veritabanına yazmayalım.
-		// Örn: [Main INFO] Received message xxxxxxxxxxxxxxxxxxxxxxxxxxxxx from xxxxxxxxx@s.whatsapp.net (pushname: xxxxxx, timestamp: 2023-06-21 12:16:33 +0300 +03, type: text, category: peer): protocolMessage:{type:INITIAL_SECURITY_NOTIFICATION_SETTING_SYNC initialSecurityNotificationSettingSync:{securityNotificationEnabled:false}}
-		return
+func (s *Session) handleReceipt(evt *events.Receipt) {
+	if evt.Type == events.ReceiptTypeRead || evt.Type == events.ReceiptTypeReadSelf {
+		s.log.Infof("%v was read by %s at %s", evt.MessageIDs, evt.SourceString(), evt.Timestamp)
+	} else if evt.Type == events.ReceiptTypeDelivered {
+		s.log.Infof("%s was delivered to %s at %s", evt.MessageIDs[0], evt.SourceString(), evt.Timestamp)
 	}
 
-	if remoteJid == "status@broadcast" {
+	chat := evt.Chat.String()
+	deviceJID := s.device.ID.String()
+	if evt.IsFromMe {
+		// One of our other devices read incoming messages.
+		if evt.Type == events.ReceiptTypeRead || evt.Type == events.ReceiptTypeReadSelf {
+			for _, id := range evt.MessageIDs {
+				if err := s.srv.chatLog.MarkRead(id, deviceJID, chat, evt.Timestamp); err != nil {
+					s.log.Errorf("Error marking message as read: %v", err)
+				}
+			}
+		}
 		return
 	}
 
-	if err := insertMessages(evt.Info.ID, cli.Store.ID.String(), remoteJid, msgContent, msgType, evt.Info.Timestamp, evt.Info.MessageSource.IsFromMe, fileName, -1); err != nil {
-		log.Errorf("Error inserting into messages: %v", err)
+	var receiptType string
+	switch evt.Type {
+	case events.ReceiptTypeDelivered:
+		receiptType = "delivered"
+	case events.ReceiptTypeRead:
+		receiptType = "read"
+	case events.ReceiptTypePlayed:
+		receiptType = "played"
+	default:
+		return
 	}
 
-	if err := insertLastMessages(evt.Info.ID, cli.Store.ID.String(), remoteJid, msgContent, msgType, evt.Info.Timestamp, evt.Info.MessageSource.IsFromMe, fileName, -1); err != nil {
-		log.Errorf("Error inserting into last_messages: %v", err)
+	sender := evt.Sender.ToNonAD().String()
+	for _, id := range evt.MessageIDs {
+		if err := s.srv.chatLog.MarkReceipt(id, deviceJID, chat, receiptType, evt.Timestamp); err != nil {
+			s.log.Errorf("Error updating %s receipt of %s: %v", receiptType, id, err)
+		}
+		if evt.IsGroup {
+			err := s.srv.chatLog.InsertReceipt(&ChatLogReceipt{
+				MessageID:   id,
+				DeviceJID:   deviceJID,
+				RemoteJID:   chat,
+				Participant: sender,
+				Type:        receiptType,
+				Timestamp:   evt.Timestamp,
+			})
+			if err != nil {
+				s.log.Errorf("Error inserting into message_receipts: %v", err)
+			}
+		}
 	}
 
-	if wsConn != nil {
-		m := Message{evt.Info.ID, remoteJid, msgType, msgContent, evt.Info.MessageSource.IsFromMe, fileName}
-		wsConn.WriteJSON(m)
-	}
+	s.publish("receipt", ReceiptEvent{
+		MessageIDs: evt.MessageIDs,
+		Chat:       chat,
+		Sender:     sender,
+		Type:       receiptType,
+		Timestamp:  evt.Timestamp,
+	})
 }
 
-func handleReceipt(evt *events.Receipt) {
-	if evt.Type == events.ReceiptTypeRead || evt.Type == events.ReceiptTypeReadSelf {
-		log.Infof("%v was read by %s at %s", evt.MessageIDs, evt.SourceString(), evt.Timestamp)
-	} else if evt.Type == events.ReceiptTypeDelivered {
-		log.Infof("%s was delivered to %s at %s", evt.MessageIDs[0], evt.SourceString(), evt.Timestamp)
-	}
+// PresenceEvent is published when a contact the session subscribed to goes online or offline.
+type PresenceEvent struct {
+	From        string     `json:"from"`
+	Unavailable bool       `json:"unavailable"`
+	LastSeen    *time.Time `json:"last_seen,omitempty"`
 }
 
-func handlePresence(evt *events.Presence) {
+func (s *Session) handlePresence(evt *events.Presence) {
 	if evt.Unavailable {
 		if evt.LastSeen.IsZero() {
-			log.Infof("%s is now offline", evt.From)
+			s.log.Infof("%s is now offline", evt.From)
 		} else {
-			log.Infof("%s is now offline (last seen: %s)", evt.From, evt.LastSeen)
+			s.log.Infof("%s is now offline (last seen: %s)", evt.From, evt.LastSeen)
 		}
 	} else {
-		log.Infof("%s is now online", evt.From)
+		s.log.Infof("%s is now online", evt.From)
 	}
-}
 
-func handleHistorySync(evt *events.HistorySync) {
-	id := atomic.AddInt32(&historySyncID, 1)
-	fileName := fmt.Sprintf("history-%d-%d.json", startupTime, id)
-	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE, 0600)
-	if err != nil {
-		log.Errorf("Failed to open file to write history sync: %v", err)
-		return
+	presence := PresenceEvent{From: evt.From.String(), Unavailable: evt.Unavailable}
+	if !evt.LastSeen.IsZero() {
+		presence.LastSeen = &evt.LastSeen
 	}
-	enc := json.NewEncoder(file)
-	enc.SetIndent("", "  ")
-	err = enc.Encode(evt.Data)
-	if err != nil {
-		log.Errorf("Failed to write history sync: %v", err)
-		return
-	}
-	log.Infof("Wrote history sync to %s", fileName)
-	_ = file.Close()
+	s.publish("presence", presence)
+}
+
+// ConnectionEvent is published when the connection state of the session changes.
+type ConnectionEvent struct {
+	State string `json:"state"`
+}
+
+func (s *Session) publishConnectionState(state string) {
+	s.log.Infof("Session %s is now %s", s.ID(), state)
+	s.publish("connection", ConnectionEvent{State: state})
 }
 
-func handleAppState(evt *events.AppState) {
-	log.Debugf("App state event: %+v / %+v", evt.Index, evt.SyncActionValue)
+func (s *Session) handleAppState(evt *events.AppState) {
+	s.log.Debugf("App state event: %+v / %+v", evt.Index, evt.SyncActionValue)
 }
 
-func handleKeepAliveTimeout(evt *events.KeepAliveTimeout) {
-	log.Debugf("Keepalive timeout event: %+v", evt)
+func (s *Session) handleKeepAliveTimeout(evt *events.KeepAliveTimeout) {
+	s.log.Debugf("Keepalive timeout event: %+v", evt)
 }
 
-func handleKeepAliveRestored(evt *events.KeepAliveRestored) {
-	log.Debugf("Keepalive restored")
+func (s *Session) handleKeepAliveRestored(evt *events.KeepAliveRestored) {
+	s.log.Debugf("Keepalive restored")
 }
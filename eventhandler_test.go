@@ -0,0 +1,242 @@
+package whatsappws
+
+import (
+	"testing"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	testChat  = types.NewJID("905550000000", types.DefaultUserServer)
+	testGroup = types.NewJID("120363000000000000", types.GroupServer)
+)
+
+// receivedMessage builds a message event of a chat, sent by sender or by us if sender is empty.
+func receivedMessage(id string, chat, sender types.JID, timestamp time.Time, msg *waProto.Message) *events.Message {
+	return &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{
+				Chat:     chat,
+				Sender:   sender,
+				IsFromMe: sender.IsEmpty(),
+				IsGroup:  chat.Server == types.GroupServer,
+			},
+			ID:        id,
+			Timestamp: timestamp,
+		},
+		Message: msg,
+	}
+}
+
+func TestReceiveMessage(t *testing.T) {
+	chatLog := NewMemoryChatLog()
+	_, fake := newTestServer(t, chatLog)
+	deviceJID := testPhone + "@s.whatsapp.net"
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	fake.Emit(receivedMessage("TEXT", testChat, testChat, start, &waProto.Message{Conversation: proto.String("Hello")}))
+	fake.Emit(receivedMessage("REPLY", testChat, types.EmptyJID, start.Add(time.Minute), &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:        proto.String("Hi"),
+			ContextInfo: &waProto.ContextInfo{StanzaId: proto.String("TEXT")},
+		},
+	}))
+	peer := receivedMessage("PEER", types.NewJID(testPhone, types.DefaultUserServer), types.EmptyJID, start, &waProto.Message{Conversation: proto.String("Sync")})
+	peer.Info.Category = "peer"
+	fake.Emit(peer)
+	fake.Emit(receivedMessage("STATUS", types.StatusBroadcastJID, testChat, start, &waProto.Message{Conversation: proto.String("Status")}))
+
+	messages := chatLog.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("stored %d messages, want 2: %+v", len(messages), messages)
+	}
+	if msg := messages[0]; msg.MessageID != "TEXT" || msg.DeviceJID != deviceJID || msg.RemoteJID != testChat.String() ||
+		msg.Type != "text" || msg.Content != "Hello" || msg.Sent || msg.SenderJID != testChat.String() || !msg.Timestamp.Equal(start) {
+		t.Errorf("received message is %+v", msg)
+	}
+	if msg := messages[1]; msg.MessageID != "REPLY" || msg.Content != "Hi" || !msg.Sent || msg.QuotedMessageID != "TEXT" {
+		t.Errorf("reply is %+v", msg)
+	}
+	if last, ok := chatLog.LastMessage(deviceJID, testChat.String()); !ok || last.MessageID != "REPLY" {
+		t.Errorf("last message is %+v", last)
+	}
+}
+
+func TestReceiveReceipts(t *testing.T) {
+	chatLog := NewMemoryChatLog()
+	_, fake := newTestServer(t, chatLog)
+	deviceJID := testPhone + "@s.whatsapp.net"
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	delivered, read := start.Add(time.Minute), start.Add(2*time.Minute)
+
+	fake.Emit(receivedMessage("SENT", testChat, types.EmptyJID, start, &waProto.Message{Conversation: proto.String("Hello")}))
+	fake.Emit(receivedMessage("INCOMING", testChat, testChat, start, &waProto.Message{Conversation: proto.String("Hi")}))
+	fake.Emit(receivedMessage("GROUP", testGroup, types.EmptyJID, start, &waProto.Message{Conversation: proto.String("Hello all")}))
+
+	receipt := func(id string, source types.MessageSource, receiptType events.ReceiptType, timestamp time.Time) *events.Receipt {
+		return &events.Receipt{MessageSource: source, MessageIDs: []types.MessageID{id}, Timestamp: timestamp, Type: receiptType}
+	}
+	fake.Emit(receipt("SENT", types.MessageSource{Chat: testChat, Sender: testChat}, events.ReceiptTypeDelivered, delivered))
+	fake.Emit(receipt("SENT", types.MessageSource{Chat: testChat, Sender: testChat}, events.ReceiptTypeRead, read))
+	// Our other device read the incoming message.
+	fake.Emit(receipt("INCOMING", types.MessageSource{Chat: testChat, IsFromMe: true}, events.ReceiptTypeRead, read))
+	member := types.NewJID("905550000001", types.DefaultUserServer)
+	fake.Emit(receipt("GROUP", types.MessageSource{Chat: testGroup, Sender: member, IsGroup: true}, events.ReceiptTypeRead, read))
+
+	messages := chatLog.Messages()
+	if len(messages) != 3 {
+		t.Fatalf("stored %d messages, want 3", len(messages))
+	}
+	if msg := messages[0]; msg.DeliveredAt == nil || !msg.DeliveredAt.Equal(delivered) || msg.ReadAt == nil || !msg.ReadAt.Equal(read) {
+		t.Errorf("sent message after the receipts is %+v", msg)
+	}
+	if msg := messages[1]; msg.ReadAt == nil || !msg.ReadAt.Equal(read) || msg.DeliveredAt != nil {
+		t.Errorf("incoming message after reading it is %+v", msg)
+	}
+	receipts := chatLog.Receipts()
+	if len(receipts) != 1 {
+		t.Fatalf("stored %d participant receipts, want 1: %+v", len(receipts), receipts)
+	}
+	if r := receipts[0]; r.MessageID != "GROUP" || r.DeviceJID != deviceJID || r.RemoteJID != testGroup.String() ||
+		r.Participant != member.String() || r.Type != "read" || !r.Timestamp.Equal(read) {
+		t.Errorf("participant receipt is %+v", r)
+	}
+}
+
+func TestReceiveEditAndRevoke(t *testing.T) {
+	chatLog := NewMemoryChatLog()
+	_, fake := newTestServer(t, chatLog)
+	deviceJID := testPhone + "@s.whatsapp.net"
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	edited := start.Add(time.Minute)
+
+	fake.Emit(receivedMessage("EDITED", testChat, testChat, start, &waProto.Message{Conversation: proto.String("Helo")}))
+	fake.Emit(receivedMessage("REVOKED", testChat, testChat, start, &waProto.Message{Conversation: proto.String("Oops")}))
+	fake.Emit(receivedMessage("EDIT", testChat, testChat, edited, &waProto.Message{ProtocolMessage: &waProto.ProtocolMessage{
+		Type:          waProto.ProtocolMessage_MESSAGE_EDIT.Enum(),
+		Key:           &waProto.MessageKey{Id: proto.String("EDITED")},
+		EditedMessage: &waProto.Message{Conversation: proto.String("Hello")},
+	}}))
+	fake.Emit(receivedMessage("REVOKE", testChat, testChat, edited, &waProto.Message{ProtocolMessage: &waProto.ProtocolMessage{
+		Type: waProto.ProtocolMessage_REVOKE.Enum(),
+		Key:  &waProto.MessageKey{Id: proto.String("REVOKED")},
+	}}))
+
+	messages := chatLog.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("stored %d messages, want 2: %+v", len(messages), messages)
+	}
+	if msg := messages[0]; msg.Content != "Hello" || msg.EditedAt == nil || !msg.EditedAt.Equal(edited) || msg.Revoked {
+		t.Errorf("edited message is %+v", msg)
+	}
+	if msg := messages[1]; !msg.Revoked || msg.EditedAt != nil {
+		t.Errorf("revoked message is %+v", msg)
+	}
+	edits := chatLog.Edits()
+	if len(edits) != 1 {
+		t.Fatalf("stored %d edits, want 1", len(edits))
+	}
+	if e := edits[0]; e.MessageID != "EDITED" || e.DeviceJID != deviceJID || e.Content != "Helo" || !e.EditedAt.Equal(edited) {
+		t.Errorf("edit is %+v", e)
+	}
+	if last, ok := chatLog.LastMessage(deviceJID, testChat.String()); !ok || last.MessageID != "REVOKED" || !last.Revoked {
+		t.Errorf("last message is %+v", last)
+	}
+}
+
+func TestReceiveEditAndRevokeOfOthers(t *testing.T) {
+	chatLog := NewMemoryChatLog()
+	_, fake := newTestServer(t, chatLog)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	author := types.NewJID("905550000001", types.DefaultUserServer)
+	member := types.NewJID("905550000002", types.DefaultUserServer)
+	admin := types.NewJID("905550000003", types.DefaultUserServer)
+	fake.AddGroup(&types.GroupInfo{JID: testGroup, Participants: []types.GroupParticipant{
+		{JID: author}, {JID: member}, {JID: admin, IsAdmin: true},
+	}})
+	edit := func(sender types.JID, id, text string) *events.Message {
+		return receivedMessage("EDIT"+id, testGroup, sender, start.Add(time.Minute), &waProto.Message{ProtocolMessage: &waProto.ProtocolMessage{
+			Type:          waProto.ProtocolMessage_MESSAGE_EDIT.Enum(),
+			Key:           &waProto.MessageKey{Id: proto.String(id)},
+			EditedMessage: &waProto.Message{Conversation: proto.String(text)},
+		}})
+	}
+	revoke := func(sender types.JID, id string) *events.Message {
+		return receivedMessage("REVOKE"+id, testGroup, sender, start.Add(time.Minute), &waProto.Message{ProtocolMessage: &waProto.ProtocolMessage{
+			Type: waProto.ProtocolMessage_REVOKE.Enum(),
+			Key:  &waProto.MessageKey{Id: proto.String(id)},
+		}})
+	}
+
+	fake.Emit(receivedMessage("THEIRS", testGroup, author, start, &waProto.Message{Conversation: proto.String("Hello")}))
+	fake.Emit(receivedMessage("MODERATED", testGroup, author, start, &waProto.Message{Conversation: proto.String("Spam")}))
+	fake.Emit(receivedMessage("OURS", testGroup, types.EmptyJID, start, &waProto.Message{Conversation: proto.String("Hi")}))
+	fake.Emit(edit(member, "THEIRS", "Forged"))
+	fake.Emit(edit(admin, "THEIRS", "Forged"))
+	fake.Emit(revoke(member, "THEIRS"))
+	fake.Emit(edit(member, "OURS", "Forged"))
+	fake.Emit(revoke(admin, "MODERATED"))
+	// Our other device edits our message.
+	fake.Emit(edit(types.EmptyJID, "OURS", "Hi all"))
+
+	messages := chatLog.Messages()
+	if len(messages) != 3 {
+		t.Fatalf("stored %d messages, want 3: %+v", len(messages), messages)
+	}
+	if msg := messages[0]; msg.Content != "Hello" || msg.EditedAt != nil || msg.Revoked {
+		t.Errorf("message edited and revoked by others is %+v", msg)
+	}
+	if msg := messages[1]; !msg.Revoked {
+		t.Errorf("message revoked by an admin is %+v", msg)
+	}
+	if msg := messages[2]; msg.Content != "Hi all" {
+		t.Errorf("our edited message is %+v", msg)
+	}
+	if edits := chatLog.Edits(); len(edits) != 1 || edits[0].MessageID != "OURS" {
+		t.Errorf("edits are %+v", edits)
+	}
+}
+
+func TestReceiveReaction(t *testing.T) {
+	chatLog := NewMemoryChatLog()
+	_, fake := newTestServer(t, chatLog)
+	deviceJID := testPhone + "@s.whatsapp.net"
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	reaction := func(id, text string, timestamp time.Time) *events.Message {
+		return receivedMessage(id, testChat, testChat, timestamp, &waProto.Message{ReactionMessage: &waProto.ReactionMessage{
+			Key:               &waProto.MessageKey{Id: proto.String("SENT"), FromMe: proto.Bool(true), RemoteJid: proto.String(testChat.String())},
+			Text:              proto.String(text),
+			SenderTimestampMs: proto.Int64(timestamp.UnixMilli()),
+		}})
+	}
+
+	fake.Emit(receivedMessage("SENT", testChat, types.EmptyJID, start, &waProto.Message{Conversation: proto.String("Hello")}))
+	fake.Emit(reaction("REACT1", "👍", start.Add(time.Minute)))
+	reactions := chatLog.Reactions()
+	if len(reactions) != 1 {
+		t.Fatalf("stored %d reactions, want 1", len(reactions))
+	}
+	if r := reactions[0]; r.MessageID != "SENT" || r.DeviceJID != deviceJID || r.RemoteJID != testChat.String() ||
+		r.ReactorJID != testChat.String() || r.Reaction != "👍" || !r.Timestamp.Equal(start.Add(time.Minute)) {
+		t.Errorf("reaction is %+v", r)
+	}
+
+	// A new reaction of the same sender replaces the old one.
+	fake.Emit(reaction("REACT2", "❤️", start.Add(2*time.Minute)))
+	if reactions = chatLog.Reactions(); len(reactions) != 1 || reactions[0].Reaction != "❤️" || !reactions[0].Timestamp.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("reactions after replacing it are %+v", reactions)
+	}
+
+	// An empty reaction removes it.
+	fake.Emit(reaction("REACT3", "", start.Add(3*time.Minute)))
+	if reactions = chatLog.Reactions(); len(reactions) != 0 {
+		t.Errorf("reactions after removing it are %+v", reactions)
+	}
+	if messages := chatLog.Messages(); len(messages) != 1 {
+		t.Errorf("stored %d messages, want only the reacted message: %+v", len(messages), messages)
+	}
+}
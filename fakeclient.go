@@ -0,0 +1,474 @@
+package whatsappws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// FakeSentMessage is a message sent through a FakeClient.
+type FakeSentMessage struct {
+	ID        types.MessageID
+	To        types.JID
+	Message   *waProto.Message
+	Timestamp time.Time
+}
+
+// FakeClient is a scriptable WAClient for tests. It records sent messages, uploads, read receipts
+// and media retry receipts, keeps joined groups in memory, and Emit feeds synthetic events to the
+// session's event handler. The Func fields override the default behaviour of the corresponding
+// method. The zero value is connected and logged in.
+type FakeClient struct {
+	SendMessageFunc     func(to types.JID, message *waProto.Message) (whatsmeow.SendResponse, error)
+	UploadFunc          func(plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error)
+	DownloadFunc        func(directPath string, mediaType whatsmeow.MediaType) ([]byte, error)
+	IsOnWhatsAppFunc    func(phones []string) ([]types.IsOnWhatsAppResponse, error)
+	DecryptPollVoteFunc func(vote *events.Message) (*waProto.PollVoteMessage, error)
+
+	lock         sync.Mutex
+	disconnected bool
+	loggedOut    bool
+	handlers     []whatsmeow.EventHandler
+	nextID       int
+	sent         []FakeSentMessage
+	uploads      [][]byte
+	readIDs      []types.MessageID
+	retries      []types.MessageID
+	groups       map[types.JID]*types.GroupInfo
+	invites      map[types.JID]string
+}
+
+// NewFakeClient returns a connected and logged in fake client.
+func NewFakeClient() *FakeClient {
+	return &FakeClient{}
+}
+
+// NewFakeDevice returns an in-memory device for a fake session, logged in as the given phone number.
+func NewFakeDevice(phone string) *store.Device {
+	jid := types.NewJID(phone, types.DefaultUserServer)
+	return &store.Device{ID: &jid, PushName: "Fake " + phone}
+}
+
+// SetConnected changes what IsConnected and IsLoggedIn return.
+func (f *FakeClient) SetConnected(connected, loggedIn bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.disconnected = !connected
+	f.loggedOut = !loggedIn
+}
+
+// Emit dispatches a synthetic event, e.g. *events.Message or *events.Receipt, to the event
+// handlers synchronously.
+func (f *FakeClient) Emit(evt interface{}) {
+	f.lock.Lock()
+	handlers := append([]whatsmeow.EventHandler(nil), f.handlers...)
+	f.lock.Unlock()
+	for _, handler := range handlers {
+		handler(evt)
+	}
+}
+
+// AddGroup adds a group that the fake account has joined.
+func (f *FakeClient) AddGroup(info *types.GroupInfo) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.groups == nil {
+		f.groups = make(map[types.JID]*types.GroupInfo)
+	}
+	stored := *info
+	f.groups[info.JID] = &stored
+}
+
+// SentMessages returns the messages sent so far.
+func (f *FakeClient) SentMessages() []FakeSentMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]FakeSentMessage(nil), f.sent...)
+}
+
+// Uploads returns the plaintext of the uploaded media.
+func (f *FakeClient) Uploads() [][]byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([][]byte(nil), f.uploads...)
+}
+
+// MediaRetryIDs returns the IDs of the messages passed to SendMediaRetryReceipt.
+func (f *FakeClient) MediaRetryIDs() []types.MessageID {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]types.MessageID(nil), f.retries...)
+}
+
+// ReadMessageIDs returns the IDs passed to MarkRead.
+func (f *FakeClient) ReadMessageIDs() []types.MessageID {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]types.MessageID(nil), f.readIDs...)
+}
+
+func (f *FakeClient) Connect() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.disconnected = false
+	return nil
+}
+
+func (f *FakeClient) Disconnect() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.disconnected = true
+}
+
+func (f *FakeClient) Logout() error {
+	f.SetConnected(false, false)
+	return nil
+}
+
+func (f *FakeClient) IsConnected() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return !f.disconnected
+}
+
+func (f *FakeClient) IsLoggedIn() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return !f.disconnected && !f.loggedOut
+}
+
+func (f *FakeClient) AddEventHandler(handler whatsmeow.EventHandler) uint32 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.handlers = append(f.handlers, handler)
+	return uint32(len(f.handlers))
+}
+
+func (f *FakeClient) GenerateMessageID() types.MessageID {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.nextID++
+	return fmt.Sprintf("FAKE%016X", f.nextID)
+}
+
+func (f *FakeClient) SendMessage(_ context.Context, to types.JID, message *waProto.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error) {
+	if !f.IsLoggedIn() {
+		return whatsmeow.SendResponse{}, whatsmeow.ErrNotLoggedIn
+	}
+	var resp whatsmeow.SendResponse
+	if f.SendMessageFunc != nil {
+		var err error
+		if resp, err = f.SendMessageFunc(to, message); err != nil {
+			return resp, err
+		}
+	}
+	if resp.ID == "" && len(extra) > 0 {
+		resp.ID = extra[0].ID
+	}
+	if resp.ID == "" {
+		resp.ID = f.GenerateMessageID()
+	}
+	if resp.Timestamp.IsZero() {
+		resp.Timestamp = time.Now()
+	}
+	f.lock.Lock()
+	f.sent = append(f.sent, FakeSentMessage{ID: resp.ID, To: to, Message: message, Timestamp: resp.Timestamp})
+	f.lock.Unlock()
+	return resp, nil
+}
+
+// BuildEdit builds the same message as the real client, which doesn't depend on its state.
+func (f *FakeClient) BuildEdit(chat types.JID, id types.MessageID, newContent *waProto.Message) *waProto.Message {
+	return (&whatsmeow.Client{}).BuildEdit(chat, id, newContent)
+}
+
+// BuildRevoke builds a revoke of an own message. The sender is ignored.
+func (f *FakeClient) BuildRevoke(chat, _ types.JID, id types.MessageID) *waProto.Message {
+	return &waProto.Message{
+		ProtocolMessage: &waProto.ProtocolMessage{
+			Type: waProto.ProtocolMessage_REVOKE.Enum(),
+			Key: &waProto.MessageKey{
+				FromMe:    proto.Bool(true),
+				Id:        proto.String(id),
+				RemoteJid: proto.String(chat.String()),
+			},
+		},
+	}
+}
+
+// BuildReaction builds a reaction like the real client, with the message key of a message from
+// the sender.
+func (f *FakeClient) BuildReaction(chat, sender types.JID, id types.MessageID, reaction string) *waProto.Message {
+	key := &waProto.MessageKey{
+		FromMe:    proto.Bool(sender.IsEmpty()),
+		Id:        proto.String(id),
+		RemoteJid: proto.String(chat.String()),
+	}
+	if !sender.IsEmpty() && chat.Server != types.DefaultUserServer {
+		key.Participant = proto.String(sender.ToNonAD().String())
+	}
+	return &waProto.Message{
+		ReactionMessage: &waProto.ReactionMessage{
+			Key:               key,
+			Text:              proto.String(reaction),
+			SenderTimestampMs: proto.Int64(time.Now().UnixMilli()),
+		},
+	}
+}
+
+// BuildPollCreation builds the same message as the real client, which doesn't depend on its state.
+func (f *FakeClient) BuildPollCreation(name string, optionNames []string, selectableOptionCount int) *waProto.Message {
+	return (&whatsmeow.Client{}).BuildPollCreation(name, optionNames, selectableOptionCount)
+}
+
+func (f *FakeClient) Upload(_ context.Context, plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	if f.UploadFunc != nil {
+		return f.UploadFunc(plaintext, appInfo)
+	}
+	f.lock.Lock()
+	f.uploads = append(f.uploads, plaintext)
+	f.lock.Unlock()
+	return whatsmeow.UploadResponse{
+		URL:        "https://mmg.whatsapp.net/fake",
+		DirectPath: "/fake",
+		FileLength: uint64(len(plaintext)),
+	}, nil
+}
+
+func (f *FakeClient) DownloadMediaWithPath(directPath string, _, _, _ []byte, _ int, mediaType whatsmeow.MediaType, _ string) ([]byte, error) {
+	if f.DownloadFunc != nil {
+		return f.DownloadFunc(directPath, mediaType)
+	}
+	return nil, errors.New("fake client has no media")
+}
+
+func (f *FakeClient) SendMediaRetryReceipt(message *types.MessageInfo, _ []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.retries = append(f.retries, message.ID)
+	return nil
+}
+
+func (f *FakeClient) MarkRead(ids []types.MessageID, _ time.Time, _, _ types.JID) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.readIDs = append(f.readIDs, ids...)
+	return nil
+}
+
+// IsOnWhatsApp reports every number as registered unless IsOnWhatsAppFunc is set.
+func (f *FakeClient) IsOnWhatsApp(phones []string) ([]types.IsOnWhatsAppResponse, error) {
+	if f.IsOnWhatsAppFunc != nil {
+		return f.IsOnWhatsAppFunc(phones)
+	}
+	resp := make([]types.IsOnWhatsAppResponse, len(phones))
+	for i, phone := range phones {
+		number := strings.TrimPrefix(phone, "+")
+		resp[i] = types.IsOnWhatsAppResponse{
+			Query: phone,
+			JID:   types.NewJID(number, types.DefaultUserServer),
+			IsIn:  true,
+		}
+	}
+	return resp, nil
+}
+
+func (f *FakeClient) SendPresence(types.Presence) error {
+	return nil
+}
+
+func (f *FakeClient) SetStatusMessage(string) error {
+	return nil
+}
+
+// ParseWebMessage parses a history sync message like the real client, except that our own
+// messages have no sender, because the fake doesn't know its own JID.
+func (f *FakeClient) ParseWebMessage(chatJID types.JID, webMsg *waProto.WebMessageInfo) (*events.Message, error) {
+	info := types.MessageInfo{
+		MessageSource: types.MessageSource{
+			Chat:     chatJID,
+			IsFromMe: webMsg.GetKey().GetFromMe(),
+			IsGroup:  chatJID.Server == types.GroupServer,
+		},
+		ID:        webMsg.GetKey().GetId(),
+		PushName:  webMsg.GetPushName(),
+		Timestamp: time.Unix(int64(webMsg.GetMessageTimestamp()), 0),
+	}
+	switch {
+	case info.IsFromMe:
+	case chatJID.Server == types.DefaultUserServer:
+		info.Sender = chatJID
+	case webMsg.GetParticipant() != "":
+		info.Sender, _ = types.ParseJID(webMsg.GetParticipant())
+	default:
+		info.Sender, _ = types.ParseJID(webMsg.GetKey().GetParticipant())
+	}
+	evt := &events.Message{Info: info, RawMessage: webMsg.GetMessage(), SourceWebMsg: webMsg}
+	evt.UnwrapRaw()
+	return evt, nil
+}
+
+func (f *FakeClient) DecryptPollVote(vote *events.Message) (*waProto.PollVoteMessage, error) {
+	if f.DecryptPollVoteFunc != nil {
+		return f.DecryptPollVoteFunc(vote)
+	}
+	return nil, errors.New("fake client can't decrypt poll votes")
+}
+
+func (f *FakeClient) DecryptReaction(*events.Message) (*waProto.ReactionMessage, error) {
+	return nil, errors.New("fake client can't decrypt reactions")
+}
+
+func (f *FakeClient) GetJoinedGroups() ([]*types.GroupInfo, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	groups := make([]*types.GroupInfo, 0, len(f.groups))
+	for _, group := range f.groups {
+		stored := *group
+		groups = append(groups, &stored)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].JID.String() < groups[j].JID.String() })
+	return groups, nil
+}
+
+func (f *FakeClient) GetGroupInfo(jid types.JID) (*types.GroupInfo, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	group, ok := f.groups[jid]
+	if !ok {
+		return nil, whatsmeow.ErrGroupNotFound
+	}
+	stored := *group
+	stored.Participants = append([]types.GroupParticipant(nil), group.Participants...)
+	return &stored, nil
+}
+
+func (f *FakeClient) CreateGroup(req whatsmeow.ReqCreateGroup) (*types.GroupInfo, error) {
+	f.lock.Lock()
+	jid := types.NewJID(fmt.Sprintf("1203630%011d", len(f.groups)+1), types.GroupServer)
+	f.lock.Unlock()
+	info := &types.GroupInfo{
+		JID:          jid,
+		GroupName:    types.GroupName{Name: req.Name, NameSetAt: time.Now()},
+		GroupCreated: time.Now(),
+	}
+	for _, participant := range req.Participants {
+		info.Participants = append(info.Participants, types.GroupParticipant{JID: participant})
+	}
+	f.AddGroup(info)
+	return info, nil
+}
+
+// UpdateGroupParticipants applies the changes and returns a response like the server's, with a
+// participant node for every change.
+func (f *FakeClient) UpdateGroupParticipants(jid types.JID, participantChanges map[types.JID]whatsmeow.ParticipantChange) (*waBinary.Node, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	group, ok := f.groups[jid]
+	if !ok {
+		return nil, whatsmeow.ErrGroupNotFound
+	}
+	var content []waBinary.Node
+	for participant, change := range participantChanges {
+		i := -1
+		for j := range group.Participants {
+			if group.Participants[j].JID == participant {
+				i = j
+			}
+		}
+		attrs := waBinary.Attrs{"jid": participant}
+		switch {
+		case change == whatsmeow.ParticipantChangeAdd && i < 0:
+			group.Participants = append(group.Participants, types.GroupParticipant{JID: participant})
+		case change == whatsmeow.ParticipantChangeAdd:
+			attrs["error"] = "409"
+		case i < 0:
+			attrs["error"] = "404"
+		case change == whatsmeow.ParticipantChangeRemove:
+			group.Participants = append(group.Participants[:i], group.Participants[i+1:]...)
+		default:
+			group.Participants[i].IsAdmin = change == whatsmeow.ParticipantChangePromote
+		}
+		content = append(content, waBinary.Node{
+			Tag:     string(change),
+			Content: []waBinary.Node{{Tag: "participant", Attrs: attrs}},
+		})
+	}
+	return &waBinary.Node{Tag: "iq", Attrs: waBinary.Attrs{"from": jid, "type": "result"}, Content: content}, nil
+}
+
+func (f *FakeClient) SetGroupName(jid types.JID, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	group, ok := f.groups[jid]
+	if !ok {
+		return whatsmeow.ErrGroupNotFound
+	}
+	group.Name = name
+	group.NameSetAt = time.Now()
+	return nil
+}
+
+func (f *FakeClient) SetGroupTopic(jid types.JID, _, _, topic string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	group, ok := f.groups[jid]
+	if !ok {
+		return whatsmeow.ErrGroupNotFound
+	}
+	group.Topic = topic
+	group.TopicSetAt = time.Now()
+	return nil
+}
+
+func (f *FakeClient) SetGroupPhoto(jid types.JID, avatar []byte) (string, error) {
+	if _, err := f.GetGroupInfo(jid); err != nil {
+		return "", err
+	} else if avatar == nil {
+		return "remove", nil
+	}
+	return f.GenerateMessageID(), nil
+}
+
+// GetGroupInviteLink returns a link with a random code, which is replaced if reset is true.
+func (f *FakeClient) GetGroupInviteLink(jid types.JID, reset bool) (string, error) {
+	if _, err := f.GetGroupInfo(jid); err != nil {
+		return "", err
+	}
+	code := f.GenerateMessageID()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if stored, ok := f.invites[jid]; ok && !reset {
+		code = stored
+	}
+	if f.invites == nil {
+		f.invites = make(map[types.JID]string)
+	}
+	f.invites[jid] = code
+	return whatsmeow.InviteLinkPrefix + code, nil
+}
+
+// JoinGroupWithLink joins a group with a link returned by GetGroupInviteLink.
+func (f *FakeClient) JoinGroupWithLink(code string) (types.JID, error) {
+	code = strings.TrimPrefix(code, whatsmeow.InviteLinkPrefix)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for jid, stored := range f.invites {
+		if stored == code {
+			return jid, nil
+		}
+	}
+	return types.EmptyJID, whatsmeow.ErrInviteLinkRevoked
+}
@@ -0,0 +1,197 @@
+package whatsappws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// GroupEvent is published when the participants or the settings of a group change, or when we
+// join a group. Type is one of join, leave, promote, demote, subject, description, announce,
+// locked, invite_link or joined.
+type GroupEvent struct {
+	MessageID    string    `json:"message_id"`
+	Group        string    `json:"group"`
+	Type         string    `json:"type"`
+	Sender       string    `json:"sender,omitempty"`
+	Participants []string  `json:"participants,omitempty"`
+	Value        string    `json:"value,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// groupEventID derives the ID of the system message of a group event from its contents, so that
+// notifications delivered twice are only stored once. It must be called before a missing
+// timestamp is filled in, so that the zero time is hashed instead of the time of delivery.
+func groupEventID(e *GroupEvent) string {
+	h := sha256.Sum256([]byte(strings.Join(append([]string{e.Group, e.Type, e.Value, e.Timestamp.UTC().Format(time.RFC3339Nano)}, e.Participants...), "\x00")))
+	return "SYS" + strings.ToUpper(hex.EncodeToString(h[:8]))
+}
+
+// content describes the event the way WhatsApp shows it in the chat.
+func (e *GroupEvent) content() string {
+	sender := e.Sender
+	if sender == "" {
+		sender = "Someone"
+	}
+	participants := strings.Join(e.Participants, ", ")
+	self := len(e.Participants) == 1 && e.Participants[0] == e.Sender
+	switch e.Type {
+	case "join":
+		if self || e.Sender == "" {
+			return participants + " joined"
+		}
+		return fmt.Sprintf("%s added %s", sender, participants)
+	case "leave":
+		if self || e.Sender == "" {
+			return participants + " left"
+		}
+		return fmt.Sprintf("%s removed %s", sender, participants)
+	case "promote":
+		return fmt.Sprintf("%s made %s admin", sender, participants)
+	case "demote":
+		return fmt.Sprintf("%s dismissed %s as admin", sender, participants)
+	case "subject":
+		return fmt.Sprintf("%s changed the subject to %q", sender, e.Value)
+	case "description":
+		if e.Value == "" {
+			return sender + " deleted the group description"
+		}
+		return sender + " changed the group description"
+	case "announce":
+		if e.Value == "true" {
+			return sender + " changed the settings so only admins can send messages"
+		}
+		return sender + " changed the settings so all participants can send messages"
+	case "locked":
+		if e.Value == "true" {
+			return sender + " changed the settings so only admins can edit the group info"
+		}
+		return sender + " changed the settings so all participants can edit the group info"
+	case "invite_link":
+		return sender + " reset the invite link"
+	case "joined":
+		return fmt.Sprintf("Joined group %q", e.Value)
+	}
+	return e.Type
+}
+
+func jidStrings(jids []types.JID) []string {
+	s := make([]string, len(jids))
+	for i, jid := range jids {
+		s[i] = jid.ToNonAD().String()
+	}
+	return s
+}
+
+func (s *Session) handleGroupInfoChange(evt *events.GroupInfo) {
+	s.log.Infof("Received group info change of %s: %+v", evt.JID, evt)
+
+	deviceJID := s.device.ID.String()
+	group := evt.JID.String()
+	// Some notifications don't say who made the change or when.
+	var sender string
+	if evt.Sender != nil && !evt.Sender.IsEmpty() {
+		sender = evt.Sender.ToNonAD().String()
+	}
+	newEvent := func(eventType string, participants []string, value string) *GroupEvent {
+		return &GroupEvent{Group: group, Type: eventType, Sender: sender, Participants: participants, Value: value, Timestamp: evt.Timestamp}
+	}
+
+	var groupEvents []*GroupEvent
+	for _, change := range []struct {
+		name string
+		jids []types.JID
+	}{{"join", evt.Join}, {"leave", evt.Leave}, {"promote", evt.Promote}, {"demote", evt.Demote}} {
+		if len(change.jids) == 0 {
+			continue
+		}
+		participants := jidStrings(change.jids)
+		if err := s.srv.chatLog.ChangeGroupParticipants(deviceJID, group, change.name, participants); err != nil {
+			s.log.Errorf("Error updating group_participants: %v", err)
+		}
+		groupEvents = append(groupEvents, newEvent(change.name, participants, ""))
+	}
+
+	change := &ChatLogGroupChange{}
+	if evt.Name != nil {
+		change.Name = &evt.Name.Name
+		groupEvents = append(groupEvents, newEvent("subject", nil, evt.Name.Name))
+	}
+	if evt.Topic != nil {
+		change.Topic = &evt.Topic.Topic
+		groupEvents = append(groupEvents, newEvent("description", nil, evt.Topic.Topic))
+	}
+	if evt.Announce != nil {
+		change.Announce = &evt.Announce.IsAnnounce
+		groupEvents = append(groupEvents, newEvent("announce", nil, fmt.Sprint(evt.Announce.IsAnnounce)))
+	}
+	if evt.Locked != nil {
+		change.Locked = &evt.Locked.IsLocked
+		groupEvents = append(groupEvents, newEvent("locked", nil, fmt.Sprint(evt.Locked.IsLocked)))
+	}
+	if change.Name != nil || change.Topic != nil || change.Announce != nil || change.Locked != nil {
+		if err := s.srv.chatLog.UpdateGroup(deviceJID, group, change); err != nil {
+			s.log.Errorf("Error updating groups: %v", err)
+		}
+	}
+	if evt.NewInviteLink != nil {
+		link := *evt.NewInviteLink
+		if err := s.srv.chatLog.SetGroupInviteLink(deviceJID, group, link); err != nil {
+			s.log.Errorf("Error updating invite link of %s: %v", group, err)
+		}
+		groupEvents = append(groupEvents, newEvent("invite_link", nil, link))
+	}
+
+	for _, e := range groupEvents {
+		s.storeGroupEvent(e)
+	}
+}
+
+func (s *Session) handleJoinedGroup(evt *events.JoinedGroup) {
+	s.log.Infof("Joined group %s (%s)", evt.JID, evt.Name)
+	s.storeGroup(&evt.GroupInfo)
+
+	// Only a new group tells when we joined it.
+	var timestamp time.Time
+	if evt.Type == "new" {
+		timestamp = evt.GroupCreated
+	}
+	s.storeGroupEvent(&GroupEvent{
+		Group:        evt.JID.String(),
+		Type:         "joined",
+		Participants: []string{s.device.ID.ToNonAD().String()},
+		Value:        evt.Name,
+		Timestamp:    timestamp,
+	})
+}
+
+// storeGroupEvent stores a group event as a system message of the group and publishes it. An
+// event without a timestamp is stored at the time it arrived.
+func (s *Session) storeGroupEvent(e *GroupEvent) {
+	e.MessageID = groupEventID(e)
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	deviceJID := s.device.ID.String()
+	exists, err := s.srv.chatLog.MessageExists(e.MessageID, deviceJID, e.Group)
+	if err != nil {
+		s.log.Errorf("Error checking for group event %s: %v", e.MessageID, err)
+	} else if exists {
+		return
+	}
+	s.storeMessage(&ChatLogMessage{
+		MessageID: e.MessageID,
+		DeviceJID: deviceJID,
+		RemoteJID: e.Group,
+		Type:      "group_event",
+		Content:   e.content(),
+		Timestamp: e.Timestamp,
+		SenderJID: e.Sender,
+	})
+	s.publish("group_event", e)
+}
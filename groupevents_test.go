@@ -0,0 +1,89 @@
+package whatsappws
+
+import (
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestGroupInfoChange(t *testing.T) {
+	chatLog := NewMemoryChatLog()
+	_, fake := newTestServer(t, chatLog)
+	deviceJID := testPhone + "@s.whatsapp.net"
+	group := testGroup.String()
+	admin := types.NewJID("905550000001", types.DefaultUserServer)
+	member := types.NewJID("905550000002", types.DefaultUserServer)
+	newMember := types.NewJID("905550000003", types.DefaultUserServer)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	chatLog.SetGroup(&ChatLogGroup{DeviceJID: deviceJID, GroupJID: group, Name: "Team", ParticipantCount: 2})
+	chatLog.SetGroupParticipants(deviceJID, group, []ChatLogGroupParticipant{
+		{ParticipantJID: admin.String(), Admin: true},
+		{ParticipantJID: member.String()},
+	})
+
+	join := &events.GroupInfo{JID: testGroup, Sender: &newMember, Timestamp: start, Join: []types.JID{newMember}}
+	fake.Emit(join)
+	fake.Emit(&events.GroupInfo{JID: testGroup, Sender: &admin, Timestamp: start.Add(time.Minute), Leave: []types.JID{member}})
+	fake.Emit(&events.GroupInfo{JID: testGroup, Sender: &admin, Timestamp: start.Add(2 * time.Minute), Promote: []types.JID{newMember}})
+	rename := &events.GroupInfo{JID: testGroup, Name: &types.GroupName{Name: "Renamed"}}
+	before := time.Now()
+	fake.Emit(rename)
+	after := time.Now()
+	// A notification delivered twice is stored once, also if it has no timestamp.
+	fake.Emit(join)
+	time.Sleep(time.Millisecond)
+	fake.Emit(rename)
+
+	messages := chatLog.Messages()
+	if len(messages) != 4 {
+		t.Fatalf("stored %d group events, want 4: %+v", len(messages), messages)
+	}
+	for i, want := range []struct {
+		sender  string
+		content string
+	}{
+		{newMember.String(), newMember.String() + " joined"},
+		{admin.String(), admin.String() + " removed " + member.String()},
+		{admin.String(), admin.String() + " made " + newMember.String() + " admin"},
+		{"", `Someone changed the subject to "Renamed"`},
+	} {
+		if msg := messages[i]; msg.Type != "group_event" || msg.RemoteJID != group || msg.SenderJID != want.sender || msg.Content != want.content {
+			t.Errorf("group event %d is %+v, want %q from %q", i, msg, want.content, want.sender)
+		}
+	}
+	if ts := messages[3].Timestamp; ts.Before(before) || ts.After(after) {
+		t.Errorf("group event without a timestamp is at %s, want the time it was received", ts)
+	}
+
+	participants := chatLog.GroupParticipants(deviceJID, group)
+	if len(participants) != 2 || participants[0].ParticipantJID != admin.String() ||
+		participants[1].ParticipantJID != newMember.String() || !participants[1].Admin {
+		t.Errorf("participants are %+v", participants)
+	}
+	if stored, _, _ := chatLog.Group(deviceJID, group); stored.Name != "Renamed" || stored.ParticipantCount != 2 {
+		t.Errorf("group is %+v", stored)
+	}
+}
+
+func TestJoinedGroupDeliveredTwice(t *testing.T) {
+	chatLog := NewMemoryChatLog()
+	_, fake := newTestServer(t, chatLog)
+	joined := &events.JoinedGroup{Reason: "invite", GroupInfo: types.GroupInfo{
+		JID:       testGroup,
+		GroupName: types.GroupName{Name: "Team"},
+	}}
+	fake.Emit(joined)
+	time.Sleep(time.Millisecond)
+	fake.Emit(joined)
+
+	messages := chatLog.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("stored %d group events, want 1: %+v", len(messages), messages)
+	}
+	if msg := messages[0]; msg.Type != "group_event" || msg.Content != `Joined group "Team"` || msg.Timestamp.IsZero() {
+		t.Errorf("group event is %+v", msg)
+	}
+}
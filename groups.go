@@ -0,0 +1,565 @@
+package whatsappws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/types"
+)
+
+var (
+	errInvalidParticipantAction = errors.New("participant action must be add, remove, promote or demote")
+	errInvalidGroupPicture      = errors.New("invalid group picture")
+)
+
+// Group is a group and its participants as returned by the group endpoints and commands.
+type Group struct {
+	JID          string             `json:"jid"`
+	Name         string             `json:"name"`
+	Topic        string             `json:"topic"`
+	Owner        string             `json:"owner"`
+	Announce     bool               `json:"announce"`
+	Locked       bool               `json:"locked"`
+	CreatedAt    time.Time          `json:"created_at"`
+	Participants []GroupParticipant `json:"participants"`
+}
+
+// GroupParticipant is a participant of a group. Error is the status code of a failed participant
+// change, e.g. 403 if the participant can't be added by us.
+type GroupParticipant struct {
+	JID        string `json:"jid"`
+	Admin      bool   `json:"admin"`
+	SuperAdmin bool   `json:"super_admin"`
+	Error      int    `json:"error,omitempty"`
+}
+
+func newGroup(info *types.GroupInfo) *Group {
+	group := &Group{
+		JID:          info.JID.String(),
+		Name:         info.Name,
+		Topic:        info.Topic,
+		Announce:     info.IsAnnounce,
+		Locked:       info.IsLocked,
+		CreatedAt:    info.GroupCreated,
+		Participants: make([]GroupParticipant, len(info.Participants)),
+	}
+	if !info.OwnerJID.IsEmpty() {
+		group.Owner = info.OwnerJID.String()
+	}
+	for i, participant := range info.Participants {
+		group.Participants[i] = GroupParticipant{
+			JID:        participant.JID.String(),
+			Admin:      participant.IsAdmin || participant.IsSuperAdmin,
+			SuperAdmin: participant.IsSuperAdmin,
+			Error:      participant.Error,
+		}
+	}
+	return group
+}
+
+// parseGroupJID parses the JID of a group. A JID without a server is a group ID.
+func parseGroupJID(arg string) (types.JID, error) {
+	if arg != "" && !strings.ContainsRune(arg, '@') {
+		return types.NewJID(arg, types.GroupServer), nil
+	}
+	jid, err := parseJID(arg)
+	if err != nil {
+		return jid, err
+	} else if jid.Server != types.GroupServer {
+		return jid, fmt.Errorf("invalid group JID %s: not a group", arg)
+	}
+	return jid, nil
+}
+
+func parseJIDs(args []string) ([]types.JID, error) {
+	jids := make([]types.JID, len(args))
+	for i, arg := range args {
+		var err error
+		if jids[i], err = parseJID(arg); err != nil {
+			return nil, err
+		}
+	}
+	return jids, nil
+}
+
+var participantChanges = map[string]whatsmeow.ParticipantChange{
+	"add":     whatsmeow.ParticipantChangeAdd,
+	"remove":  whatsmeow.ParticipantChangeRemove,
+	"promote": whatsmeow.ParticipantChangePromote,
+	"demote":  whatsmeow.ParticipantChangeDemote,
+}
+
+// storeGroup mirrors the info of a group into the chat log.
+func (s *Session) storeGroup(info *types.GroupInfo) {
+	group := &ChatLogGroup{
+		DeviceJID:        s.device.ID.String(),
+		GroupJID:         info.JID.String(),
+		Name:             info.Name,
+		Topic:            info.Topic,
+		Announce:         info.IsAnnounce,
+		Locked:           info.IsLocked,
+		ParticipantCount: len(info.Participants),
+		CreatedAt:        info.GroupCreated,
+		UpdatedAt:        time.Now(),
+	}
+	if !info.OwnerJID.IsEmpty() {
+		group.OwnerJID = info.OwnerJID.String()
+	}
+	if err := s.srv.chatLog.SetGroup(group); err != nil {
+		s.log.Errorf("Error inserting into groups: %v", err)
+	}
+	participants := make([]ChatLogGroupParticipant, len(info.Participants))
+	for i, participant := range info.Participants {
+		participants[i] = ChatLogGroupParticipant{
+			ParticipantJID: participant.JID.String(),
+			Admin:          participant.IsAdmin,
+			SuperAdmin:     participant.IsSuperAdmin,
+		}
+	}
+	if err := s.srv.chatLog.SetGroupParticipants(group.DeviceJID, group.GroupJID, participants); err != nil {
+		s.log.Errorf("Error inserting into group_participants: %v", err)
+	}
+}
+
+// joinedGroups returns and stores the groups we're participating in.
+func (s *Session) joinedGroups() ([]*Group, error) {
+	infos, err := s.cli.GetJoinedGroups()
+	if err != nil {
+		return nil, fmt.Errorf("error getting joined groups: %w", err)
+	}
+	groups := make([]*Group, len(infos))
+	for i, info := range infos {
+		s.storeGroup(info)
+		groups[i] = newGroup(info)
+	}
+	return groups, nil
+}
+
+// groupInfo fetches and stores the current info of a group. It's also called after changing a
+// group, so that the stored info is up to date.
+func (s *Session) groupInfo(jid types.JID) (*Group, error) {
+	info, err := s.cli.GetGroupInfo(jid)
+	if err != nil {
+		return nil, fmt.Errorf("error getting info of group %s: %w", jid, err)
+	}
+	s.storeGroup(info)
+	return newGroup(info), nil
+}
+
+func (s *Session) createGroup(name string, participants []types.JID) (*Group, error) {
+	if name == "" {
+		return nil, errors.New("group name is required")
+	}
+	s.log.Infof("Creating group %q with %v", name, participants)
+	info, err := s.cli.CreateGroup(whatsmeow.ReqCreateGroup{Name: name, Participants: participants})
+	if err != nil {
+		return nil, fmt.Errorf("error creating group: %w", err)
+	}
+	s.storeGroup(info)
+	return newGroup(info), nil
+}
+
+// updateGroupParticipants adds, removes, promotes or demotes participants. It returns the result
+// for every participant, since some changes can fail while others succeed.
+func (s *Session) updateGroupParticipants(jid types.JID, action string, participants []types.JID) ([]GroupParticipant, error) {
+	change, ok := participantChanges[action]
+	if !ok {
+		return nil, errInvalidParticipantAction
+	} else if len(participants) == 0 {
+		return nil, errors.New("at least one participant is required")
+	}
+	changes := make(map[types.JID]whatsmeow.ParticipantChange, len(participants))
+	for _, participant := range participants {
+		changes[participant] = change
+	}
+
+	s.log.Infof("Changing participants of group %s: %s %v", jid, action, participants)
+	resp, err := s.cli.UpdateGroupParticipants(jid, changes)
+	if err != nil {
+		return nil, fmt.Errorf("error changing participants of group %s: %w", jid, err)
+	}
+	results := participantResults(resp)
+	if _, err = s.groupInfo(jid); err != nil {
+		s.log.Warnf("Failed to refresh group after changing participants: %v", err)
+	}
+	return results, nil
+}
+
+// participantResults reads the participant nodes of the response to a participant change.
+func participantResults(resp *waBinary.Node) []GroupParticipant {
+	results := []GroupParticipant{}
+	for _, changeNode := range resp.GetChildren() {
+		for _, participantNode := range changeNode.GetChildrenByTag("participant") {
+			ag := participantNode.AttrGetter()
+			results = append(results, GroupParticipant{
+				JID:   ag.JID("jid").String(),
+				Error: ag.OptionalInt("error"),
+			})
+		}
+	}
+	return results
+}
+
+func (s *Session) setGroupSubject(jid types.JID, subject string) (*Group, error) {
+	if subject == "" {
+		return nil, errors.New("group subject is required")
+	}
+	if err := s.cli.SetGroupName(jid, subject); err != nil {
+		return nil, fmt.Errorf("error setting subject of group %s: %w", jid, err)
+	}
+	return s.groupInfo(jid)
+}
+
+// setGroupDescription changes the description of a group, or removes it if it's empty.
+func (s *Session) setGroupDescription(jid types.JID, description string) (*Group, error) {
+	if err := s.cli.SetGroupTopic(jid, "", "", description); err != nil {
+		return nil, fmt.Errorf("error setting description of group %s: %w", jid, err)
+	}
+	return s.groupInfo(jid)
+}
+
+// setGroupPicture converts an image to a square JPEG and makes it the group picture. It returns
+// the ID of the new picture.
+func (s *Session) setGroupPicture(jid types.JID, data []byte) (string, error) {
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidGroupPicture, err)
+	}
+	var buf bytes.Buffer
+	err = imaging.Encode(&buf, imaging.Fill(img, 640, 640, imaging.Center, imaging.Lanczos), imaging.JPEG, imaging.JPEGQuality(90))
+	if err != nil {
+		return "", fmt.Errorf("error encoding picture: %w", err)
+	}
+	pictureID, err := s.cli.SetGroupPhoto(jid, buf.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("error setting picture of group %s: %w", jid, err)
+	}
+	return pictureID, nil
+}
+
+// groupInviteLink returns the invite link of a group. If reset is true, the current link is revoked
+// and a new one is returned.
+func (s *Session) groupInviteLink(jid types.JID, reset bool) (string, error) {
+	link, err := s.cli.GetGroupInviteLink(jid, reset)
+	if err != nil {
+		return "", fmt.Errorf("error getting invite link of group %s: %w", jid, err)
+	}
+	if err = s.srv.chatLog.SetGroupInviteLink(s.device.ID.String(), jid.String(), link); err != nil {
+		s.log.Errorf("Error storing invite link: %v", err)
+	}
+	return link, nil
+}
+
+// joinGroup joins a group with an invite link or code.
+func (s *Session) joinGroup(link string) (*Group, error) {
+	if link == "" {
+		return nil, errors.New("invite link is required")
+	}
+	jid, err := s.cli.JoinGroupWithLink(link)
+	if err != nil {
+		return nil, fmt.Errorf("error joining group: %w", err)
+	}
+	s.log.Infof("Joined group %s", jid)
+	return s.groupInfo(jid)
+}
+
+func (s *Session) handleGroups() (interface{}, error) {
+	return s.joinedGroups()
+}
+
+func (s *Session) handleGroupInfo(args []string) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, errors.New("usage: groupinfo <group_jid>")
+	}
+	jid, err := parseGroupJID(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return s.groupInfo(jid)
+}
+
+func (s *Session) handleCreateGroup(args []string) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, errors.New("usage: creategroup <name> [jid...]")
+	}
+	participants, err := parseJIDs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return s.createGroup(args[0], participants)
+}
+
+func (s *Session) handleGroupParticipants(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errors.New("usage: groupparticipants <group_jid> <add|remove|promote|demote> <jid...>")
+	}
+	jid, err := parseGroupJID(args[0])
+	if err != nil {
+		return nil, err
+	}
+	participants, err := parseJIDs(args[2:])
+	if err != nil {
+		return nil, err
+	}
+	return s.updateGroupParticipants(jid, args[1], participants)
+}
+
+func (s *Session) handleGroupSubject(args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, errors.New("usage: groupsubject <group_jid> <subject>")
+	}
+	jid, err := parseGroupJID(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return s.setGroupSubject(jid, strings.Join(args[1:], " "))
+}
+
+func (s *Session) handleGroupDescription(args []string) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, errors.New("usage: groupdescription <group_jid> [description]")
+	}
+	jid, err := parseGroupJID(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return s.setGroupDescription(jid, strings.Join(args[1:], " "))
+}
+
+func (s *Session) handleGroupInvite(args []string) (interface{}, error) {
+	if len(args) < 1 || (len(args) > 1 && args[1] != "revoke") {
+		return nil, errors.New("usage: groupinvite <group_jid> [revoke]")
+	}
+	jid, err := parseGroupJID(args[0])
+	if err != nil {
+		return nil, err
+	}
+	link, err := s.groupInviteLink(jid, len(args) > 1)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"invite_link": link}, nil
+}
+
+func (s *Session) handleJoinGroup(args []string) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, errors.New("usage: joingroup <invite_link>")
+	}
+	return s.joinGroup(args[0])
+}
+
+// groupErrorStatus returns the HTTP status of a group error.
+func groupErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, whatsmeow.ErrGroupNotFound), errors.Is(err, whatsmeow.ErrIQNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, whatsmeow.ErrNotInGroup), errors.Is(err, whatsmeow.ErrGroupInviteLinkUnauthorized),
+		errors.Is(err, whatsmeow.ErrIQForbidden), errors.Is(err, whatsmeow.ErrIQNotAuthorized):
+		return http.StatusForbidden
+	case errors.Is(err, whatsmeow.ErrInviteLinkRevoked):
+		return http.StatusGone
+	case errors.Is(err, whatsmeow.ErrInviteLinkInvalid), errors.Is(err, whatsmeow.ErrInvalidImageFormat),
+		errors.Is(err, whatsmeow.ErrIQBadRequest), errors.Is(err, errInvalidParticipantAction),
+		errors.Is(err, errInvalidGroupPicture):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (srv *Server) handleGroupError(w http.ResponseWriter, message string, err error) {
+	if status := groupErrorStatus(err); status != http.StatusInternalServerError {
+		http.Error(w, err.Error(), status)
+	} else {
+		srv.handleError(w, status, message, err)
+	}
+}
+
+// serveGroups lists the joined groups or creates a group: GET /groups and POST /groups
+func (srv *Server) serveGroups(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "GET, POST")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	sess := srv.requestSession(r)
+	if sess == nil || !sess.cli.IsLoggedIn() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		groups, err := sess.joinedGroups()
+		if err != nil {
+			srv.handleGroupError(w, "Failed to get groups", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, groups)
+	case http.MethodPost:
+		var body struct {
+			Name         string   `json:"name" validate:"required"`
+			Participants []string `json:"participants"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+			return
+		}
+		participants, err := parseJIDs(body.Participants)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if body.Name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		group, err := sess.createGroup(body.Name, participants)
+		if err != nil {
+			srv.handleGroupError(w, "Failed to create group", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, group)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// serveGroup serves a single group:
+// GET /groups/{jid}, POST /groups/{jid}/participants, POST /groups/{jid}/subject,
+// POST /groups/{jid}/description, POST /groups/{jid}/picture, POST /groups/{jid}/invite,
+// POST /groups/{jid}/invite/revoke and POST /groups/join
+func (srv *Server) serveGroup(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "GET, POST")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	sess := srv.requestSession(r)
+	if sess == nil || !sess.cli.IsLoggedIn() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/groups/")
+	if path == "join" {
+		srv.serveJoinGroup(w, r, sess)
+		return
+	}
+	parts := strings.SplitN(path, "/", 2)
+	jid, err := parseGroupJID(parts[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var action string
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	if (action == "") != (r.Method == http.MethodGet) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var result interface{}
+	switch action {
+	case "":
+		result, err = sess.groupInfo(jid)
+	case "participants":
+		var body struct {
+			Action       string   `json:"action" validate:"required"`
+			Participants []string `json:"participants" validate:"required"`
+		}
+		if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+			return
+		}
+		if len(body.Participants) == 0 {
+			http.Error(w, "Participants are required", http.StatusBadRequest)
+			return
+		}
+		var participants []types.JID
+		if participants, err = parseJIDs(body.Participants); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err = sess.updateGroupParticipants(jid, body.Action, participants)
+	case "subject", "description":
+		var body struct {
+			Subject     string `json:"subject"`
+			Description string `json:"description"`
+		}
+		if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+			return
+		}
+		if action == "description" {
+			result, err = sess.setGroupDescription(jid, body.Description)
+		} else if body.Subject == "" {
+			http.Error(w, "Subject is required", http.StatusBadRequest)
+			return
+		} else {
+			result, err = sess.setGroupSubject(jid, body.Subject)
+		}
+	case "picture":
+		file, _, fileErr := r.FormFile("file")
+		if fileErr != nil {
+			srv.handleError(w, http.StatusBadRequest, "Failed to retrieve file from request", fileErr)
+			return
+		}
+		defer file.Close()
+		var data []byte
+		if data, err = io.ReadAll(file); err != nil {
+			srv.handleError(w, http.StatusInternalServerError, "Failed to read file data", err)
+			return
+		}
+		var pictureID string
+		if pictureID, err = sess.setGroupPicture(jid, data); err == nil {
+			result = map[string]string{"picture_id": pictureID}
+		}
+	case "invite", "invite/revoke":
+		var link string
+		if link, err = sess.groupInviteLink(jid, action == "invite/revoke"); err == nil {
+			result = map[string]string{"invite_link": link}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		srv.handleGroupError(w, "Failed to update group", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (srv *Server) serveJoinGroup(w http.ResponseWriter, r *http.Request, sess *Session) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Link string `json:"link" validate:"required"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	} else if body.Link == "" {
+		http.Error(w, "Link is required", http.StatusBadRequest)
+		return
+	}
+	group, err := sess.joinGroup(body.Link)
+	if err != nil {
+		srv.handleGroupError(w, "Failed to join group", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, group)
+}
@@ -0,0 +1,99 @@
+package whatsappws
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestCreateGroupAndChangeParticipants(t *testing.T) {
+	srv, fake := newTestServer(t, nil)
+	deviceJID := testPhone + "@s.whatsapp.net"
+	alice := types.NewJID("905550000001", types.DefaultUserServer).String()
+	bob := types.NewJID("905550000002", types.DefaultUserServer).String()
+	carol := types.NewJID("905550000003", types.DefaultUserServer).String()
+
+	// storedParticipants returns the group_participants rows as JIDs with a * for admins.
+	storedParticipants := func(group string) []string {
+		t.Helper()
+		rows, err := srv.db.Query(`
+			SELECT participant_jid, admin FROM group_participants
+			WHERE device_jid = $1 AND group_jid = $2 ORDER BY participant_jid
+		`, deviceJID, group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var participants []string
+		for rows.Next() {
+			var jid string
+			var admin bool
+			if err = rows.Scan(&jid, &admin); err != nil {
+				t.Fatal(err)
+			}
+			if admin {
+				jid += "*"
+			}
+			participants = append(participants, jid)
+		}
+		return participants
+	}
+	storedGroup := func(group string) (name string, count int) {
+		t.Helper()
+		err := srv.db.QueryRow(`SELECT name, participant_count FROM groups WHERE device_jid = $1 AND group_jid = $2`, deviceJID, group).Scan(&name, &count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return name, count
+	}
+	var group Group
+	w := serveJSON(t, srv, http.MethodPost, "/groups", map[string]interface{}{"name": "Team", "participants": []string{alice, bob}})
+	decodeResponse(t, w, http.StatusOK, &group)
+	if group.Name != "Team" || len(group.Participants) != 2 {
+		t.Errorf("created group is %+v", group)
+	}
+	groupJID, err := types.ParseJID(group.JID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fake.GetGroupInfo(groupJID); err != nil || info.Name != "Team" || len(info.Participants) != 2 {
+		t.Errorf("group on the server is %+v: %v", info, err)
+	}
+	if name, count := storedGroup(group.JID); name != "Team" || count != 2 {
+		t.Errorf("stored group is %q with %d participants", name, count)
+	}
+	if got := storedParticipants(group.JID); !reflect.DeepEqual(got, []string{alice, bob}) {
+		t.Errorf("stored participants after creating the group are %v", got)
+	}
+
+	change := func(action string, participants ...string) []GroupParticipant {
+		t.Helper()
+		var results []GroupParticipant
+		w := serveJSON(t, srv, http.MethodPost, "/groups/"+group.JID+"/participants", map[string]interface{}{"action": action, "participants": participants})
+		decodeResponse(t, w, http.StatusOK, &results)
+		return results
+	}
+	if results := change("add", carol); len(results) != 1 || results[0].JID != carol || results[0].Error != 0 {
+		t.Errorf("results of adding are %+v", results)
+	}
+	change("promote", alice)
+	change("remove", bob)
+	// Changes that fail for a participant are reported in the results.
+	if results := change("add", alice); len(results) != 1 || results[0].JID != alice || results[0].Error != http.StatusConflict {
+		t.Errorf("results of adding a participant twice are %+v", results)
+	}
+
+	if got := storedParticipants(group.JID); !reflect.DeepEqual(got, []string{alice + "*", carol}) {
+		t.Errorf("stored participants after the changes are %v", got)
+	}
+	if _, count := storedGroup(group.JID); count != 2 {
+		t.Errorf("stored participant count is %d, want 2", count)
+	}
+
+	w = serveJSON(t, srv, http.MethodPost, "/groups/"+group.JID+"/participants", map[string]interface{}{"action": "ban", "participants": []string{bob}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status of an invalid action is %d, want 400", w.Code)
+	}
+}
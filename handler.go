@@ -1,6 +1,8 @@
-package main
+package whatsappws
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 
 	"go.mau.fi/whatsmeow/types"
@@ -14,63 +16,151 @@ type Message struct {
 	Body      string
 	Sent      bool
 	FileName  string
+	Session   string
 }
 
-func handleCmd(command Command) {
+// Event is pushed to WebSocket clients for everything other than chat messages, which are sent as
+// a plain Message for compatibility.
+type Event struct {
+	Type    string      `json:"type"`
+	Session string      `json:"session"`
+	Data    interface{} `json:"data"`
+}
+
+// publish sends an event of the session to the WebSocket clients and the webhooks.
+func (s *Session) publish(eventType string, data interface{}) {
+	evt := Event{Type: eventType, Session: s.ID(), Data: data}
+	s.srv.hub.Broadcast(s, evt)
+	s.srv.webhooks.Dispatch(evt)
+}
+
+// publishMessage sends a chat message to the WebSocket clients as is, and to the webhooks as a
+// message event.
+func (s *Session) publishMessage(m Message) {
+	s.srv.hub.Broadcast(s, m)
+	s.srv.webhooks.Dispatch(Event{Type: "message", Session: s.ID(), Data: m})
+}
+
+// handleCmd runs a command and returns its result, which is sent back to the WebSocket client
+// in a Reply.
+func (s *Session) handleCmd(command Command) (interface{}, error) {
 	switch command.Cmd {
 	case "isloggedin":
-		handleIsLoggedIn()
+		return s.handleIsLoggedIn()
 	case "checkuser":
-		handleCheckUser(command.Arguments)
+		return s.handleCheckUser(command.Arguments)
 	case "send":
-		handleSendTextMessage(command.Arguments, command.UserID)
+		opts, err := parseTextOptions(command.QuotedMessageID, command.Mentions)
+		if err != nil {
+			return nil, err
+		}
+		return s.handleSendTextMessage(command.Arguments, command.UserID, opts)
 	case "markread":
-		handleMarkRead(command.Arguments)
+		return s.handleMarkRead(command.Arguments)
+	case "edit":
+		return s.handleEditMessage(command.Arguments)
+	case "revoke":
+		return s.handleRevokeMessage(command.Arguments)
+	case "react":
+		return s.handleReact(command.Arguments)
+	case "location":
+		return s.handleSendLocation(command.Arguments, command.UserID)
+	case "contact":
+		return s.handleSendContact(command.Arguments, command.UserID)
+	case "search":
+		return s.handleSearch(command.Arguments)
+	case "groups":
+		return s.handleGroups()
+	case "groupinfo":
+		return s.handleGroupInfo(command.Arguments)
+	case "creategroup":
+		return s.handleCreateGroup(command.Arguments)
+	case "groupparticipants":
+		return s.handleGroupParticipants(command.Arguments)
+	case "groupsubject":
+		return s.handleGroupSubject(command.Arguments)
+	case "groupdescription":
+		return s.handleGroupDescription(command.Arguments)
+	case "groupinvite":
+		return s.handleGroupInvite(command.Arguments)
+	case "joingroup":
+		return s.handleJoinGroup(command.Arguments)
+	default:
+		return nil, fmt.Errorf("unknown command %q", command.Cmd)
+	}
+}
+
+// commandScope returns the scope needed to run a command over the WebSocket.
+func commandScope(cmd string) string {
+	switch cmd {
+	case "send", "markread", "edit", "revoke", "react", "location", "contact",
+		"creategroup", "groupparticipants", "groupsubject", "groupdescription", "groupinvite", "joingroup":
+		return scopeSend
+	default:
+		return scopeRead
 	}
 }
 
-// Handler is a simple eventHandler for incoming events.
-func eventHandler(rawEvt interface{}) {
+// Handler is a simple eventHandler for incoming events of a session.
+func (s *Session) eventHandler(rawEvt interface{}) {
 	switch evt := rawEvt.(type) {
 	case *events.AppStateSyncComplete:
-		handleAppStateSyncComplete(evt)
+		s.handleAppStateSyncComplete(evt)
 	case *events.Connected, *events.PushNameSetting:
-		handleConnectedOrPushNameSetting(evt)
+		if _, ok := evt.(*events.Connected); ok {
+			s.publishConnectionState("connected")
+		}
+		s.handleConnectedOrPushNameSetting(evt)
+	case *events.Disconnected:
+		s.publishConnectionState("disconnected")
+	case *events.LoggedOut:
+		s.publishConnectionState("logged_out")
+	case *events.PairSuccess:
+		s.handlePairSuccess(evt)
+		s.publishConnectionState("paired")
 	case *events.StreamReplaced:
-		handleStreamReplaced(evt)
+		s.publishConnectionState("stream_replaced")
+		s.handleStreamReplaced(evt)
 	case *events.Message:
-		handleMessage(evt)
+		s.handleMessage(evt)
 	case *events.Receipt:
-		handleReceipt(evt)
+		s.handleReceipt(evt)
 	case *events.Presence:
-		handlePresence(evt)
+		s.handlePresence(evt)
+	case *events.GroupInfo:
+		s.handleGroupInfoChange(evt)
+	case *events.JoinedGroup:
+		s.handleJoinedGroup(evt)
+	case *events.MediaRetry:
+		s.handleMediaRetry(evt)
 	case *events.HistorySync:
-		handleHistorySync(evt)
+		s.handleHistorySync(evt)
 	case *events.AppState:
-		handleAppState(evt)
+		s.handleAppState(evt)
 	case *events.KeepAliveTimeout:
-		handleKeepAliveTimeout(evt)
+		s.handleKeepAliveTimeout(evt)
 	case *events.KeepAliveRestored:
-		handleKeepAliveRestored(evt)
+		s.handleKeepAliveRestored(evt)
 	}
 }
 
 // Parse a JID from a string. If the string starts with a +, it is removed.
-func parseJID(arg string) (types.JID, bool) {
+func parseJID(arg string) (types.JID, error) {
+	if arg == "" {
+		return types.EmptyJID, errors.New("invalid JID: empty string")
+	}
 	if arg[0] == '+' {
 		arg = arg[1:]
 	}
 	if !strings.ContainsRune(arg, '@') {
-		return types.NewJID(arg, types.DefaultUserServer), true
+		return types.NewJID(arg, types.DefaultUserServer), nil
 	} else {
 		recipient, err := types.ParseJID(arg)
 		if err != nil {
-			log.Errorf("Invalid JID %s: %v", arg, err)
-			return recipient, false
+			return recipient, fmt.Errorf("invalid JID %s: %w", arg, err)
 		} else if recipient.User == "" {
-			log.Errorf("Invalid JID %s: no server specified", arg)
-			return recipient, false
+			return recipient, fmt.Errorf("invalid JID %s: no server specified", arg)
 		}
-		return recipient, true
+		return recipient, nil
 	}
 }
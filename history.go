@@ -0,0 +1,95 @@
+package whatsappws
+
+import (
+	"sync/atomic"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// HistorySyncEvent reports the progress of a history sync import to the WebSocket clients.
+type HistorySyncEvent struct {
+	ID            int32  `json:"id"`
+	SyncType      string `json:"sync_type"`
+	ChunkOrder    uint32 `json:"chunk_order"`
+	Progress      uint32 `json:"progress"`
+	Conversations int    `json:"conversations"`
+	Imported      int    `json:"imported"`
+	Skipped       int    `json:"skipped"`
+	Done          bool   `json:"done"`
+}
+
+// handleHistorySync imports the conversations of a history sync chunk into messages and
+// last_messages. Messages that are already stored are skipped, so re-syncs don't create duplicates.
+func (s *Session) handleHistorySync(evt *events.HistorySync) {
+	progress := HistorySyncEvent{
+		ID:            atomic.AddInt32(&s.srv.historySyncID, 1),
+		SyncType:      evt.Data.GetSyncType().String(),
+		ChunkOrder:    evt.Data.GetChunkOrder(),
+		Progress:      evt.Data.GetProgress(),
+		Conversations: len(evt.Data.GetConversations()),
+	}
+	s.log.Infof("Importing history sync %d (%s, chunk %d, %d%%) with %d conversations", progress.ID, progress.SyncType, progress.ChunkOrder, progress.Progress, progress.Conversations)
+	s.publish("history_sync", progress)
+
+	deviceJID := s.device.ID.String()
+	for _, conv := range evt.Data.GetConversations() {
+		chatJID, err := types.ParseJID(conv.GetId())
+		if err != nil {
+			s.log.Warnf("Skipping history sync conversation with invalid JID %s: %v", conv.GetId(), err)
+			continue
+		}
+		if chatJID.String() == "status@broadcast" {
+			continue
+		}
+
+		var last *ChatLogMessage
+		for _, histMsg := range conv.GetMessages() {
+			msgEvt, err := s.cli.ParseWebMessage(chatJID, histMsg.GetMessage())
+			if err != nil {
+				s.log.Warnf("Failed to parse history message in %s: %v", chatJID, err)
+				progress.Skipped++
+				continue
+			}
+			msg := s.chatLogMessage(msgEvt)
+			if msg == nil {
+				progress.Skipped++
+				continue
+			}
+
+			exists, err := s.srv.chatLog.MessageExists(msg.MessageID, deviceJID, msg.RemoteJID)
+			if err != nil {
+				s.log.Errorf("Error checking for existing message: %v", err)
+				continue
+			}
+			if exists {
+				progress.Skipped++
+			} else if err = s.srv.chatLog.InsertMessage(msg); err != nil {
+				s.log.Errorf("Error inserting into messages: %v", err)
+				continue
+			} else {
+				progress.Imported++
+				// Old media is only downloaded when it's requested from /media.
+				s.storeMedia(&msgEvt.Info, msgEvt.Message)
+			}
+			if poll := pollCreation(msgEvt.Message); poll != nil {
+				s.storePoll(msgEvt.Info.ID, msgEvt.Info.Chat, poll, msgEvt.Info.Timestamp)
+			}
+
+			if last == nil || msg.Timestamp.After(last.Timestamp) {
+				last = msg
+			}
+		}
+
+		if last != nil {
+			err = s.srv.chatLog.SetLastMessageIfNewer(last)
+			if err != nil {
+				s.log.Errorf("Error inserting into last_messages: %v", err)
+			}
+		}
+	}
+
+	progress.Done = true
+	s.log.Infof("Imported history sync %d: %d messages imported, %d skipped", progress.ID, progress.Imported, progress.Skipped)
+	s.publish("history_sync", progress)
+}
@@ -0,0 +1,120 @@
+package whatsappws
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// historyMessage builds a message of a history sync conversation.
+func historyMessage(id string, fromMe bool, timestamp int64, msg *waProto.Message) *waProto.HistorySyncMsg {
+	return &waProto.HistorySyncMsg{Message: &waProto.WebMessageInfo{
+		Key:              &waProto.MessageKey{Id: proto.String(id), FromMe: proto.Bool(fromMe)},
+		Message:          msg,
+		MessageTimestamp: proto.Uint64(uint64(timestamp)),
+	}}
+}
+
+func TestHistorySync(t *testing.T) {
+	chatLog := NewMemoryChatLog()
+	_, fake := newTestServer(t, chatLog)
+	deviceJID := testPhone + "@s.whatsapp.net"
+	chat := "905550000000@s.whatsapp.net"
+
+	stub := historyMessage("STUB", false, 1700000500, nil)
+	stub.Message.MessageStubType = waProto.WebMessageInfo_E2E_ENCRYPTED.Enum()
+	sync := &events.HistorySync{Data: &waProto.HistorySync{
+		SyncType: waProto.HistorySync_INITIAL_BOOTSTRAP.Enum(),
+		Conversations: []*waProto.Conversation{{
+			Id: proto.String(chat),
+			Messages: []*waProto.HistorySyncMsg{
+				historyMessage("TEXT", false, 1700000100, &waProto.Message{Conversation: proto.String("Hello")}),
+				historyMessage("IMAGE", true, 1700000200, &waProto.Message{ImageMessage: &waProto.ImageMessage{
+					Caption:    proto.String("Photo"),
+					Mimetype:   proto.String("image/jpeg"),
+					DirectPath: proto.String("/v/image"),
+					MediaKey:   []byte("key"),
+				}}),
+				historyMessage("REACTION", false, 1700000300, &waProto.Message{ReactionMessage: &waProto.ReactionMessage{
+					Key:  &waProto.MessageKey{Id: proto.String("IMAGE"), FromMe: proto.Bool(true), RemoteJid: proto.String(chat)},
+					Text: proto.String("👍"),
+				}}),
+				historyMessage("VOTE", false, 1700000400, &waProto.Message{PollUpdateMessage: &waProto.PollUpdateMessage{
+					PollCreationMessageKey: &waProto.MessageKey{Id: proto.String("POLL")},
+				}}),
+				stub,
+				historyMessage("UNKNOWN", false, 1700000600, &waProto.Message{SenderKeyDistributionMessage: &waProto.SenderKeyDistributionMessage{}}),
+			},
+		}, {
+			// Messages between our own devices, e.g. the security notification setting sent on login.
+			Id: proto.String(deviceJID),
+			Messages: []*waProto.HistorySyncMsg{
+				historyMessage("PEER", true, 1700000700, &waProto.Message{ProtocolMessage: &waProto.ProtocolMessage{
+					Type: waProto.ProtocolMessage_INITIAL_SECURITY_NOTIFICATION_SETTING_SYNC.Enum(),
+				}}),
+			},
+		}, {
+			Id:       proto.String("status@broadcast"),
+			Messages: []*waProto.HistorySyncMsg{historyMessage("STATUS", false, 1700000800, &waProto.Message{Conversation: proto.String("Status")})},
+		}},
+	}}
+	fake.Emit(sync)
+
+	messages := chatLog.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("imported %d messages, want 2: %+v", len(messages), messages)
+	}
+	if msg := messages[0]; msg.MessageID != "TEXT" || msg.Type != "text" || msg.Content != "Hello" || msg.Sent || msg.SenderJID != chat {
+		t.Errorf("text message is %+v", msg)
+	}
+	if msg := messages[1]; msg.MessageID != "IMAGE" || msg.Type != "media" || msg.Content != "Photo" || !msg.Sent {
+		t.Errorf("image message is %+v", msg)
+	}
+	last, ok := chatLog.LastMessage(deviceJID, chat)
+	if !ok || last.MessageID != "IMAGE" || !last.Timestamp.Equal(time.Unix(1700000200, 0)) {
+		t.Errorf("last message is %+v", last)
+	}
+	if _, ok = chatLog.LastMessage(deviceJID, deviceJID); ok {
+		t.Error("peer conversation has a last message")
+	}
+	if media := chatLog.Media(); len(media) != 1 || media[0].MessageID != "IMAGE" || media[0].Status != mediaPending {
+		t.Errorf("media is %+v", media)
+	}
+
+	// A repeated sync doesn't duplicate messages.
+	fake.Emit(sync)
+	if messages = chatLog.Messages(); len(messages) != 2 {
+		t.Errorf("%d messages after a repeated sync, want 2", len(messages))
+	}
+}
+
+func TestHistorySyncPoll(t *testing.T) {
+	srv, fake := newTestServer(t, NewMemoryChatLog())
+	chat := "905550000000@s.whatsapp.net"
+	fake.Emit(&events.HistorySync{Data: &waProto.HistorySync{
+		SyncType: waProto.HistorySync_RECENT.Enum(),
+		Conversations: []*waProto.Conversation{{
+			Id: proto.String(chat),
+			Messages: []*waProto.HistorySyncMsg{
+				historyMessage("POLL", false, 1700000100, &waProto.Message{PollCreationMessageV3: &waProto.PollCreationMessage{
+					Name:                   proto.String("Lunch?"),
+					Options:                []*waProto.PollCreationMessage_Option{{OptionName: proto.String("Pizza")}, {OptionName: proto.String("Sushi")}},
+					SelectableOptionsCount: proto.Uint32(1),
+				}}),
+			},
+		}},
+	}})
+
+	var tally PollTally
+	decodeResponse(t, serve(srv, http.MethodGet, "/polls/POLL?chat="+chat, "", nil), http.StatusOK, &tally)
+	if tally.Name != "Lunch?" || tally.SelectableCount != 1 || len(tally.Options) != 2 || tally.Options[0].Name != "Pizza" || tally.Options[1].Name != "Sushi" {
+		t.Errorf("tally of the imported poll is %+v", tally)
+	}
+	if w := serve(srv, http.MethodDelete, "/polls/POLL?chat="+chat, "", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status of DELETE is %d, want 405", w.Code)
+	}
+}
@@ -0,0 +1,210 @@
+package whatsappws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	// Time allowed to write a message to the peer.
+	wsWriteWait = 10 * time.Second
+	// Time allowed to read the next pong message from the peer.
+	wsPongWait = 60 * time.Second
+	// Send pings to peer with this period. Must be less than wsPongWait.
+	wsPingPeriod = (wsPongWait * 9) / 10
+	// Number of outgoing messages buffered per client before it's considered too slow and dropped.
+	wsSendBuffer = 256
+)
+
+// Hub keeps track of the connected WebSocket clients and fans out events to them.
+type Hub struct {
+	log        waLog.Logger
+	clients    map[*wsClient]bool
+	broadcast  chan hubMessage
+	register   chan *wsClient
+	unregister chan *wsClient
+}
+
+type hubMessage struct {
+	session *Session
+	data    []byte
+
+	// If set, the message is only sent to this client.
+	client *wsClient
+}
+
+// wsClient is a single WebSocket connection. Everything written to the connection goes through the
+// send queue, which is drained by writePump, so there is only ever one writer per connection.
+type wsClient struct {
+	srv  *Server
+	conn *websocket.Conn
+	send chan []byte
+
+	// The session the client connected to with /sessions/{id}/ws. Clients of /ws have no session
+	// and receive the events of every session.
+	session *Session
+	// The authenticated caller, nil if authentication is disabled.
+	identity *Identity
+}
+
+func newHub(log waLog.Logger) *Hub {
+	return &Hub{
+		log:        log,
+		clients:    make(map[*wsClient]bool),
+		broadcast:  make(chan hubMessage, wsSendBuffer),
+		register:   make(chan *wsClient),
+		unregister: make(chan *wsClient),
+	}
+}
+
+func (h *Hub) run() {
+	for {
+		select {
+		case client := <-h.register:
+			h.clients[client] = true
+		case client := <-h.unregister:
+			if _, ok := h.clients[client]; ok {
+				delete(h.clients, client)
+				close(client.send)
+			}
+		case msg := <-h.broadcast:
+			if msg.client != nil {
+				if _, ok := h.clients[msg.client]; ok {
+					h.send(msg.client, msg.data)
+				}
+				continue
+			}
+			for client := range h.clients {
+				if client.session != nil && msg.session != nil && client.session != msg.session {
+					continue
+				}
+				h.send(client, msg.data)
+			}
+		}
+	}
+}
+
+func (h *Hub) send(client *wsClient, data []byte) {
+	select {
+	case client.send <- data:
+	default:
+		h.log.Warnf("WebSocket client %s is too slow, dropping it", client.conn.RemoteAddr())
+		delete(h.clients, client)
+		close(client.send)
+	}
+}
+
+// Broadcast sends v as JSON to every client subscribed to the given session.
+func (h *Hub) Broadcast(sess *Session, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		h.log.Errorf("Failed to encode WebSocket event: %v", err)
+		return
+	}
+	h.broadcast <- hubMessage{session: sess, data: data}
+}
+
+// reply sends v as JSON to a single client.
+func (c *wsClient) reply(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.srv.log.Errorf("Failed to encode WebSocket reply: %v", err)
+		return
+	}
+	c.srv.hub.broadcast <- hubMessage{data: data, client: c}
+}
+
+// readPump reads commands from the connection and runs them on the client's session, or the
+// session named in the command. Every command is answered with a Reply.
+func (c *wsClient) readPump() {
+	defer func() {
+		c.srv.hub.unregister <- c
+		c.conn.Close()
+	}()
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		var cmd Command
+		err := c.conn.ReadJSON(&cmd)
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				c.srv.log.Errorf("Failed to read json: %v", err)
+			}
+			return
+		}
+		sess := c.session
+		if cmd.Session != "" {
+			sess = c.srv.sessions.Get(cmd.Session)
+		} else if sess == nil {
+			sess = c.srv.sessions.Default()
+		}
+		if sess == nil {
+			c.reply(newReply(cmd, nil, fmt.Errorf("session %q not found", cmd.Session)))
+			continue
+		}
+		if c.identity != nil {
+			if scope := commandScope(cmd.Cmd); !c.identity.HasScope(scope) {
+				c.reply(newReply(cmd, nil, fmt.Errorf("missing %s scope", scope)))
+				continue
+			}
+		}
+		cmd.UserID = boundUserID(c.identity, cmd.UserID)
+		result, err := sess.handleCmd(cmd)
+		if err != nil {
+			c.srv.log.Errorf("Command %s failed: %v", cmd.Cmd, err)
+		}
+		c.reply(newReply(cmd, result, err))
+	}
+}
+
+// writePump is the only goroutine that writes to the connection.
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				// The hub closed the channel.
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Handle incoming WebSocket connections, register them in the hub and pass their commands to handleCmd
+func (srv *Server) serveWs(w http.ResponseWriter, r *http.Request) {
+	conn, err := srv.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		srv.log.Errorf("Failed to upgrade connection: %v", err)
+		return
+	}
+	client := &wsClient{srv: srv, conn: conn, send: make(chan []byte, wsSendBuffer), identity: requestIdentity(r)}
+	if sess, ok := r.Context().Value(sessionContextKey{}).(*Session); ok {
+		client.session = sess
+	}
+	srv.hub.register <- client
+
+	go client.writePump()
+	go client.readPump()
+}
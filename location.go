@@ -0,0 +1,143 @@
+package whatsappws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Location is a place to send. Live locations are sent once and aren't updated afterwards.
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name"`
+	Address   string  `json:"address"`
+	Live      bool    `json:"live"`
+	// Caption and AccuracyInMeters are only used for live locations.
+	Caption          string `json:"caption"`
+	AccuracyInMeters uint32 `json:"accuracy_in_meters"`
+}
+
+func (loc *Location) validate() error {
+	if loc.Latitude < -90 || loc.Latitude > 90 {
+		return errors.New("latitude must be between -90 and 90")
+	} else if loc.Longitude < -180 || loc.Longitude > 180 {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+func (loc *Location) message() *waProto.Message {
+	if loc.Live {
+		return &waProto.Message{
+			LiveLocationMessage: &waProto.LiveLocationMessage{
+				DegreesLatitude:  proto.Float64(loc.Latitude),
+				DegreesLongitude: proto.Float64(loc.Longitude),
+				Caption:          proto.String(loc.Caption),
+				AccuracyInMeters: proto.Uint32(loc.AccuracyInMeters),
+				SequenceNumber:   proto.Int64(0),
+			},
+		}
+	}
+	return &waProto.Message{
+		LocationMessage: &waProto.LocationMessage{
+			DegreesLatitude:  proto.Float64(loc.Latitude),
+			DegreesLongitude: proto.Float64(loc.Longitude),
+			Name:             proto.String(loc.Name),
+			Address:          proto.String(loc.Address),
+		},
+	}
+}
+
+// locationContent is the chat log content of a location: its name, address or caption, and a geo
+// URI with the coordinates.
+func locationContent(latitude, longitude float64, texts ...string) string {
+	var parts []string
+	for _, text := range texts {
+		if text != "" {
+			parts = append(parts, text)
+		}
+	}
+	parts = append(parts, fmt.Sprintf("geo:%s,%s",
+		strconv.FormatFloat(latitude, 'f', -1, 64), strconv.FormatFloat(longitude, 'f', -1, 64)))
+	return strings.Join(parts, "\n")
+}
+
+// sendLocation sends a location or live location.
+func (s *Session) sendLocation(recipient string, loc *Location, userID int) (*sendResult, error) {
+	jid, err := parseJID(recipient)
+	if err != nil {
+		return nil, err
+	}
+	if err = loc.validate(); err != nil {
+		return nil, err
+	}
+	if loc.Live {
+		return s.sendAndStore(jid, loc.message(), "live_location", locationContent(loc.Latitude, loc.Longitude, loc.Caption), userID)
+	}
+	return s.sendAndStore(jid, loc.message(), "location", locationContent(loc.Latitude, loc.Longitude, loc.Name, loc.Address), userID)
+}
+
+func (s *Session) handleSendLocation(args []string, userID int) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errors.New("usage: location <jid> <latitude> <longitude> [name] [address]")
+	}
+	var loc Location
+	var err error
+	if loc.Latitude, err = strconv.ParseFloat(args[1], 64); err != nil {
+		return nil, fmt.Errorf("invalid latitude: %w", err)
+	}
+	if loc.Longitude, err = strconv.ParseFloat(args[2], 64); err != nil {
+		return nil, fmt.Errorf("invalid longitude: %w", err)
+	}
+	if len(args) > 3 {
+		loc.Name = args[3]
+	}
+	if len(args) > 4 {
+		loc.Address = strings.Join(args[4:], " ")
+	}
+	return s.sendLocation(args[0], &loc, userID)
+}
+
+// serveSendLocation sends a location: POST /send-location
+func (srv *Server) serveSendLocation(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "POST")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	sess := srv.requestSession(r)
+	if sess == nil || !sess.cli.IsLoggedIn() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var body struct {
+		Recipient string `json:"recipient" validate:"required"`
+		Location
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+	if _, err := parseJID(body.Recipient); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err = body.Location.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := sess.sendLocation(body.Recipient, &body.Location, boundUserID(requestIdentity(r), -1))
+	if err != nil {
+		srv.handleError(w, http.StatusInternalServerError, "Failed to send location", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
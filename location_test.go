@@ -0,0 +1,49 @@
+package whatsappws
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSendLocation(t *testing.T) {
+	chatLog := NewMemoryChatLog()
+	srv, fake := newTestServer(t, chatLog)
+
+	w := serveJSON(t, srv, http.MethodPost, "/send-location", map[string]interface{}{
+		"recipient": "905550000000", "latitude": 41.0082, "longitude": 28.9784, "name": "Hagia Sophia", "address": "Sultanahmet, Istanbul",
+	})
+	decodeResponse(t, w, http.StatusOK, nil)
+	w = serveJSON(t, srv, http.MethodPost, "/send-location", map[string]interface{}{
+		"recipient": "905550000000", "latitude": -33.8568, "longitude": 151.2153, "live": true, "caption": "On my way", "accuracy_in_meters": 15,
+	})
+	decodeResponse(t, w, http.StatusOK, nil)
+
+	sent := fake.SentMessages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(sent))
+	}
+	if loc := sent[0].Message.GetLocationMessage(); sent[0].To.String() != testChat.String() || loc.GetDegreesLatitude() != 41.0082 ||
+		loc.GetDegreesLongitude() != 28.9784 || loc.GetName() != "Hagia Sophia" || loc.GetAddress() != "Sultanahmet, Istanbul" {
+		t.Errorf("sent location is %v", sent[0].Message)
+	}
+	if live := sent[1].Message.GetLiveLocationMessage(); live.GetDegreesLatitude() != -33.8568 || live.GetDegreesLongitude() != 151.2153 ||
+		live.GetCaption() != "On my way" || live.GetAccuracyInMeters() != 15 {
+		t.Errorf("sent live location is %v", sent[1].Message)
+	}
+
+	messages := chatLog.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("stored %d messages, want 2", len(messages))
+	}
+	if msg := messages[0]; msg.Type != "location" || msg.Content != "Hagia Sophia\nSultanahmet, Istanbul\ngeo:41.0082,28.9784" || !msg.Sent {
+		t.Errorf("stored location is %+v", msg)
+	}
+	if msg := messages[1]; msg.Type != "live_location" || msg.Content != "On my way\ngeo:-33.8568,151.2153" {
+		t.Errorf("stored live location is %+v", msg)
+	}
+
+	w = serveJSON(t, srv, http.MethodPost, "/send-location", map[string]interface{}{"recipient": "905550000000", "latitude": 91, "longitude": 0})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status of an invalid latitude is %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,318 @@
+package whatsappws
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	videoMP4  = "video/mp4"
+	video3GPP = "video/3gpp"
+	audioOpus = "audio/ogg; codecs=opus"
+	audioOGG  = "audio/ogg"
+	audioMPEG = "audio/mpeg"
+	audioMP4  = "audio/mp4"
+	audioAAC  = "audio/aac"
+	audioAMR  = "audio/amr"
+)
+
+var errPTTNotOpus = errors.New("voice notes must be OGG/Opus audio")
+
+// detectMimeType sniffs the mimetype of an uploaded file. Sniffing can't tell Opus from other OGG
+// audio or M4A from MP4 video, so those are told apart by their headers, and the file name is
+// used for formats that can't be sniffed at all.
+func detectMimeType(data []byte, fileName string) string {
+	mimeType := http.DetectContentType(data)
+	switch {
+	case mimeType == "application/ogg":
+		if len(data) >= 36 && string(data[28:36]) == "OpusHead" {
+			return audioOpus
+		}
+		return audioOGG
+	case mimeType == videoMP4 && len(data) >= 12 && string(data[8:12]) == "M4A ":
+		return audioMP4
+	case mimeType == "application/octet-stream":
+		if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(fileName))); byExt != "" {
+			return byExt
+		}
+	}
+	return mimeType
+}
+
+func isVideo(mimeType string) bool {
+	return stringContains([]string{videoMP4, video3GPP}, mimeType)
+}
+
+func isAudio(mimeType string) bool {
+	return stringContains([]string{audioOpus, audioOGG, audioMPEG, audioMP4, audioAAC, audioAMR}, mimeType)
+}
+
+// mediaDuration returns the length in seconds of MP4 videos and audio and of OGG/Opus audio, or 0
+// for other formats.
+func mediaDuration(mimeType string, data []byte) uint32 {
+	switch mimeType {
+	case videoMP4, audioMP4:
+		return mp4Duration(data)
+	case audioOpus:
+		return opusDuration(data)
+	}
+	return 0
+}
+
+// mp4Duration reads the duration from the movie header (mvhd) box inside the movie (moov) box.
+func mp4Duration(data []byte) uint32 {
+	header := mp4Box(mp4Box(data, "moov"), "mvhd")
+	// Version 1 headers have 64-bit times and duration, version 0 headers 32-bit ones.
+	var timescale, duration uint64
+	if len(header) >= 32 && header[0] == 1 {
+		timescale = uint64(binary.BigEndian.Uint32(header[20:24]))
+		duration = binary.BigEndian.Uint64(header[24:32])
+	} else if len(header) >= 20 && header[0] == 0 {
+		timescale = uint64(binary.BigEndian.Uint32(header[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(header[16:20]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return uint32(duration / timescale)
+}
+
+// mp4Box walks the boxes in data by their size and type headers and returns the contents of
+// the first one of the given type, or nil. A box cut off by the end of data is returned as far
+// as it goes.
+func mp4Box(data []byte, boxType string) []byte {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			// The box extends to the end of the data.
+			size = uint64(len(data))
+		case 1:
+			// The size follows the type as a 64-bit number.
+			if len(data) < 16 {
+				return nil
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+		if size < headerSize {
+			return nil
+		}
+		end := size
+		if end > uint64(len(data)) {
+			end = uint64(len(data))
+		}
+		if string(data[4:8]) == boxType {
+			return data[headerSize:end]
+		}
+		data = data[end:]
+	}
+	return nil
+}
+
+// opusDuration reads the granule position of the last OGG page, which counts 48 kHz samples
+// including the pre-skip of the Opus header.
+func opusDuration(data []byte) uint32 {
+	last := bytes.LastIndex(data, []byte("OggS"))
+	if last < 0 || len(data) < last+14 || len(data) < 40 {
+		return 0
+	}
+	granule := binary.LittleEndian.Uint64(data[last+6 : last+14])
+	preSkip := uint64(binary.LittleEndian.Uint16(data[38:40]))
+	if granule <= preSkip {
+		return 0
+	}
+	return uint32((granule - preSkip) / 48000)
+}
+
+// videoThumbnail scales an uploaded image down to a JPEG thumbnail for a video message.
+func videoThumbnail(data []byte) ([]byte, error) {
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding thumbnail: %w", err)
+	}
+	var buf bytes.Buffer
+	err = imaging.Encode(&buf, imaging.Fit(img, 100, 100, imaging.Lanczos), imaging.JPEG, imaging.JPEGQuality(50))
+	if err != nil {
+		return nil, fmt.Errorf("error encoding thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// mediaUpload is a video or audio file to send. Thumbnail is only used for videos and PTT only for
+// audio.
+type mediaUpload struct {
+	Data      []byte
+	MimeType  string
+	Caption   string
+	Thumbnail []byte
+	PTT       bool
+}
+
+// isMediaUpload reports whether an uploaded file is sent as a video or audio message. Voice notes
+// are always sent as audio, so that non-Opus files are rejected instead of sent as documents.
+func isMediaUpload(r *http.Request, mimeType string) bool {
+	ptt, _ := strconv.ParseBool(r.FormValue("ptt"))
+	return ptt || isVideo(mimeType) || isAudio(mimeType)
+}
+
+// newMediaUpload reads the ptt and thumbnail fields of an upload form.
+func newMediaUpload(r *http.Request, data []byte, mimeType, caption string) (*mediaUpload, error) {
+	u := &mediaUpload{Data: data, MimeType: mimeType, Caption: caption}
+	if ptt := r.FormValue("ptt"); ptt != "" {
+		var err error
+		if u.PTT, err = strconv.ParseBool(ptt); err != nil {
+			return nil, fmt.Errorf("invalid ptt: %w", err)
+		}
+	}
+	file, _, err := r.FormFile("thumbnail")
+	if errors.Is(err, http.ErrMissingFile) {
+		return u, u.validate()
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	thumbnail, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	if u.Thumbnail, err = videoThumbnail(thumbnail); err != nil {
+		return nil, err
+	}
+	return u, u.validate()
+}
+
+func (u *mediaUpload) validate() error {
+	if u.PTT && u.MimeType != audioOpus {
+		return errPTTNotOpus
+	}
+	return nil
+}
+
+// kind returns "video" or "audio".
+func (u *mediaUpload) kind() string {
+	if isVideo(u.MimeType) {
+		return "video"
+	}
+	return "audio"
+}
+
+func (u *mediaUpload) mediaType() whatsmeow.MediaType {
+	if isVideo(u.MimeType) {
+		return whatsmeow.MediaVideo
+	}
+	return whatsmeow.MediaAudio
+}
+
+func (u *mediaUpload) message(uploaded whatsmeow.UploadResponse) *waProto.Message {
+	if isVideo(u.MimeType) {
+		return &waProto.Message{
+			VideoMessage: &waProto.VideoMessage{
+				Url:           proto.String(uploaded.URL),
+				DirectPath:    proto.String(uploaded.DirectPath),
+				MediaKey:      uploaded.MediaKey,
+				Mimetype:      proto.String(u.MimeType),
+				FileEncSha256: uploaded.FileEncSHA256,
+				FileSha256:    uploaded.FileSHA256,
+				FileLength:    proto.Uint64(uint64(len(u.Data))),
+				Seconds:       proto.Uint32(mediaDuration(u.MimeType, u.Data)),
+				Caption:       proto.String(u.Caption),
+				JpegThumbnail: u.Thumbnail,
+			},
+		}
+	}
+	return &waProto.Message{
+		AudioMessage: &waProto.AudioMessage{
+			Url:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(u.MimeType),
+			FileEncSha256: uploaded.FileEncSHA256,
+			FileSha256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uint64(len(u.Data))),
+			Seconds:       proto.Uint32(mediaDuration(u.MimeType, u.Data)),
+			Ptt:           proto.Bool(u.PTT),
+		},
+	}
+}
+
+// sendMedia uploads a video or audio file once and sends it to every recipient. Recipients that
+// fail are skipped, and the first error is returned if none succeeded.
+func (s *Session) sendMedia(JIDs []string, userID int, u *mediaUpload) ([]Message, error) {
+	uploaded, err := s.cli.Upload(context.Background(), u.Data, u.mediaType())
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %w", err)
+	}
+	msg := u.message(uploaded)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var sent []Message
+	var errs []error
+	for _, jid := range JIDs {
+		wg.Add(1)
+		go func(jid string) {
+			defer wg.Done()
+			m, err := s.sendMediaMessage(jid, userID, msg, u)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			sent = append(sent, m)
+		}(jid)
+	}
+	wg.Wait()
+
+	if len(sent) == 0 && len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return sent, nil
+}
+
+func (s *Session) sendMediaMessage(jid string, userID int, msg *waProto.Message, u *mediaUpload) (Message, error) {
+	recipient, err := parseJID(jid)
+	if err != nil {
+		return Message{}, err
+	}
+	resp, err := s.cli.SendMessage(context.Background(), recipient, msg)
+	if err != nil {
+		return Message{}, fmt.Errorf("error sending %s message: %w", u.kind(), err)
+	}
+	s.log.Infof("Sent %s message %s to %s (server timestamp: %s)", u.kind(), resp.ID, recipient, resp.Timestamp)
+
+	s.storeMessage(&ChatLogMessage{
+		MessageID:  resp.ID,
+		DeviceJID:  s.device.ID.String(),
+		RemoteJID:  recipient.String(),
+		Type:       "media",
+		Content:    u.Caption,
+		Timestamp:  resp.Timestamp,
+		Sent:       true,
+		UserID:     userID,
+		SenderJID:  s.device.ID.ToNonAD().String(),
+		RawMessage: rawMessage(msg),
+	})
+	s.saveFileToDisk(u.MimeType, u.Data, resp.ID)
+
+	m := Message{resp.ID, recipient.String(), "media", u.Caption, true, "", s.ID()}
+	s.publishMessage(m)
+	return m, nil
+}
@@ -0,0 +1,86 @@
+package whatsappws
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// mp4TestBox builds a box with a 32-bit size header.
+func mp4TestBox(boxType string, contents ...[]byte) []byte {
+	data := bytes.Join(contents, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(box, boxType...), data...)
+}
+
+// mvhdBox builds a movie header box of the given version, cut to length bytes after its type.
+func mvhdBox(version byte, timescale uint32, duration uint64, length int) []byte {
+	header := make([]byte, 32)
+	header[0] = version
+	if version == 1 {
+		binary.BigEndian.PutUint32(header[20:24], timescale)
+		binary.BigEndian.PutUint64(header[24:32], duration)
+	} else {
+		binary.BigEndian.PutUint32(header[12:16], timescale)
+		binary.BigEndian.PutUint32(header[16:20], uint32(duration))
+	}
+	return mp4TestBox("mvhd", header[:length])
+}
+
+func TestMP4Duration(t *testing.T) {
+	ftyp := mp4TestBox("ftyp", []byte("isom\x00\x00\x02\x00isomiso2"))
+	// Media data that happens to contain the bytes of a movie header.
+	mdat := mp4TestBox("mdat", mvhdBox(0, 1000, 99000, 20))
+	largeMdat := append(binary.BigEndian.AppendUint64([]byte("\x00\x00\x00\x01mdat"), uint64(16+len(mdat))), mdat...)
+	moov := mp4TestBox("moov", mp4TestBox("iods", make([]byte, 16)), mvhdBox(0, 1000, 42000, 20))
+	for _, test := range []struct {
+		name     string
+		data     []byte
+		duration uint32
+	}{
+		{"version 0", bytes.Join([][]byte{ftyp, mdat, moov}, nil), 42},
+		{"version 1", mp4TestBox("moov", mvhdBox(1, 600, 6000, 32)), 10},
+		{"64-bit size", bytes.Join([][]byte{ftyp, largeMdat, moov}, nil), 42},
+		{"moov to the end", append([]byte("\x00\x00\x00\x00moov"), mvhdBox(0, 1000, 42000, 20)...), 42},
+		{"truncated version 0", mp4TestBox("moov", mvhdBox(0, 1000, 42000, 19)), 0},
+		{"truncated version 1", mp4TestBox("moov", mvhdBox(1, 600, 6000, 31)), 0},
+		{"truncated moov", moov[:len(moov)-1], 0},
+		{"zero timescale", mp4TestBox("moov", mvhdBox(0, 0, 42000, 20)), 0},
+		{"mvhd outside moov", mvhdBox(0, 1000, 42000, 20), 0},
+		{"mvhd only in mdat", bytes.Join([][]byte{ftyp, mdat}, nil), 0},
+		{"invalid size", []byte("\x00\x00\x00\x04moov"), 0},
+		{"no moov", ftyp, 0},
+	} {
+		if duration := mp4Duration(test.data); duration != test.duration {
+			t.Errorf("%s: duration is %d, want %d", test.name, duration, test.duration)
+		}
+	}
+}
+
+func TestUploadNewSavesVideoInDataDir(t *testing.T) {
+	srv, fake := newTestServer(t, nil)
+	video := append([]byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"), mp4TestBox("moov", mvhdBox(0, 1000, 5000, 20))...)
+	contentType, body := multipartBody(t, map[string]string{"jid": "905550000000"}, map[string][]byte{"clip.mp4": video})
+
+	var messages []Message
+	decodeResponse(t, serve(srv, http.MethodPost, "/upload-new", contentType, body), http.StatusOK, &messages)
+	if len(messages) != 1 {
+		t.Fatalf("response has %d messages, want 1", len(messages))
+	}
+	if sent := fake.SentMessages(); len(sent) != 1 || sent[0].Message.GetVideoMessage().GetSeconds() != 5 {
+		t.Errorf("sent messages are %+v", sent)
+	}
+	if exts, _ := mime.ExtensionsByType(videoMP4); len(exts) == 0 {
+		t.Skip("the system has no file extension for video/mp4, so the video isn't saved")
+	}
+	id := messages[0].MessageID
+	if saved, _ := filepath.Glob(filepath.Join(srv.dataDir, "media", testPhone, id+"*")); len(saved) != 1 {
+		t.Errorf("saved %v in the data directory, want the video", saved)
+	}
+	if saved, _ := filepath.Glob(id + "*"); len(saved) != 0 {
+		t.Errorf("saved %v in the working directory", saved)
+	}
+}
@@ -0,0 +1,353 @@
+package whatsappws
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// mediaRetryInterval is how long to wait for the sender to answer a media retry before requesting
+// another one.
+const mediaRetryInterval = time.Minute
+
+var (
+	errMediaRetryRequested = errors.New("media expired, a media retry was requested from the sender")
+	errNoDirectPath        = errors.New("media has no direct path")
+)
+
+// mediaKinds maps the media types stored in the chat log to the whatsmeow media type and the MMS
+// type used to download them.
+var mediaKinds = map[string]struct {
+	mediaType whatsmeow.MediaType
+	mmsType   string
+}{
+	"image":    {whatsmeow.MediaImage, "image"},
+	"sticker":  {whatsmeow.MediaImage, "image"},
+	"video":    {whatsmeow.MediaVideo, "video"},
+	"audio":    {whatsmeow.MediaAudio, "audio"},
+	"document": {whatsmeow.MediaDocument, "document"},
+}
+
+// mediaDownload is a download in progress, which other requests for the same media wait for.
+type mediaDownload struct {
+	done chan struct{}
+	err  error
+}
+
+// newChatLogMedia returns the media of a message to store, or nil if the message has no media.
+func newChatLogMedia(info *types.MessageInfo, deviceJID string, msg *waProto.Message) *ChatLogMedia {
+	media := &ChatLogMedia{
+		MessageID: info.ID,
+		DeviceJID: deviceJID,
+		RemoteJID: info.Chat.String(),
+		SenderJID: info.Sender.ToNonAD().String(),
+		FromMe:    info.IsFromMe,
+		Status:    mediaPending,
+		CreatedAt: info.Timestamp,
+		UpdatedAt: time.Now(),
+	}
+	var downloadable whatsmeow.DownloadableMessage
+	var fileLength uint64
+	switch {
+	case msg.GetImageMessage() != nil:
+		img := msg.GetImageMessage()
+		downloadable, fileLength, media.MediaType, media.MimeType, media.Thumbnail = img, img.GetFileLength(), "image", img.GetMimetype(), img.GetJpegThumbnail()
+	case msg.GetStickerMessage() != nil:
+		sticker := msg.GetStickerMessage()
+		downloadable, fileLength, media.MediaType, media.MimeType = sticker, sticker.GetFileLength(), "sticker", sticker.GetMimetype()
+	case msg.GetVideoMessage() != nil:
+		video := msg.GetVideoMessage()
+		downloadable, fileLength, media.MediaType, media.MimeType, media.Thumbnail = video, video.GetFileLength(), "video", video.GetMimetype(), video.GetJpegThumbnail()
+	case msg.GetAudioMessage() != nil:
+		audio := msg.GetAudioMessage()
+		downloadable, fileLength, media.MediaType, media.MimeType = audio, audio.GetFileLength(), "audio", audio.GetMimetype()
+	case msg.GetDocumentMessage() != nil:
+		doc := msg.GetDocumentMessage()
+		downloadable, fileLength, media.MediaType, media.MimeType, media.Thumbnail = doc, doc.GetFileLength(), "document", doc.GetMimetype(), doc.GetJpegThumbnail()
+		media.FileName = doc.GetFileName()
+	default:
+		return nil
+	}
+	media.DirectPath = downloadable.GetDirectPath()
+	media.MediaKey = downloadable.GetMediaKey()
+	media.FileEncSHA256 = downloadable.GetFileEncSha256()
+	media.FileSHA256 = downloadable.GetFileSha256()
+	media.FileLength = int64(fileLength)
+	return media
+}
+
+// storeMedia stores the media of a message, if it has any, so it can be downloaded later.
+func (s *Session) storeMedia(info *types.MessageInfo, msg *waProto.Message) *ChatLogMedia {
+	media := newChatLogMedia(info, s.device.ID.String(), msg)
+	if media == nil {
+		return nil
+	}
+	if err := s.srv.chatLog.InsertMedia(media); err != nil {
+		s.log.Errorf("Error inserting into media: %v", err)
+		return nil
+	}
+	return media
+}
+
+// downloadMediaInBackground downloads received media into the data directory without holding up
+// the event handler. Failed downloads are retried by GET /media/{message_id}.
+func (s *Session) downloadMediaInBackground(media *ChatLogMedia) {
+	go func() {
+		if err := s.fetchMedia(media); errors.Is(err, errMediaRetryRequested) {
+			s.log.Infof("Media of %s expired, requested a media retry", media.MessageID)
+		} else if err != nil {
+			s.log.Errorf("Failed to download media of %s: %v", media.MessageID, err)
+		}
+	}()
+}
+
+// fetchMedia downloads media, or waits for a download of the same media that is in progress.
+func (s *Session) fetchMedia(media *ChatLogMedia) error {
+	key := chatKey(media.RemoteJID, media.MessageID)
+	s.mediaLock.Lock()
+	if s.mediaDownloads == nil {
+		s.mediaDownloads = make(map[string]*mediaDownload)
+	}
+	if download, ok := s.mediaDownloads[key]; ok {
+		s.mediaLock.Unlock()
+		<-download.done
+		return download.err
+	}
+	download := &mediaDownload{done: make(chan struct{})}
+	s.mediaDownloads[key] = download
+	s.mediaLock.Unlock()
+
+	download.err = s.downloadMedia(media)
+
+	s.mediaLock.Lock()
+	delete(s.mediaDownloads, key)
+	s.mediaLock.Unlock()
+	close(download.done)
+	return download.err
+}
+
+// downloadMedia downloads media into the data directory. If the media expired on the WhatsApp
+// servers, a media retry is requested from the sender and errMediaRetryRequested is returned; the
+// download is started again when the sender answers.
+func (s *Session) downloadMedia(media *ChatLogMedia) error {
+	if media.Status == mediaRetry && time.Since(media.UpdatedAt) < mediaRetryInterval {
+		return errMediaRetryRequested
+	}
+	if media.DirectPath == "" {
+		s.setMediaStatus(media, mediaFailed, errNoDirectPath)
+		return errNoDirectPath
+	}
+	kind := mediaKinds[media.MediaType]
+	data, err := s.cli.DownloadMediaWithPath(media.DirectPath, media.FileEncSHA256, media.FileSHA256, media.MediaKey, int(media.FileLength), kind.mediaType, kind.mmsType)
+	if errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) || errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410) {
+		return s.requestMediaRetry(media)
+	} else if err != nil {
+		s.setMediaStatus(media, mediaFailed, err)
+		return fmt.Errorf("failed to download %s: %w", media.MediaType, err)
+	}
+
+	relPath := filepath.Join("media", safeFileName(s.device.ID.User), safeFileName(media.MessageID)+mediaExtension(media.MimeType, media.FileName))
+	fullPath := filepath.Join(s.srv.dataDir, relPath)
+	if err = os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("failed to create media directory: %w", err)
+	}
+	if err = os.WriteFile(fullPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to save %s: %w", media.MediaType, err)
+	}
+	now := time.Now()
+	media.Path = relPath
+	media.DownloadedAt = &now
+	s.setMediaStatus(media, mediaDownloaded, nil)
+	s.log.Infof("Saved %s message %s to %s", media.MediaType, media.MessageID, fullPath)
+	return nil
+}
+
+// requestMediaRetry asks the sender to upload expired media again.
+func (s *Session) requestMediaRetry(media *ChatLogMedia) error {
+	chat, err := types.ParseJID(media.RemoteJID)
+	if err != nil {
+		return err
+	}
+	sender, err := types.ParseJID(media.SenderJID)
+	if err != nil {
+		return err
+	}
+	info := &types.MessageInfo{
+		ID: media.MessageID,
+		MessageSource: types.MessageSource{
+			Chat:     chat,
+			Sender:   sender,
+			IsFromMe: media.FromMe,
+			IsGroup:  chat.Server == types.GroupServer,
+		},
+	}
+	if err = s.cli.SendMediaRetryReceipt(info, media.MediaKey); err != nil {
+		s.setMediaStatus(media, mediaFailed, err)
+		return fmt.Errorf("failed to request media retry: %w", err)
+	}
+	s.setMediaStatus(media, mediaRetry, nil)
+	return errMediaRetryRequested
+}
+
+// handleMediaRetry downloads media again with the new direct path the sender uploaded it to.
+func (s *Session) handleMediaRetry(evt *events.MediaRetry) {
+	media, err := s.srv.chatLog.GetMedia(s.device.ID.String(), evt.ChatID.String(), evt.MessageID)
+	if err != nil {
+		s.log.Warnf("Received media retry for unknown media %s: %v", evt.MessageID, err)
+		return
+	}
+	notif, err := whatsmeow.DecryptMediaRetryNotification(evt, media.MediaKey)
+	if err != nil {
+		s.setMediaStatus(media, mediaFailed, err)
+		s.log.Errorf("Failed to decrypt media retry of %s: %v", evt.MessageID, err)
+		return
+	}
+	if notif.GetResult() != waProto.MediaRetryNotification_SUCCESS {
+		s.setMediaStatus(media, mediaFailed, fmt.Errorf("media retry failed: %s", notif.GetResult()))
+		s.log.Warnf("Media retry of %s failed: %s", evt.MessageID, notif.GetResult())
+		return
+	}
+	s.log.Infof("Media of %s was uploaded again", evt.MessageID)
+	media.DirectPath = notif.GetDirectPath()
+	s.setMediaStatus(media, mediaPending, nil)
+	s.downloadMediaInBackground(media)
+}
+
+func (s *Session) setMediaStatus(media *ChatLogMedia, status string, err error) {
+	media.Status = status
+	media.Error = ""
+	if err != nil {
+		media.Error = err.Error()
+	}
+	media.UpdatedAt = time.Now()
+	if err := s.srv.chatLog.UpdateMedia(media); err != nil {
+		s.log.Errorf("Error updating media: %v", err)
+	}
+}
+
+// mediaExtension returns the extension of the file name, or else of the mimetype.
+func mediaExtension(mimeType, fileName string) string {
+	if ext := filepath.Ext(fileName); ext != "" {
+		return safeFileName(ext)
+	}
+	if strings.HasPrefix(mimeType, "image/jpeg") {
+		return ".jpg"
+	}
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// safeFileName drops the characters of a JID or message ID that aren't safe in file names.
+func safeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+// serveMedia serves GET /media/{message_id} and GET /media/{message_id}/thumbnail. The file is
+// downloaded first if it's missing; if it expired, a media retry is requested and the endpoint
+// responds with 202 Accepted until the sender uploaded it again.
+func (srv *Server) serveMedia(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "GET")
+	switch r.Method {
+	case "OPTIONS":
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodGet:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	sess := srv.requestSession(r)
+	if sess == nil || sess.device.ID == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	messageID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/media/"), "/")
+	if messageID == "" || action != "" && action != "thumbnail" {
+		http.NotFound(w, r)
+		return
+	}
+	var chat string
+	if c := r.URL.Query().Get("chat"); c != "" {
+		jid, err := parseJID(c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		chat = jid.String()
+	}
+	media, err := srv.chatLog.GetMedia(sess.device.ID.String(), chat, messageID)
+	if errors.Is(err, errMediaNotFound) {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		srv.handleError(w, http.StatusInternalServerError, "Failed to read media", err)
+		return
+	}
+
+	if action == "thumbnail" {
+		if len(media.Thumbnail) == 0 {
+			http.Error(w, "Media has no thumbnail", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(media.Thumbnail)
+		return
+	}
+
+	// Media that wasn't downloaded yet, or whose file was removed, is downloaded again.
+	var file *os.File
+	if media.Path != "" {
+		file, _ = os.Open(filepath.Join(srv.dataDir, media.Path))
+	}
+	if file == nil {
+		err = sess.fetchMedia(media)
+		if errors.Is(err, errMediaRetryRequested) {
+			w.Header().Set("Retry-After", "15")
+			http.Error(w, "Media expired, it was requested again from the sender", http.StatusAccepted)
+			return
+		} else if err != nil {
+			srv.handleError(w, http.StatusBadGateway, "Failed to download media", err)
+			return
+		}
+		if media, err = srv.chatLog.GetMedia(sess.device.ID.String(), media.RemoteJID, messageID); err != nil {
+			srv.handleError(w, http.StatusInternalServerError, "Failed to read media", err)
+			return
+		}
+		if file, err = os.Open(filepath.Join(srv.dataDir, media.Path)); err != nil {
+			srv.handleError(w, http.StatusInternalServerError, "Failed to open media", err)
+			return
+		}
+	}
+	defer file.Close()
+
+	if media.MimeType != "" {
+		w.Header().Set("Content-Type", media.MimeType)
+	}
+	if media.FileName != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": path.Base(strings.ReplaceAll(media.FileName, `\`, "/"))}))
+	}
+	var modTime time.Time
+	if media.DownloadedAt != nil {
+		modTime = *media.DownloadedAt
+	}
+	http.ServeContent(w, r, filepath.Base(media.Path), modTime, file)
+}
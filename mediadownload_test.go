@@ -0,0 +1,90 @@
+package whatsappws
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"testing"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestServeMedia(t *testing.T) {
+	srv, fake := newTestServer(t, nil)
+	chat := testChat.String()
+	image := testPNG(t)
+	var lock sync.Mutex
+	downloads := make(map[string]int)
+	fake.DownloadFunc = func(directPath string, mediaType whatsmeow.MediaType) ([]byte, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		downloads[directPath]++
+		if directPath == "/v/expired" {
+			return nil, whatsmeow.ErrMediaDownloadFailedWith410
+		}
+		return image, nil
+	}
+	// History messages aren't downloaded until they're requested.
+	imageMessage := func(directPath string) *waProto.Message {
+		return &waProto.Message{ImageMessage: &waProto.ImageMessage{
+			Mimetype:      proto.String("image/png"),
+			DirectPath:    proto.String(directPath),
+			MediaKey:      []byte("key"),
+			JpegThumbnail: []byte("thumbnail"),
+		}}
+	}
+	fake.Emit(&events.HistorySync{Data: &waProto.HistorySync{
+		SyncType: waProto.HistorySync_RECENT.Enum(),
+		Conversations: []*waProto.Conversation{{
+			Id: proto.String(chat),
+			Messages: []*waProto.HistorySyncMsg{
+				historyMessage("IMAGE", false, 1700000100, imageMessage("/v/image")),
+				historyMessage("EXPIRED", false, 1700000200, imageMessage("/v/expired")),
+			},
+		}},
+	}})
+
+	// Media that wasn't downloaded yet is downloaded, saved and served, and then served from disk.
+	for i := 0; i < 2; i++ {
+		w := serve(srv, http.MethodGet, "/media/IMAGE", "", nil)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || !bytes.Equal(w.Body.Bytes(), image) {
+			t.Fatalf("request %d: status is %d with type %q and %d bytes", i+1, w.Code, w.Header().Get("Content-Type"), w.Body.Len())
+		}
+	}
+	if downloads["/v/image"] != 1 {
+		t.Errorf("downloaded the image %d times, want once", downloads["/v/image"])
+	}
+	media, err := srv.chatLog.GetMedia(testPhone+"@s.whatsapp.net", chat, "IMAGE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.Status != mediaDownloaded || media.Path == "" || media.DownloadedAt == nil {
+		t.Errorf("downloaded media is %+v", media)
+	}
+	if w := serve(srv, http.MethodGet, "/media/IMAGE/thumbnail", "", nil); w.Code != http.StatusOK || w.Body.String() != "thumbnail" {
+		t.Errorf("thumbnail status is %d: %q", w.Code, w.Body.String())
+	}
+
+	// Expired media is requested again from the sender, and the request is accepted until it arrives.
+	for i := 0; i < 2; i++ {
+		w := serve(srv, http.MethodGet, "/media/EXPIRED", "", nil)
+		if w.Code != http.StatusAccepted || w.Header().Get("Retry-After") == "" {
+			t.Errorf("request %d for expired media: status is %d, Retry-After %q", i+1, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+	if retries := fake.MediaRetryIDs(); len(retries) != 1 || retries[0] != "EXPIRED" {
+		t.Errorf("media retries are %v, want one for EXPIRED", retries)
+	}
+	if media, err = srv.chatLog.GetMedia(testPhone+"@s.whatsapp.net", chat, "EXPIRED"); err != nil {
+		t.Fatal(err)
+	} else if media.Status != mediaRetry {
+		t.Errorf("expired media is %+v", media)
+	}
+
+	if w := serve(srv, http.MethodGet, "/media/UNKNOWN", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("status of unknown media is %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,524 @@
+package whatsappws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryChatLog is a ChatLogStore that keeps everything in memory. It's meant for tests, which can
+// inspect what was persisted with Messages, LastMessage, Receipts, Edits, Reactions, Group,
+// GroupParticipants and Media.
+type MemoryChatLog struct {
+	lock         sync.Mutex
+	messages     []ChatLogMessage
+	lastMessages map[string]ChatLogMessage
+	receipts     []ChatLogReceipt
+	edits        []ChatLogEdit
+	reactions    []ChatLogReaction
+	polls        []ChatLogPoll
+	pollVotes    []ChatLogPollVote
+	groups       map[string]ChatLogGroup
+	inviteLinks  map[string]string
+	participants map[string][]ChatLogGroupParticipant
+	media        []ChatLogMedia
+}
+
+func NewMemoryChatLog() *MemoryChatLog {
+	return &MemoryChatLog{
+		lastMessages: make(map[string]ChatLogMessage),
+		groups:       make(map[string]ChatLogGroup),
+		inviteLinks:  make(map[string]string),
+		participants: make(map[string][]ChatLogGroupParticipant),
+	}
+}
+
+func chatKey(deviceJID, remoteJID string) string {
+	return deviceJID + "|" + remoteJID
+}
+
+// Messages returns a copy of the stored messages in insertion order.
+func (m *MemoryChatLog) Messages() []ChatLogMessage {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]ChatLogMessage(nil), m.messages...)
+}
+
+// LastMessage returns the last message of a chat.
+func (m *MemoryChatLog) LastMessage(deviceJID, remoteJID string) (ChatLogMessage, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	msg, ok := m.lastMessages[chatKey(deviceJID, remoteJID)]
+	return msg, ok
+}
+
+// Receipts returns a copy of the stored group participant receipts.
+func (m *MemoryChatLog) Receipts() []ChatLogReceipt {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]ChatLogReceipt(nil), m.receipts...)
+}
+
+// Edits returns a copy of the previous contents of edited messages.
+func (m *MemoryChatLog) Edits() []ChatLogEdit {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]ChatLogEdit(nil), m.edits...)
+}
+
+// Reactions returns a copy of the current reactions.
+func (m *MemoryChatLog) Reactions() []ChatLogReaction {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]ChatLogReaction(nil), m.reactions...)
+}
+
+// Group returns a stored group and its invite link.
+func (m *MemoryChatLog) Group(deviceJID, groupJID string) (ChatLogGroup, string, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	group, ok := m.groups[chatKey(deviceJID, groupJID)]
+	return group, m.inviteLinks[chatKey(deviceJID, groupJID)], ok
+}
+
+// GroupParticipants returns a copy of the participants of a group.
+func (m *MemoryChatLog) GroupParticipants(deviceJID, groupJID string) []ChatLogGroupParticipant {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]ChatLogGroupParticipant(nil), m.participants[chatKey(deviceJID, groupJID)]...)
+}
+
+// Media returns a copy of the stored media.
+func (m *MemoryChatLog) Media() []ChatLogMedia {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]ChatLogMedia(nil), m.media...)
+}
+
+func (m *MemoryChatLog) InsertMessage(msg *ChatLogMessage) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.messages = append(m.messages, *msg)
+	return nil
+}
+
+func (m *MemoryChatLog) SetLastMessage(msg *ChatLogMessage) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.lastMessages[chatKey(msg.DeviceJID, msg.RemoteJID)] = *msg
+	return nil
+}
+
+func (m *MemoryChatLog) SetLastMessageIfNewer(msg *ChatLogMessage) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := chatKey(msg.DeviceJID, msg.RemoteJID)
+	if stored, ok := m.lastMessages[key]; ok && stored.Timestamp.After(msg.Timestamp) {
+		return nil
+	}
+	m.lastMessages[key] = *msg
+	return nil
+}
+
+func (m *MemoryChatLog) MessageExists(messageID, deviceJID, remoteJID string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.find(messageID, deviceJID, remoteJID) != nil, nil
+}
+
+func (m *MemoryChatLog) GetMessage(messageID, deviceJID, remoteJID string) (*ChatLogMessage, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	msg := m.find(messageID, deviceJID, remoteJID)
+	if msg == nil {
+		return nil, errMessageNotFound
+	}
+	stored := *msg
+	return &stored, nil
+}
+
+// find returns the stored message. The lock must be held.
+func (m *MemoryChatLog) find(messageID, deviceJID, remoteJID string) *ChatLogMessage {
+	for i := range m.messages {
+		msg := &m.messages[i]
+		if msg.MessageID == messageID && msg.DeviceJID == deviceJID && msg.RemoteJID == remoteJID {
+			return msg
+		}
+	}
+	return nil
+}
+
+func (m *MemoryChatLog) EditMessage(messageID, deviceJID, remoteJID, content string, editedAt time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	msg := m.find(messageID, deviceJID, remoteJID)
+	if msg == nil {
+		return errMessageNotFound
+	}
+	m.edits = append(m.edits, ChatLogEdit{
+		MessageID: messageID,
+		DeviceJID: deviceJID,
+		RemoteJID: remoteJID,
+		Content:   msg.Content,
+		EditedAt:  editedAt,
+	})
+	msg.Content = content
+	msg.EditedAt = &editedAt
+	key := chatKey(deviceJID, remoteJID)
+	if last, ok := m.lastMessages[key]; ok && last.MessageID == messageID {
+		last.Content = content
+		m.lastMessages[key] = last
+	}
+	return nil
+}
+
+func (m *MemoryChatLog) RevokeMessage(messageID, deviceJID, remoteJID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	msg := m.find(messageID, deviceJID, remoteJID)
+	if msg == nil {
+		return errMessageNotFound
+	}
+	msg.Revoked = true
+	key := chatKey(deviceJID, remoteJID)
+	if last, ok := m.lastMessages[key]; ok && last.MessageID == messageID {
+		last.Revoked = true
+		m.lastMessages[key] = last
+	}
+	return nil
+}
+
+func (m *MemoryChatLog) MarkRead(messageID, deviceJID, remoteJID string, timestamp time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if msg := m.find(messageID, deviceJID, remoteJID); msg != nil {
+		msg.ReadAt = &timestamp
+	}
+	return nil
+}
+
+func (m *MemoryChatLog) MarkReceipt(messageID, deviceJID, remoteJID, receiptType string, timestamp time.Time) error {
+	if _, ok := receiptColumns[receiptType]; !ok {
+		return fmt.Errorf("unknown receipt type %q", receiptType)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	msg := m.find(messageID, deviceJID, remoteJID)
+	if msg == nil || !msg.Sent {
+		return nil
+	}
+	if msg.DeliveredAt == nil {
+		msg.DeliveredAt = &timestamp
+	}
+	if receiptType != "delivered" && msg.ReadAt == nil {
+		msg.ReadAt = &timestamp
+	}
+	if receiptType == "played" && msg.PlayedAt == nil {
+		msg.PlayedAt = &timestamp
+	}
+	return nil
+}
+
+func (m *MemoryChatLog) InsertReceipt(receipt *ChatLogReceipt) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, stored := range m.receipts {
+		if stored.DeviceJID == receipt.DeviceJID && stored.RemoteJID == receipt.RemoteJID && stored.MessageID == receipt.MessageID &&
+			stored.Participant == receipt.Participant && stored.Type == receipt.Type {
+			return nil
+		}
+	}
+	m.receipts = append(m.receipts, *receipt)
+	return nil
+}
+
+func (m *MemoryChatLog) SetReaction(reaction *ChatLogReaction) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i, stored := range m.reactions {
+		if stored.DeviceJID == reaction.DeviceJID && stored.RemoteJID == reaction.RemoteJID && stored.MessageID == reaction.MessageID &&
+			stored.ReactorJID == reaction.ReactorJID {
+			m.reactions = append(m.reactions[:i], m.reactions[i+1:]...)
+			break
+		}
+	}
+	if reaction.Reaction != "" {
+		m.reactions = append(m.reactions, *reaction)
+	}
+	return nil
+}
+
+func (m *MemoryChatLog) InsertPoll(poll *ChatLogPoll) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.findPoll(poll.MessageID, poll.DeviceJID, poll.RemoteJID) == nil {
+		m.polls = append(m.polls, *poll)
+	}
+	return nil
+}
+
+func (m *MemoryChatLog) GetPoll(messageID, deviceJID, remoteJID string) (*ChatLogPoll, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	poll := m.findPoll(messageID, deviceJID, remoteJID)
+	if poll == nil {
+		return nil, errPollNotFound
+	}
+	stored := *poll
+	return &stored, nil
+}
+
+// findPoll returns the stored poll. The lock must be held.
+func (m *MemoryChatLog) findPoll(messageID, deviceJID, remoteJID string) *ChatLogPoll {
+	for i := range m.polls {
+		poll := &m.polls[i]
+		if poll.MessageID == messageID && poll.DeviceJID == deviceJID && poll.RemoteJID == remoteJID {
+			return poll
+		}
+	}
+	return nil
+}
+
+func (m *MemoryChatLog) SetPollVote(vote *ChatLogPollVote) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i, stored := range m.pollVotes {
+		if stored.MessageID == vote.MessageID && stored.DeviceJID == vote.DeviceJID && stored.RemoteJID == vote.RemoteJID &&
+			stored.VoterJID == vote.VoterJID {
+			if !stored.Timestamp.After(vote.Timestamp) {
+				m.pollVotes[i] = *vote
+			}
+			return nil
+		}
+	}
+	m.pollVotes = append(m.pollVotes, *vote)
+	return nil
+}
+
+func (m *MemoryChatLog) PollVotes(messageID, deviceJID, remoteJID string) ([]ChatLogPollVote, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var votes []ChatLogPollVote
+	for _, vote := range m.pollVotes {
+		if vote.MessageID == messageID && vote.DeviceJID == deviceJID && vote.RemoteJID == remoteJID {
+			votes = append(votes, vote)
+		}
+	}
+	return votes, nil
+}
+
+func (m *MemoryChatLog) SetGroup(group *ChatLogGroup) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.groups[chatKey(group.DeviceJID, group.GroupJID)] = *group
+	return nil
+}
+
+func (m *MemoryChatLog) SetGroupInviteLink(deviceJID, groupJID, inviteLink string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.groups[chatKey(deviceJID, groupJID)]; ok {
+		m.inviteLinks[chatKey(deviceJID, groupJID)] = inviteLink
+	}
+	return nil
+}
+
+func (m *MemoryChatLog) UpdateGroup(deviceJID, groupJID string, change *ChatLogGroupChange) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	group, ok := m.groups[chatKey(deviceJID, groupJID)]
+	if !ok {
+		return nil
+	}
+	if change.Name != nil {
+		group.Name = *change.Name
+	}
+	if change.Topic != nil {
+		group.Topic = *change.Topic
+	}
+	if change.Announce != nil {
+		group.Announce = *change.Announce
+	}
+	if change.Locked != nil {
+		group.Locked = *change.Locked
+	}
+	group.UpdatedAt = time.Now()
+	m.groups[chatKey(deviceJID, groupJID)] = group
+	return nil
+}
+
+func (m *MemoryChatLog) SetGroupParticipants(deviceJID, groupJID string, participants []ChatLogGroupParticipant) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.participants[chatKey(deviceJID, groupJID)] = append([]ChatLogGroupParticipant(nil), participants...)
+	return nil
+}
+
+func (m *MemoryChatLog) ChangeGroupParticipants(deviceJID, groupJID, change string, participantJIDs []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := chatKey(deviceJID, groupJID)
+	for _, participantJID := range participantJIDs {
+		i := -1
+		for j, participant := range m.participants[key] {
+			if participant.ParticipantJID == participantJID {
+				i = j
+			}
+		}
+		switch {
+		case change == "join" && i < 0:
+			m.participants[key] = append(m.participants[key], ChatLogGroupParticipant{ParticipantJID: participantJID})
+		case change == "leave" && i >= 0:
+			m.participants[key] = append(m.participants[key][:i], m.participants[key][i+1:]...)
+		case change == "promote" && i >= 0:
+			m.participants[key][i].Admin = true
+		case change == "demote" && i >= 0:
+			m.participants[key][i].Admin, m.participants[key][i].SuperAdmin = false, false
+		case change != "join" && change != "leave" && change != "promote" && change != "demote":
+			return fmt.Errorf("unknown participant change %q", change)
+		}
+	}
+	if group, ok := m.groups[key]; ok {
+		group.ParticipantCount = len(m.participants[key])
+		group.UpdatedAt = time.Now()
+		m.groups[key] = group
+	}
+	return nil
+}
+
+func (m *MemoryChatLog) Chats(deviceJID string, before *ChatLogChatCursor, limit int) ([]ChatLogChat, *ChatLogChatCursor, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	chats := []ChatLogChat{}
+	for _, last := range m.lastMessages {
+		if last.DeviceJID != deviceJID || before != nil && !(last.Timestamp.Before(before.Timestamp) ||
+			last.Timestamp.Equal(before.Timestamp) && last.RemoteJID < before.RemoteJID) {
+			continue
+		}
+		chat := ChatLogChat{RemoteJID: last.RemoteJID, LastMessage: last}
+		chat.Name = m.groups[chatKey(deviceJID, last.RemoteJID)].Name
+		for _, msg := range m.messages {
+			if msg.DeviceJID == deviceJID && msg.RemoteJID == last.RemoteJID && !msg.Sent && msg.ReadAt == nil && msg.Type != "group_event" {
+				chat.UnreadCount++
+			}
+		}
+		chats = append(chats, chat)
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		a, b := chats[i].LastMessage, chats[j].LastMessage
+		return a.Timestamp.After(b.Timestamp) || a.Timestamp.Equal(b.Timestamp) && a.RemoteJID > b.RemoteJID
+	})
+	chats, next := chatsPage(chats, limit)
+	return chats, next, nil
+}
+
+// ChatMessages uses the position of a message in insertion order as its ID.
+func (m *MemoryChatLog) ChatMessages(query *ChatLogMessageQuery) ([]ChatLogMessage, *ChatLogCursor, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	matches, next := m.query(query, nil)
+	messages := make([]ChatLogMessage, len(matches))
+	for i, match := range matches {
+		messages[i] = m.messages[match]
+	}
+	return messages, next, nil
+}
+
+// query returns the indexes of the messages matching the query and the filter, newest first, and
+// the cursor of the next page. The lock must be held.
+func (m *MemoryChatLog) query(query *ChatLogMessageQuery, filter func(msg *ChatLogMessage) bool) ([]int, *ChatLogCursor) {
+	var matches []int
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg, id := &m.messages[i], int64(i+1)
+		if msg.DeviceJID != query.DeviceJID || query.RemoteJID != "" && msg.RemoteJID != query.RemoteJID ||
+			len(query.Types) > 0 && !stringContains(query.Types, msg.Type) ||
+			!query.Since.IsZero() && msg.Timestamp.Before(query.Since) ||
+			!query.Until.IsZero() && !msg.Timestamp.Before(query.Until) ||
+			query.Before != nil && !(msg.Timestamp.Before(query.Before.Timestamp) || msg.Timestamp.Equal(query.Before.Timestamp) && id < query.Before.ID) ||
+			filter != nil && !filter(msg) {
+			continue
+		}
+		matches = append(matches, i)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return m.messages[matches[i]].Timestamp.After(m.messages[matches[j]].Timestamp)
+	})
+	var next *ChatLogCursor
+	if len(matches) > query.Limit {
+		matches = matches[:query.Limit]
+		last := matches[len(matches)-1]
+		next = &ChatLogCursor{Timestamp: m.messages[last].Timestamp, ID: int64(last + 1)}
+	}
+	return matches, next
+}
+
+// SearchMessages matches messages whose content contains every word of the text, ignoring case.
+// The snippet is the whole content.
+func (m *MemoryChatLog) SearchMessages(query *ChatLogSearchQuery) ([]ChatLogSearchResult, *ChatLogCursor, error) {
+	words := strings.Fields(strings.ToLower(query.Text))
+	highlighter := searchHighlighter(query.Text)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	matches, next := m.query(&query.ChatLogMessageQuery, func(msg *ChatLogMessage) bool {
+		if query.Sent != nil && msg.Sent != *query.Sent {
+			return false
+		}
+		for _, word := range words {
+			if !strings.Contains(strings.ToLower(msg.Content), word) {
+				return false
+			}
+		}
+		return true
+	})
+	results := make([]ChatLogSearchResult, len(matches))
+	for i, match := range matches {
+		msg := m.messages[match]
+		results[i] = ChatLogSearchResult{ChatLogMessage: msg, Snippet: highlight(highlighter, msg.Content)}
+	}
+	return results, next, nil
+}
+
+func (m *MemoryChatLog) InsertMedia(media *ChatLogMedia) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.findMedia(media.DeviceJID, media.RemoteJID, media.MessageID) == nil {
+		m.media = append(m.media, *media)
+	}
+	return nil
+}
+
+func (m *MemoryChatLog) GetMedia(deviceJID, remoteJID, messageID string) (*ChatLogMedia, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	media := m.findMedia(deviceJID, remoteJID, messageID)
+	if media == nil {
+		return nil, errMediaNotFound
+	}
+	stored := *media
+	return &stored, nil
+}
+
+func (m *MemoryChatLog) UpdateMedia(media *ChatLogMedia) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if stored := m.findMedia(media.DeviceJID, media.RemoteJID, media.MessageID); stored != nil {
+		stored.DirectPath, stored.Path, stored.Status, stored.Error = media.DirectPath, media.Path, media.Status, media.Error
+		stored.UpdatedAt, stored.DownloadedAt = media.UpdatedAt, media.DownloadedAt
+	}
+	return nil
+}
+
+// findMedia returns the newest stored media with the message ID, in the chat if remoteJID is set.
+// The lock must be held.
+func (m *MemoryChatLog) findMedia(deviceJID, remoteJID, messageID string) *ChatLogMedia {
+	for i := len(m.media) - 1; i >= 0; i-- {
+		media := &m.media[i]
+		if media.DeviceJID == deviceJID && media.MessageID == messageID && (remoteJID == "" || media.RemoteJID == remoteJID) {
+			return media
+		}
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package whatsappws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	errMessageNotSent = errors.New("message was not sent by us")
+	errMessageRevoked = errors.New("message was revoked")
+	errNotEditable    = errors.New("only text messages can be edited")
+	errEditWindow     = errors.New("message is too old to edit")
+)
+
+// MessageEditEvent is pushed to WebSocket clients when a message is edited.
+type MessageEditEvent struct {
+	MessageID string    `json:"message_id"`
+	Chat      string    `json:"chat"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MessageRevokeEvent is pushed to WebSocket clients when a message is deleted for everyone.
+type MessageRevokeEvent struct {
+	MessageID string    `json:"message_id"`
+	Chat      string    `json:"chat"`
+	Sender    string    `json:"sender"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// sentMessage returns a message we sent to the chat, which can be edited or revoked.
+func (s *Session) sentMessage(chat types.JID, messageID string) (*ChatLogMessage, error) {
+	msg, err := s.srv.chatLog.GetMessage(messageID, s.device.ID.String(), chat.String())
+	if err != nil {
+		return nil, err
+	} else if !msg.Sent {
+		return nil, errMessageNotSent
+	} else if msg.Revoked {
+		return nil, errMessageRevoked
+	}
+	return msg, nil
+}
+
+// editMessage replaces the text of a message we sent.
+func (s *Session) editMessage(chat types.JID, messageID, text string) (*sendResult, error) {
+	orig, err := s.sentMessage(chat, messageID)
+	if err != nil {
+		return nil, err
+	} else if orig.Type != "text" {
+		return nil, errNotEditable
+	} else if time.Since(orig.Timestamp) > whatsmeow.EditWindow {
+		return nil, errEditWindow
+	}
+
+	s.log.Infof("Editing message %s in %s: %s", messageID, chat, text)
+	msg := s.cli.BuildEdit(chat, messageID, &waProto.Message{Conversation: proto.String(text)})
+	resp, err := s.cli.SendMessage(context.Background(), chat, msg)
+	if err != nil {
+		return nil, fmt.Errorf("error sending edit: %w", err)
+	}
+
+	if err = s.srv.chatLog.EditMessage(messageID, s.device.ID.String(), chat.String(), text, resp.Timestamp); err != nil {
+		s.log.Errorf("Error editing message in chat log: %v", err)
+	}
+	s.publish("message_edit", MessageEditEvent{
+		MessageID: messageID,
+		Chat:      chat.String(),
+		Sender:    s.device.ID.ToNonAD().String(),
+		Content:   text,
+		Timestamp: resp.Timestamp,
+	})
+	return &sendResult{MessageID: messageID, Recipient: chat.String(), Timestamp: resp.Timestamp}, nil
+}
+
+// revokeMessage deletes a message we sent for everyone.
+func (s *Session) revokeMessage(chat types.JID, messageID string) (*sendResult, error) {
+	if _, err := s.sentMessage(chat, messageID); err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Revoking message %s in %s", messageID, chat)
+	resp, err := s.cli.SendMessage(context.Background(), chat, s.cli.BuildRevoke(chat, types.EmptyJID, messageID))
+	if err != nil {
+		return nil, fmt.Errorf("error sending revoke: %w", err)
+	}
+
+	if err = s.srv.chatLog.RevokeMessage(messageID, s.device.ID.String(), chat.String()); err != nil {
+		s.log.Errorf("Error revoking message in chat log: %v", err)
+	}
+	s.publish("message_revoke", MessageRevokeEvent{
+		MessageID: messageID,
+		Chat:      chat.String(),
+		Sender:    s.device.ID.ToNonAD().String(),
+		Timestamp: resp.Timestamp,
+	})
+	return &sendResult{MessageID: messageID, Recipient: chat.String(), Timestamp: resp.Timestamp}, nil
+}
+
+// handleProtocolMessage applies edits and revokes of other devices and contacts to the chat log
+// and publishes them. Other protocol messages are ignored, and so are edits and revokes of
+// messages that aren't stored or that the sender may not change.
+func (s *Session) handleProtocolMessage(evt *events.Message) {
+	protoMsg := evt.Message.GetProtocolMessage()
+	messageID := protoMsg.GetKey().GetId()
+	chat := evt.Info.Chat.String()
+	sender := evt.Info.Sender.ToNonAD().String()
+	deviceJID := s.device.ID.String()
+	if chat == "status@broadcast" {
+		return
+	}
+	msgType := protoMsg.GetType()
+	if msgType != waProto.ProtocolMessage_MESSAGE_EDIT && msgType != waProto.ProtocolMessage_REVOKE {
+		return
+	}
+
+	orig, err := s.srv.chatLog.GetMessage(messageID, deviceJID, chat)
+	if errors.Is(err, errMessageNotFound) {
+		s.log.Warnf("Received %s of unknown message %s in %s", msgType, messageID, chat)
+		return
+	} else if err != nil {
+		s.log.Errorf("Error reading message %s for %s: %v", messageID, msgType, err)
+		return
+	}
+	// Only the author can edit a message. Group admins can also delete the messages of others.
+	if !isAuthor(orig, &evt.Info) && (msgType != waProto.ProtocolMessage_REVOKE || !s.isGroupAdmin(evt.Info.Chat, evt.Info.Sender)) {
+		s.log.Warnf("Ignoring %s of message %s in %s by %s, who didn't send it", msgType, messageID, chat, sender)
+		return
+	}
+
+	switch msgType {
+	case waProto.ProtocolMessage_MESSAGE_EDIT:
+		content, _, _ := classifyMessage(protoMsg.GetEditedMessage())
+		if err = s.srv.chatLog.EditMessage(messageID, deviceJID, chat, content, evt.Info.Timestamp); err != nil {
+			s.log.Errorf("Error editing message in chat log: %v", err)
+		}
+		s.publish("message_edit", MessageEditEvent{
+			MessageID: messageID,
+			Chat:      chat,
+			Sender:    sender,
+			Content:   content,
+			Timestamp: evt.Info.Timestamp,
+		})
+	case waProto.ProtocolMessage_REVOKE:
+		if err = s.srv.chatLog.RevokeMessage(messageID, deviceJID, chat); err != nil {
+			s.log.Errorf("Error revoking message in chat log: %v", err)
+		}
+		s.publish("message_revoke", MessageRevokeEvent{
+			MessageID: messageID,
+			Chat:      chat,
+			Sender:    sender,
+			Timestamp: evt.Info.Timestamp,
+		})
+	}
+}
+
+// isAuthor reports whether the sender of a message wrote a stored message. Messages stored before
+// the sender was recorded are attributed to the chat outside of groups.
+func isAuthor(msg *ChatLogMessage, info *types.MessageInfo) bool {
+	if msg.Sent || info.IsFromMe {
+		return msg.Sent && info.IsFromMe
+	}
+	author := msg.SenderJID
+	if author == "" && !info.IsGroup {
+		author = msg.RemoteJID
+	}
+	return author != "" && author == info.Sender.ToNonAD().String()
+}
+
+// isGroupAdmin reports whether a participant is an admin of a group.
+func (s *Session) isGroupAdmin(group, participant types.JID) bool {
+	if group.Server != types.GroupServer || participant.IsEmpty() {
+		return false
+	}
+	info, err := s.cli.GetGroupInfo(group)
+	if err != nil {
+		s.log.Errorf("Error reading the admins of %s: %v", group, err)
+		return false
+	}
+	for _, p := range info.Participants {
+		if p.JID.ToNonAD() == participant.ToNonAD() {
+			return p.IsAdmin || p.IsSuperAdmin
+		}
+	}
+	return false
+}
+
+func (s *Session) handleEditMessage(args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errors.New("usage: edit <message_id> <jid> <text>")
+	}
+	chat, err := parseJID(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return s.editMessage(chat, args[0], strings.Join(args[2:], " "))
+}
+
+func (s *Session) handleRevokeMessage(args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, errors.New("usage: revoke <message_id> <jid>")
+	}
+	chat, err := parseJID(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return s.revokeMessage(chat, args[0])
+}
+
+// messageErrorStatus returns the HTTP status of an edit, revoke or reaction error.
+func messageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errMessageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errMessageNotSent):
+		return http.StatusForbidden
+	case errors.Is(err, errMessageRevoked), errors.Is(err, errNotEditable), errors.Is(err, errEditWindow):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// serveMessage edits or revokes a sent message, or reacts to a message:
+// POST /messages/{message_id}/edit, POST /messages/{message_id}/revoke and
+// POST /messages/{message_id}/react
+func (srv *Server) serveMessage(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "POST")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	} else if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	sess := srv.requestSession(r)
+	if sess == nil || !sess.cli.IsLoggedIn() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/messages/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	messageID, action := parts[0], parts[1]
+
+	var body struct {
+		Chat     string `json:"chat" validate:"required"`
+		Message  string `json:"message"`
+		Reaction string `json:"reaction"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+	chat, err := parseJID(body.Chat)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result *sendResult
+	switch action {
+	case "edit":
+		if body.Message == "" {
+			http.Error(w, "Message is required", http.StatusBadRequest)
+			return
+		}
+		result, err = sess.editMessage(chat, messageID, body.Message)
+	case "revoke":
+		result, err = sess.revokeMessage(chat, messageID)
+	case "react":
+		result, err = sess.reactToMessage(chat, messageID, body.Reaction)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		if status := messageErrorStatus(err); status != http.StatusInternalServerError {
+			http.Error(w, err.Error(), status)
+		} else {
+			srv.handleError(w, status, "Failed to "+action+" message", err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,191 @@
+package whatsappws
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migration is a versioned SQL file in migrations/<dialect>, named <version>_<name>.sql.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations(dialect string) ([]migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for chat log dialect %s", dialect)
+	}
+	var migrations []migration
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSuffix(entry.Name(), ".sql"), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		data, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: parts[1], sql: string(data)})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// chatLogSchemaVersion returns the version of the last applied migration, or 0.
+func chatLogSchemaVersion(db *sql.DB) (int, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+	var version int
+	if err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// MigrateChatLog applies the migrations that are newer than the schema version, each in its own
+// transaction. dialect is postgres or sqlite3.
+func MigrateChatLog(db *sql.DB, dialect string, log waLog.Logger) error {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return err
+	}
+	current, err := chatLogSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current == 0 && dialect == "sqlite3" {
+		if err = checkSQLiteMessagesID(db); err != nil {
+			return err
+		}
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		log.Infof("Migrating chat log database to version %d (%s)", m.version, m.name)
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(m.sql); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.name, err)
+		}
+		if _, err = tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES ($1, $2, $3)`, m.version, m.name, time.Now().UTC()); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", m.version, err)
+		}
+		current = m.version
+	}
+	log.Infof("Chat log database is at schema version %d", current)
+	return nil
+}
+
+// checkSQLiteMessagesID fails if a messages table created before the migrations has no id
+// column. SQLite can't add an INTEGER PRIMARY KEY to an existing table, so CREATE TABLE IF NOT
+// EXISTS would keep the table without it.
+func checkSQLiteMessagesID(db *sql.DB) error {
+	var columns, ids int
+	err := db.QueryRow(`SELECT COUNT(*), COUNT(CASE WHEN name = 'id' THEN 1 END) FROM pragma_table_info('messages')`).Scan(&columns, &ids)
+	if err != nil {
+		return fmt.Errorf("failed to read the columns of messages: %w", err)
+	}
+	if columns > 0 && ids == 0 {
+		return errors.New("the existing messages table has no id column; copy it into a table with id INTEGER PRIMARY KEY AUTOINCREMENT before migrating")
+	}
+	return nil
+}
+
+// sqliteSearchTriggers keep the messages_fts table in sync with messages.
+var sqliteSearchTriggers = []string{"messages_fts_insert", "messages_fts_delete", "messages_fts_update"}
+
+// sqliteSearchSchema is the external content FTS5 table of messages.content and its triggers. The
+// table is rebuilt from messages, so that it includes the messages stored without the triggers.
+const sqliteSearchSchema = `
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(content, content='messages', content_rowid='id');
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+	INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+	INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+	INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+	INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+END;
+
+INSERT INTO messages_fts (messages_fts) VALUES ('rebuild');
+`
+
+// setUpSQLiteSearch indexes messages in messages_fts if go-sqlite3 is built with FTS5, and returns
+// whether it is. A database can be opened by builds with and without FTS5: without it, the
+// triggers are dropped so that messages can still be inserted, and the next build with FTS5
+// rebuilds the index.
+func setUpSQLiteSearch(db *sql.DB, log waLog.Logger) (bool, error) {
+	var fts5 bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return false, fmt.Errorf("failed to check for FTS5: %w", err)
+	}
+	var triggers int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ($1, $2, $3)`,
+		sqliteSearchTriggers[0], sqliteSearchTriggers[1], sqliteSearchTriggers[2]).Scan(&triggers)
+	if err != nil {
+		return false, fmt.Errorf("failed to read triggers: %w", err)
+	}
+
+	if fts5 && triggers < len(sqliteSearchTriggers) {
+		log.Infof("Building the full-text search index of messages")
+		tx, err := db.Begin()
+		if err != nil {
+			return false, err
+		}
+		if _, err = tx.Exec(sqliteSearchSchema); err != nil {
+			_ = tx.Rollback()
+			return false, fmt.Errorf("failed to create messages_fts: %w", err)
+		}
+		if err = tx.Commit(); err != nil {
+			return false, fmt.Errorf("failed to commit messages_fts: %w", err)
+		}
+	} else if !fts5 && triggers > 0 {
+		log.Warnf("SQLite is built without FTS5, searches won't use the messages_fts index")
+		if _, err = db.Exec(`DROP TRIGGER IF EXISTS ` + strings.Join(sqliteSearchTriggers, `; DROP TRIGGER IF EXISTS `)); err != nil {
+			return false, fmt.Errorf("failed to drop the messages_fts triggers: %w", err)
+		}
+	}
+	return fts5, nil
+}
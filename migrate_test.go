@@ -0,0 +1,113 @@
+package whatsappws
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// openTestDB opens an in-memory SQLite database that lives as long as the test.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	db := openTestDB(t)
+	for i := 0; i < 2; i++ {
+		if err := MigrateChatLog(db, "sqlite3", waLog.Noop); err != nil {
+			t.Fatalf("migration %d failed: %v", i+1, err)
+		}
+	}
+	migrations, err := loadMigrations("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := chatLogSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest := migrations[len(migrations)-1].version; version != latest {
+		t.Errorf("schema version is %d, want %d", version, latest)
+	}
+}
+
+func TestSQLiteSearch(t *testing.T) {
+	db := openTestDB(t)
+	if err := MigrateChatLog(db, "sqlite3", waLog.Noop); err != nil {
+		t.Fatal(err)
+	}
+	store, err := newSQLiteChatLog(db, waLog.Noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, content := range []string{"Your order ORD-12345 has shipped", "100% done", "100 done", "Hello there"} {
+		err = store.InsertMessage(&ChatLogMessage{
+			MessageID: "MSG" + string(rune('A'+i)),
+			DeviceJID: "905551112233@s.whatsapp.net",
+			RemoteJID: "905550000000@s.whatsapp.net",
+			Type:      "text",
+			Content:   content,
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			UserID:    -1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(text string) []ChatLogSearchResult {
+		t.Helper()
+		results, _, err := store.SearchMessages(&ChatLogSearchQuery{
+			ChatLogMessageQuery: ChatLogMessageQuery{DeviceJID: "905551112233@s.whatsapp.net", Limit: 10},
+			Text:                text,
+		})
+		if err != nil {
+			t.Fatalf("search for %q failed: %v", text, err)
+		}
+		return results
+	}
+	if results := search("ord-12345 shipped"); len(results) != 1 || results[0].MessageID != "MSGA" {
+		t.Errorf("search for the order returned %+v", results)
+	} else if !store.fts && results[0].Snippet != "Your order <mark>ORD-12345</mark> has <mark>shipped</mark>" {
+		t.Errorf("snippet is %q", results[0].Snippet)
+	}
+	if results := search("hello"); len(results) != 1 || results[0].MessageID != "MSGD" {
+		t.Errorf("search for hello returned %+v", results)
+	}
+	if !store.fts {
+		// LIKE wildcards in the text match literally.
+		if results := search("100%"); len(results) != 1 || results[0].MessageID != "MSGB" {
+			t.Errorf("search for 100%% returned %+v", results)
+		}
+	}
+	if results := search("missing"); len(results) != 0 {
+		t.Errorf("search for a missing word returned %+v", results)
+	}
+}
+
+func TestMigrateSQLiteLegacyMessages(t *testing.T) {
+	db := openTestDB(t)
+	_, err := db.Exec(`CREATE TABLE messages (message_id TEXT NOT NULL, remote_jid TEXT NOT NULL, content TEXT NOT NULL DEFAULT '')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MigrateChatLog(db, "sqlite3", waLog.Noop)
+	if err == nil || !strings.Contains(err.Error(), "no id column") {
+		t.Fatalf("migration of a messages table without id returned %v", err)
+	}
+	if version, err := chatLogSchemaVersion(db); err != nil || version != 0 {
+		t.Errorf("schema version is %d (%v), want 0", version, err)
+	}
+}
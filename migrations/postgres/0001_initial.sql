@@ -0,0 +1,42 @@
+-- Chat log tables. IF NOT EXISTS keeps the tables of deployments that created them by hand.
+CREATE TABLE IF NOT EXISTS messages (
+	id         BIGSERIAL PRIMARY KEY,
+	message_id TEXT NOT NULL,
+	device_jid TEXT NOT NULL,
+	remote_jid TEXT NOT NULL,
+	type       TEXT NOT NULL DEFAULT '',
+	content    TEXT NOT NULL DEFAULT '',
+	timestamp  TIMESTAMPTZ NOT NULL,
+	sent       BOOLEAN NOT NULL DEFAULT false,
+	file_name  TEXT NOT NULL DEFAULT '',
+	user_id    INTEGER,
+	read_at    TIMESTAMPTZ
+);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS read_at TIMESTAMPTZ;
+-- Hand-made tables may not have the id, which orders the messages of the same second. Existing
+-- rows are numbered in the order they're stored.
+DO $$
+BEGIN
+	IF NOT EXISTS (
+		SELECT FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'messages' AND column_name = 'id'
+	) THEN
+		ALTER TABLE messages ADD COLUMN id BIGSERIAL;
+		CREATE UNIQUE INDEX messages_id_idx ON messages (id);
+	END IF;
+END $$;
+CREATE INDEX IF NOT EXISTS messages_chat_idx ON messages (device_jid, remote_jid, timestamp);
+CREATE INDEX IF NOT EXISTS messages_message_id_idx ON messages (message_id);
+
+CREATE TABLE IF NOT EXISTS last_messages (
+	id         BIGSERIAL PRIMARY KEY,
+	message_id TEXT NOT NULL,
+	device_jid TEXT NOT NULL,
+	remote_jid TEXT NOT NULL UNIQUE,
+	type       TEXT NOT NULL DEFAULT '',
+	content    TEXT NOT NULL DEFAULT '',
+	timestamp  TIMESTAMPTZ NOT NULL,
+	sent       BOOLEAN NOT NULL DEFAULT false,
+	file_name  TEXT NOT NULL DEFAULT '',
+	user_id    INTEGER
+);
@@ -0,0 +1,12 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS played_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS message_receipts (
+	device_jid  TEXT NOT NULL,
+	remote_jid  TEXT NOT NULL,
+	message_id  TEXT NOT NULL,
+	participant TEXT NOT NULL,
+	type        TEXT NOT NULL,
+	timestamp   TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (device_jid, remote_jid, message_id, participant, type)
+);
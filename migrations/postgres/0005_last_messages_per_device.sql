@@ -0,0 +1,4 @@
+-- With several sessions, two accounts can chat with the same contact, so the last message is kept
+-- per device instead of per remote JID.
+ALTER TABLE last_messages DROP CONSTRAINT IF EXISTS last_messages_remote_jid_key;
+CREATE UNIQUE INDEX IF NOT EXISTS last_messages_chat_key ON last_messages (device_jid, remote_jid);
@@ -0,0 +1,7 @@
+-- Sender of the message (the participant in groups) and the message it replies to.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS sender_jid TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS quoted_message_id TEXT NOT NULL DEFAULT '';
+
+-- Mentions are stored as comma-separated JIDs.
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS quoted_message_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS mentions TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Edited and revoked (deleted for everyone) messages.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS revoked BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE last_messages ADD COLUMN IF NOT EXISTS revoked BOOLEAN NOT NULL DEFAULT false;
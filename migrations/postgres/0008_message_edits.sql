@@ -0,0 +1,10 @@
+-- Previous contents of edited messages. edited_at is when the content was replaced.
+CREATE TABLE IF NOT EXISTS message_edits (
+	id         BIGSERIAL PRIMARY KEY,
+	device_jid TEXT NOT NULL,
+	remote_jid TEXT NOT NULL,
+	message_id TEXT NOT NULL,
+	content    TEXT NOT NULL DEFAULT '',
+	edited_at  TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS message_edits_message_idx ON message_edits (device_jid, remote_jid, message_id);
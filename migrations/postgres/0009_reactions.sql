@@ -0,0 +1,10 @@
+-- The current reaction of every reactor to a message. Removed reactions are deleted.
+CREATE TABLE IF NOT EXISTS reactions (
+	device_jid  TEXT NOT NULL,
+	remote_jid  TEXT NOT NULL,
+	message_id  TEXT NOT NULL,
+	reactor_jid TEXT NOT NULL,
+	reaction    TEXT NOT NULL,
+	timestamp   TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (device_jid, remote_jid, message_id, reactor_jid)
+);
@@ -0,0 +1,30 @@
+-- Polls sent or received in a chat, and their options in order.
+CREATE TABLE IF NOT EXISTS polls (
+	device_jid       TEXT NOT NULL,
+	remote_jid       TEXT NOT NULL,
+	message_id       TEXT NOT NULL,
+	name             TEXT NOT NULL,
+	selectable_count INTEGER NOT NULL DEFAULT 0,
+	created_at       TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (device_jid, remote_jid, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS poll_options (
+	device_jid TEXT NOT NULL,
+	remote_jid TEXT NOT NULL,
+	message_id TEXT NOT NULL,
+	position   INTEGER NOT NULL,
+	name       TEXT NOT NULL,
+	PRIMARY KEY (device_jid, remote_jid, message_id, position)
+);
+
+-- The latest vote of every voter. options is a JSON array of the selected option names.
+CREATE TABLE IF NOT EXISTS poll_votes (
+	device_jid TEXT NOT NULL,
+	remote_jid TEXT NOT NULL,
+	message_id TEXT NOT NULL,
+	voter_jid  TEXT NOT NULL,
+	options    TEXT NOT NULL,
+	timestamp  TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (device_jid, remote_jid, message_id, voter_jid)
+);
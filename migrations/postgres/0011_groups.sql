@@ -0,0 +1,15 @@
+-- Joined groups as last fetched from WhatsApp. invite_link is set once a link is generated.
+CREATE TABLE IF NOT EXISTS groups (
+	device_jid        TEXT NOT NULL,
+	group_jid         TEXT NOT NULL,
+	name              TEXT NOT NULL,
+	topic             TEXT NOT NULL DEFAULT '',
+	owner_jid         TEXT NOT NULL DEFAULT '',
+	announce          BOOLEAN NOT NULL DEFAULT false,
+	locked            BOOLEAN NOT NULL DEFAULT false,
+	participant_count INTEGER NOT NULL DEFAULT 0,
+	invite_link       TEXT,
+	created_at        TIMESTAMPTZ,
+	updated_at        TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (device_jid, group_jid)
+);
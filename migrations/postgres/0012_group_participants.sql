@@ -0,0 +1,9 @@
+-- The participants of the joined groups, kept up to date by group info events.
+CREATE TABLE IF NOT EXISTS group_participants (
+	device_jid      TEXT NOT NULL,
+	group_jid       TEXT NOT NULL,
+	participant_jid TEXT NOT NULL,
+	admin           BOOLEAN NOT NULL DEFAULT false,
+	super_admin     BOOLEAN NOT NULL DEFAULT false,
+	PRIMARY KEY (device_jid, group_jid, participant_jid)
+);
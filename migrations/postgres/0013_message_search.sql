@@ -0,0 +1,2 @@
+-- Full-text search of messages.content. The expression must match the one used by the search query.
+CREATE INDEX IF NOT EXISTS messages_content_search_idx ON messages USING GIN (to_tsvector('simple', content));
@@ -0,0 +1,27 @@
+-- Media of received messages. The keys are kept so the file can be downloaded later; path is
+-- relative to the data directory and set once the file is downloaded. status is pending,
+-- downloaded, retry (a media retry was requested from the sender) or failed.
+CREATE TABLE IF NOT EXISTS media (
+	device_jid      TEXT NOT NULL,
+	remote_jid      TEXT NOT NULL,
+	message_id      TEXT NOT NULL,
+	sender_jid      TEXT NOT NULL DEFAULT '',
+	from_me         BOOLEAN NOT NULL DEFAULT false,
+	media_type      TEXT NOT NULL,
+	mimetype        TEXT NOT NULL DEFAULT '',
+	file_name       TEXT NOT NULL DEFAULT '',
+	direct_path     TEXT NOT NULL DEFAULT '',
+	media_key       BYTEA NOT NULL,
+	file_enc_sha256 BYTEA,
+	file_sha256     BYTEA,
+	file_length     BIGINT NOT NULL DEFAULT 0,
+	thumbnail       BYTEA,
+	path            TEXT NOT NULL DEFAULT '',
+	status          TEXT NOT NULL DEFAULT 'pending',
+	error           TEXT NOT NULL DEFAULT '',
+	created_at      TIMESTAMPTZ NOT NULL,
+	updated_at      TIMESTAMPTZ NOT NULL,
+	downloaded_at   TIMESTAMPTZ,
+	PRIMARY KEY (device_jid, remote_jid, message_id)
+);
+CREATE INDEX IF NOT EXISTS media_message_id_idx ON media (device_jid, message_id);
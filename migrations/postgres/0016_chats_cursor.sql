@@ -0,0 +1,2 @@
+-- The chat list is paginated by the timestamp of the last message and the remote JID.
+CREATE INDEX IF NOT EXISTS last_messages_chats_idx ON last_messages (device_jid, timestamp, remote_jid);
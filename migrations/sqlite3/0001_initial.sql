@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS messages (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id TEXT NOT NULL,
+	device_jid TEXT NOT NULL,
+	remote_jid TEXT NOT NULL,
+	type       TEXT NOT NULL DEFAULT '',
+	content    TEXT NOT NULL DEFAULT '',
+	timestamp  TIMESTAMP NOT NULL,
+	sent       BOOLEAN NOT NULL DEFAULT false,
+	file_name  TEXT NOT NULL DEFAULT '',
+	user_id    INTEGER,
+	read_at    TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS messages_chat_idx ON messages (device_jid, remote_jid, timestamp);
+CREATE INDEX IF NOT EXISTS messages_message_id_idx ON messages (message_id);
+
+CREATE TABLE IF NOT EXISTS last_messages (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id TEXT NOT NULL,
+	device_jid TEXT NOT NULL,
+	remote_jid TEXT NOT NULL,
+	type       TEXT NOT NULL DEFAULT '',
+	content    TEXT NOT NULL DEFAULT '',
+	timestamp  TIMESTAMP NOT NULL,
+	sent       BOOLEAN NOT NULL DEFAULT false,
+	file_name  TEXT NOT NULL DEFAULT '',
+	user_id    INTEGER
+);
@@ -0,0 +1,12 @@
+ALTER TABLE messages ADD COLUMN delivered_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN played_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS message_receipts (
+	device_jid  TEXT NOT NULL,
+	remote_jid  TEXT NOT NULL,
+	message_id  TEXT NOT NULL,
+	participant TEXT NOT NULL,
+	type        TEXT NOT NULL,
+	timestamp   TIMESTAMP NOT NULL,
+	PRIMARY KEY (device_jid, remote_jid, message_id, participant, type)
+);
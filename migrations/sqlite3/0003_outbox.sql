@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS outbox (
+	message_id      TEXT PRIMARY KEY,
+	device_jid      TEXT NOT NULL,
+	remote_jid      TEXT NOT NULL,
+	content         TEXT NOT NULL,
+	user_id         INTEGER,
+	status          TEXT NOT NULL,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	last_error      TEXT NOT NULL DEFAULT '',
+	next_attempt_at TIMESTAMP NOT NULL,
+	created_at      TIMESTAMP NOT NULL,
+	sent_at         TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS outbox_due_idx ON outbox (status, next_attempt_at);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	url        TEXT NOT NULL,
+	event_type TEXT NOT NULL,
+	payload    TEXT NOT NULL,
+	attempts   INTEGER NOT NULL,
+	last_error TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1 @@
+CREATE UNIQUE INDEX IF NOT EXISTS last_messages_chat_key ON last_messages (device_jid, remote_jid);
@@ -0,0 +1,4 @@
+-- Edited and revoked (deleted for everyone) messages.
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN revoked BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE last_messages ADD COLUMN revoked BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+-- Full-text search of messages.content. The messages_fts FTS5 table and its triggers are created by
+-- the chat log store instead of a migration, because FTS5 is only available when go-sqlite3 is built
+-- with the sqlite_fts5 tag. Without it, searches fall back to LIKE. See setUpSQLiteSearch.
@@ -0,0 +1,27 @@
+-- Media of received messages. The keys are kept so the file can be downloaded later; path is
+-- relative to the data directory and set once the file is downloaded. status is pending,
+-- downloaded, retry (a media retry was requested from the sender) or failed.
+CREATE TABLE IF NOT EXISTS media (
+	device_jid      TEXT NOT NULL,
+	remote_jid      TEXT NOT NULL,
+	message_id      TEXT NOT NULL,
+	sender_jid      TEXT NOT NULL DEFAULT '',
+	from_me         BOOLEAN NOT NULL DEFAULT false,
+	media_type      TEXT NOT NULL,
+	mimetype        TEXT NOT NULL DEFAULT '',
+	file_name       TEXT NOT NULL DEFAULT '',
+	direct_path     TEXT NOT NULL DEFAULT '',
+	media_key       BLOB NOT NULL,
+	file_enc_sha256 BLOB,
+	file_sha256     BLOB,
+	file_length     INTEGER NOT NULL DEFAULT 0,
+	thumbnail       BLOB,
+	path            TEXT NOT NULL DEFAULT '',
+	status          TEXT NOT NULL DEFAULT 'pending',
+	error           TEXT NOT NULL DEFAULT '',
+	created_at      TIMESTAMP NOT NULL,
+	updated_at      TIMESTAMP NOT NULL,
+	downloaded_at   TIMESTAMP,
+	PRIMARY KEY (device_jid, remote_jid, message_id)
+);
+CREATE INDEX IF NOT EXISTS media_message_id_idx ON media (device_jid, message_id);
@@ -0,0 +1,3 @@
+-- The message as received or sent, so that replies can quote media, locations and other messages
+-- that the content doesn't describe.
+ALTER TABLE messages ADD COLUMN raw_message BLOB;
@@ -0,0 +1,12 @@
+-- Failed webhook deliveries waiting for their next attempt, so that they survive restarts.
+CREATE TABLE IF NOT EXISTS webhook_retries (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	url             TEXT NOT NULL,
+	event_type      TEXT NOT NULL,
+	payload         TEXT NOT NULL,
+	attempts        INTEGER NOT NULL,
+	last_error      TEXT NOT NULL,
+	next_attempt_at TIMESTAMP NOT NULL,
+	created_at      TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_retries_due_idx ON webhook_retries (next_attempt_at);
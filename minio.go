@@ -1,4 +1,4 @@
-package main
+package whatsappws
 
 import (
 	"context"
@@ -8,7 +8,7 @@ import (
 )
 
 // WIP
-func UploadFile(endpoint, accessKey, secretKey, bucket, objectName, filePath string) error {
+func (srv *Server) UploadFile(endpoint, accessKey, secretKey, bucket, objectName, filePath string) error {
 	// Initialize MinIO client object.
 	minioClient, err := minio.New(endpoint, &minio.Options{
 		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
@@ -24,6 +24,6 @@ func UploadFile(endpoint, accessKey, secretKey, bucket, objectName, filePath str
 		return err
 	}
 
-	log.Infof("Successfully uploaded %s to %s\n", filePath, objectName)
+	srv.log.Infof("Successfully uploaded %s to %s\n", filePath, objectName)
 	return nil
 }
@@ -0,0 +1,295 @@
+package whatsappws
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	outboxQueued  = "queued"
+	outboxSending = "sending"
+	outboxSent    = "sent"
+	outboxFailed  = "failed"
+
+	outboxMaxAttempts  = 8
+	outboxPollInterval = time.Second
+	outboxBatchSize    = 50
+	// Delay before retrying when the session of a message is not connected. This doesn't count as
+	// an attempt.
+	outboxOfflineDelay = 5 * time.Second
+)
+
+var (
+	errOutboxNotFound  = errors.New("outbox message not found")
+	errSessionNotFound = errors.New("session not found")
+)
+
+// OutboxMessage is a text message waiting to be sent, or the final status of a sent message. The
+// message ID is generated when queuing and is the WhatsApp message ID once sent.
+type OutboxMessage struct {
+	MessageID     string     `json:"message_id"`
+	DeviceJID     string     `json:"device_jid"`
+	Recipient     string     `json:"recipient"`
+	Message       string     `json:"message"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	// The message the text replies to and the JIDs it mentions.
+	QuotedMessageID string   `json:"quoted_message_id,omitempty"`
+	Mentions        []string `json:"mentions,omitempty"`
+
+	userID int
+}
+
+// textOptions parses the reply and mentions of the message.
+func (msg *OutboxMessage) textOptions() (textOptions, error) {
+	return parseTextOptions(msg.QuotedMessageID, msg.Mentions)
+}
+
+// enqueueTextMessage stores a text message in the outbox. It's sent by the outbox worker.
+func (s *Session) enqueueTextMessage(recipient types.JID, text string, userID int, opts textOptions) (*OutboxMessage, error) {
+	if s.device.ID == nil {
+		return nil, whatsmeow.ErrNotLoggedIn
+	}
+	if opts.QuotedMessageID != "" {
+		if _, err := s.quotedMessage(recipient, opts.QuotedMessageID); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now().UTC()
+	msg := &OutboxMessage{
+		MessageID:     s.cli.GenerateMessageID(),
+		DeviceJID:     s.device.ID.String(),
+		Recipient:     recipient.String(),
+		Message:       text,
+		Status:        outboxQueued,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		userID:        userID,
+
+		QuotedMessageID: opts.QuotedMessageID,
+	}
+	for _, jid := range opts.Mentions {
+		msg.Mentions = append(msg.Mentions, jid.String())
+	}
+	var dbUserID *int
+	if userID != -1 {
+		dbUserID = &userID
+	}
+	_, err := s.srv.db.Exec(`
+		INSERT INTO outbox (message_id, device_jid, remote_jid, content, user_id, status, next_attempt_at, created_at, quoted_message_id, mentions)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, msg.MessageID, msg.DeviceJID, msg.Recipient, msg.Message, dbUserID, msg.Status, msg.NextAttemptAt, msg.CreatedAt, msg.QuotedMessageID, strings.Join(msg.Mentions, ","))
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue message: %w", err)
+	}
+	s.log.Infof("Queued message %s to %s", msg.MessageID, msg.Recipient)
+	return msg, nil
+}
+
+const outboxColumns = `message_id, device_jid, remote_jid, content, user_id, status, attempts, last_error, next_attempt_at, created_at, sent_at, quoted_message_id, mentions`
+
+type scannable interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOutboxMessage(row scannable) (*OutboxMessage, error) {
+	var msg OutboxMessage
+	var userID sql.NullInt64
+	var sentAt sql.NullTime
+	var mentions string
+	err := row.Scan(&msg.MessageID, &msg.DeviceJID, &msg.Recipient, &msg.Message, &userID, &msg.Status, &msg.Attempts, &msg.LastError, &msg.NextAttemptAt, &msg.CreatedAt, &sentAt,
+		&msg.QuotedMessageID, &mentions)
+	if err != nil {
+		return nil, err
+	}
+	msg.userID = -1
+	if userID.Valid {
+		msg.userID = int(userID.Int64)
+	}
+	if sentAt.Valid {
+		msg.SentAt = &sentAt.Time
+	}
+	if mentions != "" {
+		msg.Mentions = strings.Split(mentions, ",")
+	}
+	return &msg, nil
+}
+
+func (srv *Server) getOutboxMessage(messageID string) (*OutboxMessage, error) {
+	msg, err := scanOutboxMessage(srv.db.QueryRow(`SELECT `+outboxColumns+` FROM outbox WHERE message_id = $1`, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errOutboxNotFound
+	}
+	return msg, err
+}
+
+// runOutboxWorker sends the due outbox messages until the context is cancelled.
+func (srv *Server) runOutboxWorker(ctx context.Context) {
+	// Messages that were being sent when the process stopped are sent again.
+	if _, err := srv.db.Exec(`UPDATE outbox SET status = $1 WHERE status = $2`, outboxQueued, outboxSending); err != nil {
+		srv.log.Errorf("Failed to requeue outbox messages: %v", err)
+	}
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			srv.processOutbox()
+		}
+	}
+}
+
+func (srv *Server) processOutbox() {
+	rows, err := srv.db.Query(`
+		SELECT `+outboxColumns+` FROM outbox
+		WHERE status = $1 AND next_attempt_at <= $2
+		ORDER BY next_attempt_at
+		LIMIT $3
+	`, outboxQueued, time.Now().UTC(), outboxBatchSize)
+	if err != nil {
+		srv.log.Errorf("Failed to read outbox: %v", err)
+		return
+	}
+	var due []*OutboxMessage
+	for rows.Next() {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			srv.log.Errorf("Failed to read outbox message: %v", err)
+			continue
+		}
+		due = append(due, msg)
+	}
+	rows.Close()
+
+	for _, msg := range due {
+		srv.sendOutboxMessage(msg)
+	}
+}
+
+func (srv *Server) sendOutboxMessage(msg *OutboxMessage) {
+	sess := srv.sessions.ByJID(msg.DeviceJID)
+	if sess == nil {
+		// The session was removed, so the message can never be sent.
+		srv.failOutboxMessage(msg, errSessionNotFound, true)
+		return
+	} else if !sess.cli.IsConnected() || !sess.cli.IsLoggedIn() {
+		_, err := srv.db.Exec(`UPDATE outbox SET next_attempt_at = $1 WHERE message_id = $2`, time.Now().UTC().Add(outboxOfflineDelay), msg.MessageID)
+		if err != nil {
+			srv.log.Errorf("Failed to reschedule outbox message %s: %v", msg.MessageID, err)
+		}
+		return
+	}
+
+	// Claim the message so it isn't picked up twice.
+	res, err := srv.db.Exec(`UPDATE outbox SET status = $1, attempts = attempts + 1 WHERE message_id = $2 AND status = $3`, outboxSending, msg.MessageID, outboxQueued)
+	if err != nil {
+		srv.log.Errorf("Failed to claim outbox message %s: %v", msg.MessageID, err)
+		return
+	} else if n, _ := res.RowsAffected(); n == 0 {
+		return
+	}
+	msg.Attempts++
+
+	recipient, err := parseJID(msg.Recipient)
+	if err != nil {
+		srv.failOutboxMessage(msg, err, true)
+		return
+	}
+	opts, err := msg.textOptions()
+	if err != nil {
+		srv.failOutboxMessage(msg, err, true)
+		return
+	}
+	waMsg, err := sess.buildTextMessage(recipient, msg.Message, opts)
+	if err != nil {
+		srv.failOutboxMessage(msg, err, true)
+		return
+	}
+	srv.log.Infof("Sending message %s to %s (attempt %d): %s", msg.MessageID, recipient, msg.Attempts, msg.Message)
+	resp, err := sess.cli.SendMessage(context.Background(), recipient, waMsg, whatsmeow.SendRequestExtra{ID: msg.MessageID})
+	if err != nil {
+		srv.log.Errorf("Error sending message %s: %v", msg.MessageID, err)
+		srv.failOutboxMessage(msg, err, msg.Attempts >= outboxMaxAttempts)
+		return
+	}
+	srv.log.Infof("Message %s sent (server timestamp: %s)", msg.MessageID, resp.Timestamp)
+
+	_, err = srv.db.Exec(`UPDATE outbox SET status = $1, sent_at = $2, last_error = '' WHERE message_id = $3`, outboxSent, resp.Timestamp.UTC(), msg.MessageID)
+	if err != nil {
+		srv.log.Errorf("Failed to mark outbox message %s as sent: %v", msg.MessageID, err)
+	}
+
+	sess.storeMessage(&ChatLogMessage{
+		MessageID:       resp.ID,
+		DeviceJID:       msg.DeviceJID,
+		RemoteJID:       recipient.String(),
+		Type:            "text",
+		Content:         msg.Message,
+		Timestamp:       resp.Timestamp,
+		Sent:            true,
+		UserID:          msg.userID,
+		SenderJID:       sess.device.ID.ToNonAD().String(),
+		QuotedMessageID: msg.QuotedMessageID,
+	})
+
+	m := Message{resp.ID, recipient.String(), "text", msg.Message, true, "", sess.ID()}
+	sess.publishMessage(m)
+}
+
+// failOutboxMessage records a failed attempt. The message is retried with exponential backoff
+// until it's final.
+func (srv *Server) failOutboxMessage(msg *OutboxMessage, sendErr error, final bool) {
+	status := outboxQueued
+	nextAttempt := time.Now().UTC().Add(outboxBackoff(msg.Attempts))
+	if final {
+		status = outboxFailed
+		srv.log.Errorf("Giving up on outbox message %s after %d attempts: %v", msg.MessageID, msg.Attempts, sendErr)
+	}
+	_, err := srv.db.Exec(`UPDATE outbox SET status = $1, last_error = $2, next_attempt_at = $3 WHERE message_id = $4`, status, sendErr.Error(), nextAttempt, msg.MessageID)
+	if err != nil {
+		srv.log.Errorf("Failed to update outbox message %s: %v", msg.MessageID, err)
+	}
+}
+
+// outboxBackoff returns 2, 4, 8... seconds, capped at 10 minutes.
+func outboxBackoff(attempts int) time.Duration {
+	delay := time.Second << attempts
+	if delay <= 0 || delay > 10*time.Minute {
+		return 10 * time.Minute
+	}
+	return delay
+}
+
+// serveOutbox returns the status of a queued message: GET /outbox/{message_id}
+func (srv *Server) serveOutbox(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "GET")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	messageID := strings.TrimPrefix(r.URL.Path, "/outbox/")
+	msg, err := srv.getOutboxMessage(messageID)
+	if errors.Is(err, errOutboxNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		srv.handleError(w, http.StatusInternalServerError, "Failed to read outbox", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
@@ -0,0 +1,268 @@
+package whatsappws
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// PollVoteEvent is pushed to WebSocket clients when someone votes in a poll. Options is empty if
+// the vote was retracted.
+type PollVoteEvent struct {
+	MessageID string    `json:"message_id"`
+	Chat      string    `json:"chat"`
+	Voter     string    `json:"voter"`
+	Options   []string  `json:"options"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PollTally is the current result of a poll.
+type PollTally struct {
+	MessageID       string            `json:"message_id"`
+	Chat            string            `json:"chat"`
+	Name            string            `json:"name"`
+	SelectableCount int               `json:"selectable_count"`
+	Options         []PollOptionTally `json:"options"`
+	Voters          int               `json:"voters"`
+	Votes           []ChatLogPollVote `json:"votes"`
+}
+
+// PollOptionTally is the number of votes for an option and who voted for it.
+type PollOptionTally struct {
+	Name   string   `json:"name"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters"`
+}
+
+// pollCreation returns the poll of a message, which can be in any of the poll message versions.
+func pollCreation(msg *waProto.Message) *waProto.PollCreationMessage {
+	switch {
+	case msg.GetPollCreationMessage() != nil:
+		return msg.GetPollCreationMessage()
+	case msg.GetPollCreationMessageV2() != nil:
+		return msg.GetPollCreationMessageV2()
+	case msg.GetPollCreationMessageV3() != nil:
+		return msg.GetPollCreationMessageV3()
+	}
+	return nil
+}
+
+// storePoll stores the options of a sent or received poll, so that votes can be mapped to them.
+func (s *Session) storePoll(messageID string, chat types.JID, poll *waProto.PollCreationMessage, timestamp time.Time) {
+	stored := &ChatLogPoll{
+		MessageID:       messageID,
+		DeviceJID:       s.device.ID.String(),
+		RemoteJID:       chat.String(),
+		Name:            poll.GetName(),
+		SelectableCount: int(poll.GetSelectableOptionsCount()),
+		CreatedAt:       timestamp,
+	}
+	for _, option := range poll.GetOptions() {
+		stored.Options = append(stored.Options, option.GetOptionName())
+	}
+	if err := s.srv.chatLog.InsertPoll(stored); err != nil {
+		s.log.Errorf("Error inserting into polls: %v", err)
+	}
+}
+
+// handlePollVote maps the option hashes of a decrypted vote to the names of the poll's options,
+// then stores and publishes the vote.
+func (s *Session) handlePollVote(evt *events.Message, vote *waProto.PollVoteMessage) {
+	update := evt.Message.GetPollUpdateMessage()
+	messageID := update.GetPollCreationMessageKey().GetId()
+	chat := evt.Info.Chat.String()
+	poll, err := s.srv.chatLog.GetPoll(messageID, s.device.ID.String(), chat)
+	if err != nil {
+		s.log.Warnf("Failed to get poll %s in %s for vote: %v", messageID, chat, err)
+		return
+	}
+
+	names := make(map[string]string, len(poll.Options))
+	for i, hash := range whatsmeow.HashPollOptions(poll.Options) {
+		names[hex.EncodeToString(hash)] = poll.Options[i]
+	}
+	options := []string{}
+	for _, hash := range vote.GetSelectedOptions() {
+		name, ok := names[hex.EncodeToString(hash)]
+		if !ok {
+			s.log.Warnf("Vote in poll %s for unknown option %X", messageID, hash)
+			continue
+		}
+		options = append(options, name)
+	}
+
+	timestamp := evt.Info.Timestamp
+	if ms := update.GetSenderTimestampMs(); ms > 0 {
+		timestamp = time.UnixMilli(ms)
+	}
+	voter := evt.Info.Sender.ToNonAD().String()
+	err = s.srv.chatLog.SetPollVote(&ChatLogPollVote{
+		MessageID: messageID,
+		DeviceJID: s.device.ID.String(),
+		RemoteJID: chat,
+		VoterJID:  voter,
+		Options:   options,
+		Timestamp: timestamp,
+	})
+	if err != nil {
+		s.log.Errorf("Error inserting into poll_votes: %v", err)
+	}
+	s.publish("poll_vote", PollVoteEvent{MessageID: messageID, Chat: chat, Voter: voter, Options: options, Timestamp: timestamp})
+}
+
+// validatePoll checks a poll before it's sent. Votes refer to options by the hash of their name,
+// so the names must be unique.
+func validatePoll(name string, options []string, selectableCount int) error {
+	if name == "" {
+		return errors.New("poll name is required")
+	} else if len(options) < 2 {
+		return errors.New("a poll needs at least 2 options")
+	} else if selectableCount < 0 || selectableCount > len(options) {
+		return fmt.Errorf("selectable count must be between 0 and %d", len(options))
+	}
+	seen := make(map[string]bool, len(options))
+	for _, option := range options {
+		if option == "" || seen[option] {
+			return errors.New("poll options must be unique and not empty")
+		}
+		seen[option] = true
+	}
+	return nil
+}
+
+// createPoll sends a poll that was checked with validatePoll and stores it with its options.
+func (s *Session) createPoll(chat types.JID, name string, options []string, selectableCount int, userID int) (*sendResult, error) {
+	msg := s.cli.BuildPollCreation(name, options, selectableCount)
+	result, err := s.sendAndStore(chat, msg, "poll", name, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.storePoll(result.MessageID, chat, msg.GetPollCreationMessage(), result.Timestamp)
+	return result, nil
+}
+
+// pollTally counts the latest vote of every voter.
+func (s *Session) pollTally(chat types.JID, messageID string) (*PollTally, error) {
+	poll, err := s.srv.chatLog.GetPoll(messageID, s.device.ID.String(), chat.String())
+	if err != nil {
+		return nil, err
+	}
+	votes, err := s.srv.chatLog.PollVotes(messageID, s.device.ID.String(), chat.String())
+	if err != nil {
+		return nil, err
+	}
+
+	tally := &PollTally{
+		MessageID:       messageID,
+		Chat:            chat.String(),
+		Name:            poll.Name,
+		SelectableCount: poll.SelectableCount,
+		Options:         make([]PollOptionTally, len(poll.Options)),
+		Votes:           votes,
+	}
+	index := make(map[string]int, len(poll.Options))
+	for i, option := range poll.Options {
+		tally.Options[i] = PollOptionTally{Name: option, Voters: []string{}}
+		index[option] = i
+	}
+	for _, vote := range votes {
+		if len(vote.Options) > 0 {
+			tally.Voters++
+		}
+		for _, option := range vote.Options {
+			if i, ok := index[option]; ok {
+				tally.Options[i].Votes++
+				tally.Options[i].Voters = append(tally.Options[i].Voters, vote.VoterJID)
+			}
+		}
+	}
+	return tally, nil
+}
+
+// serveCreatePoll sends a poll: POST /polls
+func (srv *Server) serveCreatePoll(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "POST")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	} else if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	sess := srv.requestSession(r)
+	if sess == nil || !sess.cli.IsLoggedIn() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var body struct {
+		Chat            string   `json:"chat" validate:"required"`
+		Name            string   `json:"name" validate:"required"`
+		Options         []string `json:"options" validate:"required"`
+		SelectableCount int      `json:"selectable_count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+	chat, err := parseJID(body.Chat)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = validatePoll(body.Name, body.Options, body.SelectableCount); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := sess.createPoll(chat, body.Name, body.Options, body.SelectableCount, boundUserID(requestIdentity(r), -1))
+	if err != nil {
+		srv.handleError(w, http.StatusInternalServerError, "Failed to send poll", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// servePoll returns the tally of a poll: GET /polls/{message_id}?chat={jid}
+func (srv *Server) servePoll(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "GET")
+	switch r.Method {
+	case "OPTIONS":
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodGet:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	sess := srv.requestSession(r)
+	if sess == nil || sess.device.ID == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	messageID := strings.TrimPrefix(r.URL.Path, "/polls/")
+	chat, err := parseJID(r.URL.Query().Get("chat"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tally, err := sess.pollTally(chat, messageID)
+	if errors.Is(err, errPollNotFound) {
+		http.Error(w, "Poll not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		srv.handleError(w, http.StatusInternalServerError, "Failed to read poll", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tally)
+}
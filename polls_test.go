@@ -0,0 +1,85 @@
+package whatsappws
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPollVotesAndTally(t *testing.T) {
+	srv, fake := newTestServer(t, nil)
+	options := []string{"Pizza", "Sushi", "Salad"}
+
+	var created sendResult
+	w := serveJSON(t, srv, http.MethodPost, "/polls", map[string]interface{}{"chat": testGroup.String(), "name": "Lunch?", "options": options, "selectable_count": 2})
+	decodeResponse(t, w, http.StatusOK, &created)
+	sent := fake.SentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	if poll := pollCreation(sent[0].Message); poll.GetName() != "Lunch?" || len(poll.GetOptions()) != 3 || poll.GetSelectableOptionsCount() != 2 {
+		t.Errorf("sent poll is %v", sent[0].Message)
+	}
+
+	// The fake client can't decrypt votes, so every vote message carries the options to select.
+	hashes := whatsmeow.HashPollOptions(options)
+	selected := make(map[string][][]byte)
+	fake.DecryptPollVoteFunc = func(vote *events.Message) (*waProto.PollVoteMessage, error) {
+		return &waProto.PollVoteMessage{SelectedOptions: selected[vote.Info.ID]}, nil
+	}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	vote := func(id string, voter types.JID, minutes int, choices ...int) {
+		for _, i := range choices {
+			selected[id] = append(selected[id], hashes[i])
+		}
+		fake.Emit(receivedMessage(id, testGroup, voter, start.Add(time.Duration(minutes)*time.Minute), &waProto.Message{PollUpdateMessage: &waProto.PollUpdateMessage{
+			PollCreationMessageKey: &waProto.MessageKey{Id: proto.String(created.MessageID)},
+		}}))
+	}
+	alice := types.NewJID("905550000001", types.DefaultUserServer)
+	bob := types.NewJID("905550000002", types.DefaultUserServer)
+	carol := types.NewJID("905550000003", types.DefaultUserServer)
+	vote("VOTE1", alice, 1, 0, 1)
+	vote("VOTE2", bob, 2, 1)
+	// A changed vote replaces the earlier one, and a retracted vote counts for nothing.
+	vote("VOTE3", alice, 3, 2)
+	vote("VOTE4", carol, 4, 0)
+	vote("VOTE5", carol, 5)
+
+	var tally PollTally
+	decodeResponse(t, serve(srv, http.MethodGet, "/polls/"+created.MessageID+"?chat="+testGroup.String(), "", nil), http.StatusOK, &tally)
+	if tally.MessageID != created.MessageID || tally.Name != "Lunch?" || tally.SelectableCount != 2 || tally.Voters != 2 || len(tally.Votes) != 3 {
+		t.Errorf("tally is %+v", tally)
+	}
+	for i, want := range []struct {
+		name   string
+		voters []string
+	}{
+		{"Pizza", nil},
+		{"Sushi", []string{bob.String()}},
+		{"Salad", []string{alice.String()}},
+	} {
+		if i >= len(tally.Options) {
+			t.Fatalf("tally has %d options, want 3", len(tally.Options))
+		}
+		option := tally.Options[i]
+		if option.Name != want.name || option.Votes != len(want.voters) || len(option.Voters) != len(want.voters) ||
+			(len(want.voters) > 0 && option.Voters[0] != want.voters[0]) {
+			t.Errorf("option %d is %+v, want %s with voters %v", i, option, want.name, want.voters)
+		}
+	}
+
+	if w = serve(srv, http.MethodGet, "/polls/UNKNOWN?chat="+testGroup.String(), "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("status of an unknown poll is %d, want 404", w.Code)
+	}
+	w = serveJSON(t, srv, http.MethodPost, "/polls", map[string]interface{}{"chat": testGroup.String(), "name": "Lunch?", "options": []string{"Pizza", "Pizza"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status of a poll with duplicate options is %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package whatsappws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// ReactionEvent is pushed to WebSocket clients when a reaction to a message is added, changed or
+// removed. Reaction is empty if it was removed.
+type ReactionEvent struct {
+	MessageID string    `json:"message_id"`
+	Chat      string    `json:"chat"`
+	Sender    string    `json:"sender"`
+	Reaction  string    `json:"reaction"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// handleReaction stores and publishes a plain or decrypted reaction.
+func (s *Session) handleReaction(evt *events.Message, reaction *waProto.ReactionMessage) {
+	chat := evt.Info.Chat.String()
+	if chat == "status@broadcast" {
+		return
+	}
+	timestamp := evt.Info.Timestamp
+	if ms := reaction.GetSenderTimestampMs(); ms > 0 {
+		timestamp = time.UnixMilli(ms)
+	}
+	s.saveReaction(&ReactionEvent{
+		MessageID: reaction.GetKey().GetId(),
+		Chat:      chat,
+		Sender:    evt.Info.Sender.ToNonAD().String(),
+		Reaction:  reaction.GetText(),
+		Timestamp: timestamp,
+	})
+}
+
+func (s *Session) saveReaction(evt *ReactionEvent) {
+	err := s.srv.chatLog.SetReaction(&ChatLogReaction{
+		MessageID:  evt.MessageID,
+		DeviceJID:  s.device.ID.String(),
+		RemoteJID:  evt.Chat,
+		ReactorJID: evt.Sender,
+		Reaction:   evt.Reaction,
+		Timestamp:  evt.Timestamp,
+	})
+	if err != nil {
+		s.log.Errorf("Error storing reaction: %v", err)
+	}
+	s.publish("reaction", evt)
+}
+
+// reactToMessage reacts to a stored message with an emoji, or removes our reaction if it's empty.
+func (s *Session) reactToMessage(chat types.JID, messageID, reaction string) (*sendResult, error) {
+	target, err := s.srv.chatLog.GetMessage(messageID, s.device.ID.String(), chat.String())
+	if err != nil {
+		return nil, err
+	} else if target.Revoked {
+		return nil, errMessageRevoked
+	}
+	// The reaction refers to the message by its author.
+	sender := types.EmptyJID
+	if !target.Sent {
+		sender = chat
+		if target.SenderJID != "" {
+			if sender, err = types.ParseJID(target.SenderJID); err != nil {
+				return nil, fmt.Errorf("invalid sender of message %s: %w", messageID, err)
+			}
+		}
+	}
+
+	s.log.Infof("Reacting to message %s in %s: %q", messageID, chat, reaction)
+	resp, err := s.cli.SendMessage(context.Background(), chat, s.cli.BuildReaction(chat, sender, messageID, reaction))
+	if err != nil {
+		return nil, fmt.Errorf("error sending reaction: %w", err)
+	}
+
+	s.saveReaction(&ReactionEvent{
+		MessageID: messageID,
+		Chat:      chat.String(),
+		Sender:    s.device.ID.ToNonAD().String(),
+		Reaction:  reaction,
+		Timestamp: resp.Timestamp,
+	})
+	return &sendResult{MessageID: messageID, Recipient: chat.String(), Timestamp: resp.Timestamp}, nil
+}
+
+func (s *Session) handleReact(args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, errors.New("usage: react <message_id> <jid> [emoji]")
+	}
+	chat, err := parseJID(args[1])
+	if err != nil {
+		return nil, err
+	}
+	var reaction string
+	if len(args) > 2 {
+		reaction = args[2]
+	}
+	return s.reactToMessage(chat, args[0], reaction)
+}
@@ -0,0 +1,119 @@
+package whatsappws
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+)
+
+var errEmptySearch = errors.New("search text is empty")
+
+// searchFilters are the parameters of a search besides the text, which the search command takes as
+// key=value arguments.
+var searchFilters = []string{"chat", "direction", "type", "since", "until", "limit", "before"}
+
+// SearchPage is a page of search results, newest first. NextCursor is passed as before to get the
+// next page, and is empty on the last page.
+type SearchPage struct {
+	Results    []ChatLogSearchResult `json:"results"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+}
+
+// parseSearchQuery reads the q parameter and the searchFilters.
+func parseSearchQuery(values url.Values) (*ChatLogSearchQuery, error) {
+	messageQuery, err := parseMessageQuery(values)
+	if err != nil {
+		return nil, err
+	}
+	query := &ChatLogSearchQuery{ChatLogMessageQuery: messageQuery, Text: strings.TrimSpace(values.Get("q"))}
+	if query.Text == "" {
+		return nil, errEmptySearch
+	}
+	if chat := values.Get("chat"); chat != "" {
+		jid, err := parseJID(chat)
+		if err != nil {
+			return nil, err
+		}
+		query.RemoteJID = jid.String()
+	}
+	switch direction := values.Get("direction"); direction {
+	case "":
+	case "sent", "received":
+		sent := direction == "sent"
+		query.Sent = &sent
+	default:
+		return nil, fmt.Errorf("invalid direction %q: must be sent or received", direction)
+	}
+	return query, nil
+}
+
+func (s *Session) searchMessages(query *ChatLogSearchQuery) (*SearchPage, error) {
+	query.DeviceJID = s.device.ID.String()
+	results, next, err := s.srv.chatLog.SearchMessages(query)
+	if err != nil {
+		return nil, err
+	}
+	page := &SearchPage{Results: results}
+	if next != nil {
+		page.NextCursor = encodeCursor(next)
+	}
+	return page, nil
+}
+
+func (s *Session) handleSearch(args []string) (interface{}, error) {
+	values := url.Values{}
+	var words []string
+	for _, arg := range args {
+		if key, value, ok := strings.Cut(arg, "="); ok && stringContains(searchFilters, key) {
+			values.Add(key, value)
+		} else {
+			words = append(words, arg)
+		}
+	}
+	values.Set("q", strings.Join(words, " "))
+	query, err := parseSearchQuery(values)
+	if errors.Is(err, errEmptySearch) {
+		return nil, errors.New("usage: search [chat=<jid>] [direction=sent|received] [type=<types>] [since=<time>] [until=<time>] [limit=<n>] [before=<cursor>] <text>")
+	} else if err != nil {
+		return nil, err
+	}
+	if s.device.ID == nil {
+		return nil, whatsmeow.ErrNotLoggedIn
+	}
+	return s.searchMessages(query)
+}
+
+// serveSearch searches the content of the messages of the session.
+func (srv *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "GET")
+	switch r.Method {
+	case "OPTIONS":
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodGet:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	sess := srv.requestSession(r)
+	if sess == nil || sess.device.ID == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	query, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := sess.searchMessages(query)
+	if err != nil {
+		srv.handleError(w, http.StatusInternalServerError, "Failed to search messages", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
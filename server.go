@@ -0,0 +1,223 @@
+// Copyright (c) 2021 Tulir Asokan
+// Modified by (c) 2024 huzairuje
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package whatsappws serves WhatsApp accounts over HTTP and WebSocket and keeps a chat log of their
+// messages. The whatsapp-ws command in cmd/whatsapp-ws wires it up from flags; other programs can
+// embed a Server and mount its Handler.
+package whatsappws
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Config is everything a Server needs. The databases are opened by the caller, so that they can be
+// shared with the rest of the program.
+type Config struct {
+	// Logger of the server. Defaults to stdout at LogLevel.
+	Logger waLog.Logger
+	// LogLevel of the WhatsApp clients, e.g. INFO or DEBUG.
+	LogLevel string
+
+	// SessionStore holds the linked WhatsApp devices.
+	SessionStore *sqlstore.Container
+	// ChatLogDB is the chat log database, ChatLogDialect is postgres or sqlite3.
+	ChatLogDB      *sql.DB
+	ChatLogDialect string
+	// AutoMigrate applies the pending chat log migrations in NewServer.
+	AutoMigrate bool
+	// ChatLog replaces the SQL store of messages, read state and receipts, e.g. with a
+	// MemoryChatLog in tests. The outbox and webhook dead letters are always in ChatLogDB.
+	ChatLog ChatLogStore
+
+	// DataDir is the directory files are served from and received media is downloaded into.
+	DataDir string
+	// AuthConfig is the path to a JSON file with API keys, AuthSecret signs bearer tokens. If both
+	// are empty, the endpoints are not authenticated.
+	AuthConfig string
+	AuthSecret string
+	// AllowedOrigins is a comma-separated list of origins allowed for CORS and WebSocket
+	// connections, * allows all.
+	AllowedOrigins string
+	// WebhookConfig is the path to a JSON file with webhook endpoints.
+	WebhookConfig string
+}
+
+// Server owns the sessions, stores, WebSocket hub and webhooks of one whatsapp-ws instance.
+type Server struct {
+	log      waLog.Logger
+	logLevel string
+	dataDir  string
+
+	db       *sql.DB
+	chatLog  ChatLogStore
+	sessions *SessionManager
+	hub      *Hub
+	auth     *Authenticator
+	webhooks *Webhooks
+
+	upgrader      websocket.Upgrader
+	sessionRoutes map[string]http.HandlerFunc
+	mux           *http.ServeMux
+	cancel        context.CancelFunc
+
+	historySyncID int32
+}
+
+// NewServer creates a server and its routes. Call Start to connect the sessions.
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.SessionStore == nil || cfg.ChatLogDB == nil {
+		return nil, errors.New("session store and chat log database are required")
+	}
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = "INFO"
+	}
+	srv := &Server{
+		log:      cfg.Logger,
+		logLevel: cfg.LogLevel,
+		dataDir:  cfg.DataDir,
+		db:       cfg.ChatLogDB,
+		mux:      http.NewServeMux(),
+	}
+	if srv.log == nil {
+		srv.log = waLog.Stdout("Main", cfg.LogLevel, true)
+	}
+
+	var err error
+	srv.auth, err = newAuthenticator(cfg.AuthConfig, cfg.AuthSecret, cfg.AllowedOrigins)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load authentication config: %w", err)
+	}
+	if !srv.auth.Enabled() {
+		srv.log.Warnf("No API keys or token secret configured, the HTTP and WebSocket endpoints are not authenticated")
+	}
+
+	if cfg.AutoMigrate {
+		if err = MigrateChatLog(cfg.ChatLogDB, cfg.ChatLogDialect, srv.log); err != nil {
+			return nil, fmt.Errorf("failed to migrate chatlog database: %w", err)
+		}
+	}
+	srv.chatLog = cfg.ChatLog
+	if srv.chatLog == nil {
+		srv.chatLog, err = newChatLogStore(cfg.ChatLogDialect, cfg.ChatLogDB, srv.log)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	srv.webhooks, err = newWebhooks(cfg.WebhookConfig, cfg.ChatLogDB, srv.log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhook config: %w", err)
+	}
+
+	srv.hub = newHub(srv.log)
+	srv.sessions = newSessionManager(srv, cfg.SessionStore)
+	srv.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			return srv.auth.CheckOrigin(r.Header.Get("Origin"))
+		},
+	}
+	srv.routes()
+	return srv, nil
+}
+
+func (srv *Server) routes() {
+	srv.sessionRoutes = srv.newSessionRoutes()
+	for action, handler := range srv.sessionRoutes {
+		srv.mux.HandleFunc("/"+action, handler)
+	}
+	srv.mux.HandleFunc("/outbox/", srv.auth.requireScope(scopeRead, srv.serveOutbox))
+	srv.mux.HandleFunc("/sessions", srv.auth.requireScope(scopeAdmin, srv.serveSessions))
+	srv.mux.HandleFunc("/sessions/", srv.auth.requireScope(scopeRead, srv.serveSessionRoute))
+}
+
+// Handler returns the HTTP and WebSocket endpoints.
+func (srv *Server) Handler() http.Handler {
+	return srv.mux
+}
+
+// Sessions returns the session manager, e.g. to attach sessions with a FakeClient in tests.
+func (srv *Server) Sessions() *SessionManager {
+	return srv.sessions
+}
+
+// Start starts the WebSocket hub, the webhook and outbox workers and a session for every stored
+// device. Without stored devices, Start creates an unpaired session.
+func (srv *Server) Start() error {
+	go srv.hub.run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv.cancel = cancel
+	srv.webhooks.Start(ctx)
+	if err := srv.sessions.LoadAll(); err != nil {
+		cancel()
+		return fmt.Errorf("failed to start sessions: %w", err)
+	}
+	go srv.runOutboxWorker(ctx)
+	return nil
+}
+
+// Close stops the outbox and webhook retry workers and disconnects every session.
+func (srv *Server) Close() {
+	if srv.cancel != nil {
+		srv.cancel()
+	}
+	srv.sessions.DisconnectAll()
+}
+
+// HandleConsoleInput runs a line typed on the console. r or a rejects or accepts a pending pair,
+// anything else is a command such as "send <jid> <text>" for the default session.
+func (srv *Server) HandleConsoleInput(line string) {
+	if srv.handlePairInput(line) {
+		return
+	}
+	sess := srv.sessions.Default()
+	if sess == nil {
+		srv.log.Errorf("No session to run %q on", line)
+		return
+	}
+	var command Command
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return
+	}
+	command.Cmd = strings.ToLower(args[0])
+	command.Arguments = args[1:]
+
+	go func() {
+		if _, err := sess.handleCmd(command); err != nil {
+			srv.log.Errorf("Command %s failed: %v", command.Cmd, err)
+		}
+	}()
+}
+
+// handlePairInput forwards r (reject) or a (accept) to the sessions that are waiting for a pair
+// confirmation. It returns false if no session is waiting.
+func (srv *Server) handlePairInput(cmd string) bool {
+	waiting := false
+	for _, sess := range srv.sessions.List() {
+		if !sess.isWaitingForPair.Load() {
+			continue
+		}
+		waiting = true
+		if cmd == "r" {
+			sess.pairRejectChan <- true
+		} else if cmd == "a" {
+			sess.pairRejectChan <- false
+		}
+	}
+	return waiting
+}
@@ -0,0 +1,101 @@
+package whatsappws
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// testPhone is the phone number of the session of newTestServer.
+const testPhone = "905551112233"
+
+// newTestServer returns a server with a SQLite chat log and a session attached to a FakeClient.
+// chatLog replaces the SQL store of the chat log if it isn't nil. The WebSocket hub is running, the
+// outbox worker isn't, so tests send the outbox with processOutbox.
+func newTestServer(t *testing.T, chatLog ChatLogStore) (*Server, *FakeClient) {
+	t.Helper()
+	container, err := sqlstore.New("sqlite3", "file:"+t.Name()+"-sessions?mode=memory&cache=shared&_foreign_keys=on", waLog.Noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(Config{
+		Logger:         waLog.Noop,
+		SessionStore:   container,
+		ChatLogDB:      openTestDB(t),
+		ChatLogDialect: "sqlite3",
+		AutoMigrate:    true,
+		ChatLog:        chatLog,
+		DataDir:        t.TempDir(),
+		AllowedOrigins: "*",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.hub.run()
+	fake := &FakeClient{}
+	srv.Sessions().Attach(testPhone, NewFakeDevice(testPhone), fake)
+	t.Cleanup(srv.Close)
+	return srv, fake
+}
+
+// serve runs a request against the server.
+func serve(srv *Server, method, target, contentType string, body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, body)
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, r)
+	return w
+}
+
+// serveJSON runs a request with a JSON body, which is encoded if it isn't a string.
+func serveJSON(t *testing.T, srv *Server, method, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	data, ok := body.(string)
+	if !ok {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = string(encoded)
+	}
+	return serve(srv, method, target, "application/json", bytes.NewBufferString(data))
+}
+
+// decodeResponse decodes the JSON body of a response, failing the test if the status isn't the
+// expected one.
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status is %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("failed to decode %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestSessionRoutes(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+
+	var status struct {
+		ID      string `json:"id"`
+		IsLogin bool   `json:"isLogin"`
+	}
+	decodeResponse(t, serve(srv, http.MethodGet, "/sessions/"+testPhone+"/status", "", nil), http.StatusOK, &status)
+	if status.ID != testPhone+"@s.whatsapp.net" || !status.IsLogin {
+		t.Errorf("status of the session is %+v", status)
+	}
+	if w := serve(srv, http.MethodGet, "/sessions/905550000000/status", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("status of an unknown session is %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,444 @@
+package whatsappws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdp/qrterminal/v3"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Session is a single WhatsApp account served by this process.
+type Session struct {
+	idLock sync.RWMutex
+	id     string
+	cli    WAClient
+	device *store.Device
+	srv    *Server
+	log    waLog.Logger
+
+	qrLock sync.RWMutex
+	qrStr  string
+
+	pairRejectChan   chan bool
+	isWaitingForPair atomic.Bool
+
+	mediaLock      sync.Mutex
+	mediaDownloads map[string]*mediaDownload
+}
+
+// SessionManager owns one Session per device stored in the session database.
+type SessionManager struct {
+	srv       *Server
+	container *sqlstore.Container
+
+	lock      sync.RWMutex
+	sessions  map[string]*Session
+	defaultID string
+}
+
+type sessionContextKey struct{}
+
+func newSessionManager(srv *Server, container *sqlstore.Container) *SessionManager {
+	return &SessionManager{
+		srv:       srv,
+		container: container,
+		sessions:  make(map[string]*Session),
+	}
+}
+
+// LoadAll starts a session for every device in the store. If there are no sessions at all, a new
+// unpaired session is created so that the first account can be linked through the QR code.
+func (m *SessionManager) LoadAll() error {
+	devices, err := m.container.GetAllDevices()
+	if err != nil {
+		return fmt.Errorf("failed to get devices: %w", err)
+	}
+	if len(devices) == 0 && len(m.List()) == 0 {
+		_, err = m.Create()
+		return err
+	}
+	for _, device := range devices {
+		if _, err = m.start(device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create starts a new unpaired session. Its ID is temporary until the QR code is scanned, after
+// which the session is renamed to the phone number of the linked account.
+func (m *SessionManager) Create() (*Session, error) {
+	return m.start(m.container.NewDevice())
+}
+
+func (m *SessionManager) start(device *store.Device) (*Session, error) {
+	id := newSessionID(device)
+	client := whatsmeow.NewClient(device, waLog.Stdout("Client/"+id, m.srv.logLevel, true))
+	sess := m.Attach(id, device, client)
+	client.PrePairCallback = sess.prePairCallback
+
+	ch, err := client.GetQRChannel(context.Background())
+	if err != nil {
+		// This error means that we're already logged in, so ignore it.
+		if !errors.Is(err, whatsmeow.ErrQRStoreContainsID) {
+			m.remove(sess.ID())
+			return nil, fmt.Errorf("failed to get QR channel for session %s: %w", sess.ID(), err)
+		}
+	} else {
+		go sess.watchQR(ch)
+	}
+
+	m.srv.log.Infof("Starting session %s (device: %v)", sess.ID(), device.ID)
+	if err = sess.cli.Connect(); err != nil {
+		m.remove(sess.ID())
+		return nil, fmt.Errorf("failed to connect session %s: %w", sess.ID(), err)
+	}
+	sess.cli.SetStatusMessage("Check")
+	return sess, nil
+}
+
+// Attach registers a session that uses the given client, e.g. a FakeClient in tests. The client
+// must already be paired, the device is only used for its JID and push name.
+func (m *SessionManager) Attach(id string, device *store.Device, client WAClient) *Session {
+	sess := &Session{
+		id:             id,
+		cli:            client,
+		device:         device,
+		srv:            m.srv,
+		log:            m.srv.log,
+		pairRejectChan: make(chan bool, 1),
+	}
+	client.AddEventHandler(sess.eventHandler)
+
+	m.lock.Lock()
+	m.sessions[sess.ID()] = sess
+	if m.defaultID == "" {
+		m.defaultID = sess.ID()
+	}
+	m.lock.Unlock()
+	return sess
+}
+
+// Get returns the session with the given ID, or nil if there is no such session.
+func (m *SessionManager) Get(id string) *Session {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.sessions[id]
+}
+
+// ByJID returns the session of the device with the given JID, or nil if there is no such session.
+func (m *SessionManager) ByJID(jid string) *Session {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, sess := range m.sessions {
+		if sess.device.ID != nil && sess.device.ID.String() == jid {
+			return sess
+		}
+	}
+	return nil
+}
+
+// Default returns the session used by the routes that aren't scoped to a session.
+func (m *SessionManager) Default() *Session {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.sessions[m.defaultID]
+}
+
+// List returns all sessions ordered by ID.
+func (m *SessionManager) List() []*Session {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	list := make([]*Session, 0, len(m.sessions))
+	for _, sess := range m.sessions {
+		list = append(list, sess)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID() < list[j].ID()
+	})
+	return list
+}
+
+// Delete logs out the session (or just disconnects it if it was never paired) and removes it.
+func (m *SessionManager) Delete(id string) error {
+	sess := m.Get(id)
+	if sess == nil {
+		return fmt.Errorf("session %s not found", id)
+	}
+	if sess.cli.IsLoggedIn() {
+		if err := sess.cli.Logout(); err != nil {
+			return fmt.Errorf("failed to log out session %s: %w", id, err)
+		}
+	} else {
+		sess.cli.Disconnect()
+		if sess.device.ID != nil {
+			if err := sess.device.Delete(); err != nil {
+				return fmt.Errorf("failed to delete device of session %s: %w", id, err)
+			}
+		}
+	}
+	m.remove(id)
+	m.srv.log.Infof("Deleted session %s", id)
+	return nil
+}
+
+// DisconnectAll disconnects every session, used on shutdown.
+func (m *SessionManager) DisconnectAll() {
+	for _, sess := range m.List() {
+		sess.cli.Disconnect()
+	}
+}
+
+func (m *SessionManager) remove(id string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.sessions, id)
+	if m.defaultID == id {
+		m.defaultID = ""
+		for otherID := range m.sessions {
+			if m.defaultID == "" || otherID < m.defaultID {
+				m.defaultID = otherID
+			}
+		}
+	}
+}
+
+func (m *SessionManager) rename(sess *Session, newID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	oldID := sess.ID()
+	if oldID == newID {
+		return
+	}
+	delete(m.sessions, oldID)
+	sess.idLock.Lock()
+	sess.id = newID
+	sess.idLock.Unlock()
+	m.sessions[newID] = sess
+	if m.defaultID == oldID {
+		m.defaultID = newID
+	}
+	m.srv.log.Infof("Session %s paired, renamed to %s", oldID, newID)
+}
+
+// ID returns the ID of the session, which changes from a temporary ID to the phone number when
+// the session is paired.
+func (s *Session) ID() string {
+	s.idLock.RLock()
+	defer s.idLock.RUnlock()
+	return s.id
+}
+
+func newSessionID(device *store.Device) string {
+	if device.ID != nil {
+		return device.ID.User
+	}
+	random := make([]byte, 4)
+	_, _ = rand.Read(random)
+	return "pending-" + hex.EncodeToString(random)
+}
+
+func (s *Session) prePairCallback(jid types.JID, platform, businessName string) bool {
+	s.isWaitingForPair.Store(true)
+	defer s.isWaitingForPair.Store(false)
+	s.log.Infof("Pairing %s on session %s (platform: %q, business name: %q). Type r within 3 seconds to reject pair", jid, s.ID(), platform, businessName)
+	select {
+	case reject := <-s.pairRejectChan:
+		if reject {
+			s.log.Infof("Rejecting pair")
+			return false
+		}
+	case <-time.After(3 * time.Second):
+	}
+	s.log.Infof("Accepting pair")
+	return true
+}
+
+func (s *Session) watchQR(ch <-chan whatsmeow.QRChannelItem) {
+	for evt := range ch {
+		if evt.Event == "code" {
+			s.qrLock.Lock()
+			s.qrStr = evt.Code
+			s.qrLock.Unlock()
+			qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
+		} else {
+			s.log.Infof("QR channel result for session %s: %s", s.ID(), evt.Event)
+		}
+	}
+}
+
+func (s *Session) getQR() string {
+	s.qrLock.RLock()
+	defer s.qrLock.RUnlock()
+	return s.qrStr
+}
+
+func (s *Session) handlePairSuccess(evt *events.PairSuccess) {
+	s.srv.sessions.rename(s, evt.ID.User)
+}
+
+// requestSession returns the session selected by serveSessionRoute, or the default session for the
+// routes that aren't scoped to a session.
+func (srv *Server) requestSession(r *http.Request) *Session {
+	if sess, ok := r.Context().Value(sessionContextKey{}).(*Session); ok {
+		return sess
+	}
+	return srv.sessions.Default()
+}
+
+type sessionInfo struct {
+	ID       string `json:"id"`
+	JID      string `json:"jid"`
+	PushName string `json:"pushName"`
+	IsLogin  bool   `json:"isLogin"`
+}
+
+func (s *Session) info() sessionInfo {
+	info := sessionInfo{
+		ID:       s.ID(),
+		PushName: s.device.PushName,
+		IsLogin:  s.cli.IsLoggedIn(),
+	}
+	if s.device.ID != nil {
+		info.JID = s.device.ID.String()
+	}
+	return info
+}
+
+// serveSessions lists the sessions (GET) or creates a new unpaired session (POST)
+func (srv *Server) serveSessions(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "GET, POST")
+
+	var response interface{}
+	switch r.Method {
+	case "OPTIONS":
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodGet:
+		list := srv.sessions.List()
+		infos := make([]sessionInfo, 0, len(list))
+		for _, sess := range list {
+			infos = append(infos, sess.info())
+		}
+		response = infos
+	case http.MethodPost:
+		sess, err := srv.sessions.Create()
+		if err != nil {
+			srv.handleError(w, http.StatusInternalServerError, "Failed to create session", err)
+			return
+		}
+		response = sess.info()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// serveSessionRoute dispatches /sessions/{id}/{action} to the regular handlers with the session
+// stored in the request context. DELETE /sessions/{id} removes the session.
+func (srv *Server) serveSessionRoute(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/sessions/"), "/", 2)
+	sess := srv.sessions.Get(parts[0])
+	if sess == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	var action string
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	if action == "" {
+		srv.auth.setCORSHeaders(w, r, "DELETE")
+		if identity := requestIdentity(r); identity != nil && !identity.HasScope(scopeAdmin) {
+			http.Error(w, "Missing admin scope", http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case "OPTIONS":
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			if err := srv.sessions.Delete(sess.ID()); err != nil {
+				srv.handleError(w, http.StatusInternalServerError, "Failed to delete session", err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	handler, ok := srv.sessionRoutes[action]
+	if i := strings.IndexByte(action, '/'); !ok && i >= 0 {
+		// Subtree routes such as messages/{id}/edit.
+		handler, ok = srv.sessionRoutes[action[:i+1]]
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// The handlers see the path of the unscoped route.
+	r = r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, sess))
+	u := *r.URL
+	u.Path = "/" + action
+	r.URL = &u
+	handler(w, r)
+}
+
+// newSessionRoutes returns the routes that are served both unscoped (e.g. /send, using the default
+// session) and scoped to a session (e.g. /sessions/{id}/send).
+func (srv *Server) newSessionRoutes() map[string]http.HandlerFunc {
+	requireScope, requireScopeByMethod := srv.auth.requireScope, srv.auth.requireScopeByMethod
+	return map[string]http.HandlerFunc{
+		"ws":            requireScope(scopeRead, srv.serveWs),
+		"send":          requireScope(scopeSend, srv.serveSendText),
+		"send-bulk":     requireScope(scopeSend, srv.serveSendTextBulk),
+		"send-location": requireScope(scopeSend, srv.serveSendLocation),
+		"send-contact":  requireScope(scopeSend, srv.serveSendContact),
+		"status":        requireScope(scopeRead, srv.serveStatus),
+		"check-user":    requireScope(scopeRead, srv.serveCheckUser),
+		"qr":            requireScope(scopeAdmin, srv.serveQR),
+		"messages/":     requireScope(scopeSend, srv.serveMessage),
+		"polls":         requireScope(scopeSend, srv.serveCreatePoll),
+		"polls/":        requireScope(scopeRead, srv.servePoll),
+		"chats":         requireScope(scopeRead, srv.serveChats),
+		"chats/":        requireScope(scopeRead, srv.serveChat),
+		"search":        requireScope(scopeRead, srv.serveSearch),
+		"media/":        requireScope(scopeRead, srv.serveMedia),
+		"groups":        requireScopeByMethod(scopeRead, scopeSend, srv.serveGroups),
+		"groups/":       requireScopeByMethod(scopeRead, scopeSend, srv.serveGroup),
+		"upload": requireScope(scopeSend, func(w http.ResponseWriter, r *http.Request) {
+			srv.uploadHandler(w, r, srv.dataDir)
+		}),
+		"upload-new": requireScope(scopeSend, func(w http.ResponseWriter, r *http.Request) {
+			srv.newUploadHandler(w, r, srv.dataDir)
+		}),
+	}
+}
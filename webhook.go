@@ -0,0 +1,283 @@
+package whatsappws
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	webhookWorkers       = 4
+	webhookQueueSize     = 1024
+	webhookMaxAttempts   = 6
+	webhookTimeout       = 10 * time.Second
+	webhookRetryInterval = time.Second
+	webhookRetryBatch    = 100
+)
+
+// WebhookEndpoint is a target that receives events as signed JSON POST requests.
+type WebhookEndpoint struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+	// Event types to send, e.g. message, receipt, presence or connection. Empty means all events.
+	Events []string `json:"events"`
+}
+
+// WebhookConfig is read from the file passed with -webhook-config.
+type WebhookConfig struct {
+	Endpoints []WebhookEndpoint `json:"endpoints"`
+}
+
+// Webhooks delivers events to the configured endpoints. Failed deliveries are stored in the
+// webhook_retries table and retried with exponential backoff, also after a restart, and end up
+// in the webhook_dead_letters table.
+type Webhooks struct {
+	log       waLog.Logger
+	db        *sql.DB
+	endpoints []WebhookEndpoint
+	client    *http.Client
+	queue     chan *webhookDelivery
+}
+
+type webhookDelivery struct {
+	endpoint  *WebhookEndpoint
+	eventType string
+	body      []byte
+	attempts  int
+	lastError string
+}
+
+func newWebhooks(configPath string, db *sql.DB, log waLog.Logger) (*Webhooks, error) {
+	wh := &Webhooks{
+		log:    log,
+		db:     db,
+		client: &http.Client{Timeout: webhookTimeout},
+		queue:  make(chan *webhookDelivery, webhookQueueSize),
+	}
+	if configPath == "" {
+		return wh, nil
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook config: %w", err)
+	}
+	var config WebhookConfig
+	if err = json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook config: %w", err)
+	}
+	wh.endpoints = config.Endpoints
+	return wh, nil
+}
+
+// Start starts the delivery workers and the retry worker, which stops when the context is
+// cancelled.
+func (wh *Webhooks) Start(ctx context.Context) {
+	for i := 0; i < webhookWorkers; i++ {
+		go wh.worker()
+	}
+	go wh.runRetryWorker(ctx)
+}
+
+func (endpoint *WebhookEndpoint) wants(eventType string) bool {
+	if len(endpoint.Events) == 0 {
+		return true
+	}
+	for _, wanted := range endpoint.Events {
+		if wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatch queues the event for every endpoint that subscribed to its type.
+func (wh *Webhooks) Dispatch(evt Event) {
+	if len(wh.endpoints) == 0 {
+		return
+	}
+	body, err := json.Marshal(evt)
+	if err != nil {
+		wh.log.Errorf("Failed to encode webhook event: %v", err)
+		return
+	}
+	for i := range wh.endpoints {
+		endpoint := &wh.endpoints[i]
+		if !endpoint.wants(evt.Type) {
+			continue
+		}
+		wh.enqueue(&webhookDelivery{endpoint: endpoint, eventType: evt.Type, body: body})
+	}
+}
+
+func (wh *Webhooks) enqueue(delivery *webhookDelivery) {
+	select {
+	case wh.queue <- delivery:
+	default:
+		delivery.lastError = "webhook queue full"
+		wh.deadLetter(delivery)
+	}
+}
+
+func (wh *Webhooks) worker() {
+	for delivery := range wh.queue {
+		wh.deliver(delivery)
+	}
+}
+
+// deliver makes an attempt to deliver an event, and stores it for a retry or as a dead letter if
+// it fails.
+func (wh *Webhooks) deliver(delivery *webhookDelivery) {
+	delivery.attempts++
+	err := wh.post(delivery)
+	if err == nil {
+		return
+	}
+	delivery.lastError = err.Error()
+	if delivery.attempts >= webhookMaxAttempts {
+		wh.deadLetter(delivery)
+		return
+	}
+	backoff := time.Second << delivery.attempts
+	wh.log.Warnf("Webhook delivery of %s to %s failed (attempt %d), retrying in %s: %v", delivery.eventType, delivery.endpoint.URL, delivery.attempts, backoff, err)
+	now := time.Now().UTC()
+	_, err = wh.db.Exec(`
+		INSERT INTO webhook_retries (url, event_type, payload, attempts, last_error, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, delivery.endpoint.URL, delivery.eventType, string(delivery.body), delivery.attempts, delivery.lastError, now.Add(backoff), now)
+	if err != nil {
+		wh.log.Errorf("Error inserting into webhook_retries: %v", err)
+		wh.deadLetter(delivery)
+	}
+}
+
+// runRetryWorker queues the due retries until the context is cancelled.
+func (wh *Webhooks) runRetryWorker(ctx context.Context) {
+	ticker := time.NewTicker(webhookRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			wh.processRetries()
+		}
+	}
+}
+
+// processRetries takes the due retries out of webhook_retries and queues them. Retries for an
+// endpoint that is no longer configured are dead-lettered.
+func (wh *Webhooks) processRetries() {
+	rows, err := wh.db.Query(`
+		SELECT id, url, event_type, payload, attempts, last_error FROM webhook_retries
+		WHERE next_attempt_at <= $1
+		ORDER BY next_attempt_at
+		LIMIT $2
+	`, time.Now().UTC(), webhookRetryBatch)
+	if err != nil {
+		wh.log.Errorf("Failed to read webhook retries: %v", err)
+		return
+	}
+	type retry struct {
+		id       int64
+		url      string
+		delivery webhookDelivery
+	}
+	var due []retry
+	for rows.Next() {
+		var r retry
+		var payload string
+		if err = rows.Scan(&r.id, &r.url, &r.delivery.eventType, &payload, &r.delivery.attempts, &r.delivery.lastError); err != nil {
+			wh.log.Errorf("Failed to read webhook retry: %v", err)
+			continue
+		}
+		r.delivery.body = []byte(payload)
+		due = append(due, r)
+	}
+	rows.Close()
+
+	for i := range due {
+		r := &due[i]
+		// Claim the retry so it isn't queued twice.
+		res, err := wh.db.Exec(`DELETE FROM webhook_retries WHERE id = $1`, r.id)
+		if err != nil {
+			wh.log.Errorf("Failed to claim webhook retry %d: %v", r.id, err)
+			continue
+		} else if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		r.delivery.endpoint = wh.endpoint(r.url)
+		if r.delivery.endpoint == nil {
+			r.delivery.endpoint = &WebhookEndpoint{URL: r.url}
+			r.delivery.lastError = "endpoint is no longer configured"
+			wh.deadLetter(&r.delivery)
+			continue
+		}
+		wh.enqueue(&r.delivery)
+	}
+}
+
+// endpoint returns the configured endpoint with the URL, or nil.
+func (wh *Webhooks) endpoint(url string) *WebhookEndpoint {
+	for i := range wh.endpoints {
+		if wh.endpoints[i].URL == url {
+			return &wh.endpoints[i]
+		}
+	}
+	return nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>".
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wh *Webhooks) post(delivery *webhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, delivery.endpoint.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.eventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if delivery.endpoint.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(delivery.endpoint.Secret, timestamp, delivery.body))
+	}
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (wh *Webhooks) deadLetter(delivery *webhookDelivery) {
+	wh.log.Errorf("Giving up on webhook delivery of %s to %s after %d attempts: %s", delivery.eventType, delivery.endpoint.URL, delivery.attempts, delivery.lastError)
+	_, err := wh.db.Exec(`
+		INSERT INTO webhook_dead_letters (url, event_type, payload, attempts, last_error, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, delivery.endpoint.URL, delivery.eventType, string(delivery.body), delivery.attempts, delivery.lastError, time.Now().UTC())
+	if err != nil {
+		wh.log.Errorf("Error inserting into webhook_dead_letters: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package whatsappws
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+func TestWebhookRetriesEndInDeadLetters(t *testing.T) {
+	db := openTestDB(t)
+	if err := MigrateChatLog(db, "sqlite3", waLog.Noop); err != nil {
+		t.Fatal(err)
+	}
+	const secret = "s3cret"
+	var mu sync.Mutex
+	var requests int
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := "sha256=" + signWebhook(secret, r.Header.Get("X-Webhook-Timestamp"), body)
+		if got := r.Header.Get("X-Webhook-Signature"); got != want {
+			t.Errorf("signature is %q, want %q", got, want)
+		}
+		if got := r.Header.Get("X-Webhook-Event"); got != "message" {
+			t.Errorf("event header is %q, want message", got)
+		}
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer endpoint.Close()
+
+	wh, err := newWebhooks("", db, waLog.Noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wh.endpoints = []WebhookEndpoint{{URL: endpoint.URL, Secret: secret}}
+	wh.Dispatch(Event{Type: "message", Session: testPhone, Data: map[string]string{"id": "MSG1"}})
+
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		select {
+		case delivery := <-wh.queue:
+			wh.deliver(delivery)
+		default:
+			t.Fatalf("attempt %d wasn't queued", attempt)
+		}
+		// Make the stored retry due instead of waiting for the backoff.
+		if _, err = db.Exec(`UPDATE webhook_retries SET next_attempt_at = '2000-01-01 00:00:00'`); err != nil {
+			t.Fatal(err)
+		}
+		wh.processRetries()
+	}
+	if len(wh.queue) != 0 {
+		t.Errorf("%d deliveries are still queued after the last attempt", len(wh.queue))
+	}
+	if requests != webhookMaxAttempts {
+		t.Errorf("endpoint got %d requests, want %d", requests, webhookMaxAttempts)
+	}
+
+	var retries int
+	if err = db.QueryRow(`SELECT COUNT(*) FROM webhook_retries`).Scan(&retries); err != nil {
+		t.Fatal(err)
+	} else if retries != 0 {
+		t.Errorf("%d retries are left", retries)
+	}
+	var url, eventType, lastError string
+	var attempts int
+	err = db.QueryRow(`SELECT url, event_type, attempts, last_error FROM webhook_dead_letters`).Scan(&url, &eventType, &attempts, &lastError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != endpoint.URL || eventType != "message" || attempts != webhookMaxAttempts || lastError == "" {
+		t.Errorf("dead letter is %s %s after %d attempts: %s", url, eventType, attempts, lastError)
+	}
+}
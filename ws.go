@@ -1,13 +1,14 @@
-package main
+package whatsappws
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"strconv"
 
-	"github.com/gorilla/websocket"
 	"github.com/mdp/qrterminal/v3"
+	"go.mau.fi/whatsmeow/types"
 )
 
 const (
@@ -21,52 +22,42 @@ const (
 	imageSVG  = "image/svg+xml"
 )
 
-// WebSocket upgrader
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
 type Command struct {
+	ID        string   `json:"id"`
 	Cmd       string   `json:"cmd"`
 	Arguments []string `json:"args"`
 	UserID    int      `json:"user_id"`
+	Session   string   `json:"session"`
+
+	// Optional for send: the ID of the message to reply to and the JIDs to mention.
+	QuotedMessageID string   `json:"quoted_message_id"`
+	Mentions        []string `json:"mentions"`
 }
 
-// Handle incoming WebSocket connections, read json messages and pass them to the handleCmd function
-func serveWs(w http.ResponseWriter, r *http.Request) {
-	var err error
-	wsConn, err = upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Errorf("Failed to upgrade connection: %v", err)
-		return
-	}
-	defer wsConn.Close()
+// Reply is sent to the WebSocket client that issued a Command, with the ID of the command.
+type Reply struct {
+	ID     string      `json:"id"`
+	OK     bool        `json:"ok"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
 
-	for {
-		var cmd Command
-		err := wsConn.ReadJSON(&cmd)
-		if err != nil {
-			log.Errorf("Failed to read json: %v", err)
-			return
-		}
-		handleCmd(cmd)
+func newReply(cmd Command, result interface{}, err error) Reply {
+	if err != nil {
+		return Reply{ID: cmd.ID, OK: false, Error: err.Error()}
 	}
+	return Reply{ID: cmd.ID, OK: true, Result: result}
 }
 
-// ServeStatus returns the current status of the client
-func serveSendText(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// serveSendText queues a text message and returns its outbox entry
+func (srv *Server) serveSendText(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "POST")
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	if cli.IsLoggedIn() {
+	sess := srv.requestSession(r)
+	if sess != nil && sess.cli.IsLoggedIn() {
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, "Error reading request body", http.StatusBadRequest)
@@ -74,8 +65,10 @@ func serveSendText(w http.ResponseWriter, r *http.Request) {
 		}
 
 		type messageBodyText struct {
-			Recipient string `json:"recipient" validate:"required"`
-			Message   string `json:"message" validate:"required"`
+			Recipient       string   `json:"recipient" validate:"required"`
+			Message         string   `json:"message" validate:"required"`
+			QuotedMessageID string   `json:"quoted_message_id"`
+			Mentions        []string `json:"mentions"`
 		}
 
 		var msgBody messageBodyText
@@ -84,33 +77,42 @@ func serveSendText(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Error decoding JSON", http.StatusBadRequest)
 			return
 		}
-		handleSendNewTextMessage(msgBody.Message, msgBody.Recipient)
-
-		respJson, err := json.Marshal(msgBody)
+		recipient, err := parseJID(msgBody.Recipient)
 		if err != nil {
-			http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts, err := parseTextOptions(msgBody.QuotedMessageID, msgBody.Mentions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write(respJson)
+		queued, err := sess.enqueueTextMessage(recipient, msgBody.Message, boundUserID(requestIdentity(r), -1), opts)
+		if errors.Is(err, errMessageNotFound) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			srv.handleError(w, http.StatusInternalServerError, "Failed to queue message", err)
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, queued)
 		return
 
 	}
 	w.WriteHeader(http.StatusServiceUnavailable)
 }
 
-// ServeStatus returns the current status of the client
-func serveSendTextBulk(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// serveSendTextBulk queues a text message for every recipient and returns the outbox entries
+func (srv *Server) serveSendTextBulk(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "POST")
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	if cli.IsLoggedIn() {
+	sess := srv.requestSession(r)
+	if sess != nil && sess.cli.IsLoggedIn() {
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, "Error reading request body", http.StatusBadRequest)
@@ -128,31 +130,42 @@ func serveSendTextBulk(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Error decoding JSON", http.StatusBadRequest)
 			return
 		}
-		handleSendNewTextMessageBulk(msgBody.Message, msgBody.Recipient)
 
-		respJson, err := json.Marshal(msgBody)
-		if err != nil {
-			http.Error(w, "Error decoding JSON", http.StatusBadRequest)
-			return
+		recipients := make([]types.JID, 0, len(msgBody.Recipient))
+		for _, jid := range msgBody.Recipient {
+			recipient, err := parseJID(jid)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			recipients = append(recipients, recipient)
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write(respJson)
+		userID := boundUserID(requestIdentity(r), -1)
+		queued := make([]*OutboxMessage, 0, len(recipients))
+		for _, recipient := range recipients {
+			msg, err := sess.enqueueTextMessage(recipient, msgBody.Message, userID, textOptions{})
+			if err != nil {
+				srv.handleError(w, http.StatusInternalServerError, "Failed to queue message", err)
+				return
+			}
+			queued = append(queued, msg)
+		}
+
+		writeJSON(w, http.StatusAccepted, queued)
 		return
 	}
 	w.WriteHeader(http.StatusServiceUnavailable)
 }
 
-func serveCheckUser(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+func (srv *Server) serveCheckUser(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "POST")
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	if cli.IsLoggedIn() {
+	sess := srv.requestSession(r)
+	if sess != nil && sess.cli.IsLoggedIn() {
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, "Error reading request body", http.StatusBadRequest)
@@ -169,7 +182,11 @@ func serveCheckUser(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Error decoding JSON", http.StatusBadRequest)
 			return
 		}
-		response := newHandleCheckUser(msgBody.Recipient)
+		response, err := sess.newHandleCheckUser(msgBody.Recipient)
+		if err != nil {
+			srv.handleError(w, http.StatusInternalServerError, "Failed to check users", err)
+			return
+		}
 
 		responseJSON, err := json.Marshal(response)
 		if err != nil {
@@ -186,15 +203,14 @@ func serveCheckUser(w http.ResponseWriter, r *http.Request) {
 }
 
 // ServeStatus returns the current status of the client
-func serveStatus(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+func (srv *Server) serveStatus(w http.ResponseWriter, r *http.Request) {
+	srv.auth.setCORSHeaders(w, r, "GET")
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	if cli.IsLoggedIn() {
+	sess := srv.requestSession(r)
+	if sess != nil && sess.cli.IsLoggedIn() {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 
@@ -203,9 +219,9 @@ func serveStatus(w http.ResponseWriter, r *http.Request) {
 			PushName string `json:"pushName"`
 			IsLogin  bool   `json:"isLogin"`
 		}{
-			ID:       cli.Store.ID.String(),
-			PushName: cli.Store.PushName,
-			IsLogin:  cli.IsLoggedIn(),
+			ID:       sess.device.ID.String(),
+			PushName: sess.device.PushName,
+			IsLogin:  sess.cli.IsLoggedIn(),
 		}
 
 		// Convert the response to JSON
@@ -221,43 +237,56 @@ func serveStatus(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusServiceUnavailable)
 }
 
-func serveQR(w http.ResponseWriter, r *http.Request) {
-	if cli.IsLoggedIn() {
+func (srv *Server) serveQR(w http.ResponseWriter, r *http.Request) {
+	sess := srv.requestSession(r)
+	if sess == nil || sess.cli.IsLoggedIn() {
 		w.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
+	qrStr := sess.getQR()
+	if qrStr == "" {
+		http.Error(w, "QR code not available yet", http.StatusNotFound)
+		return
+	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	qrterminal.GenerateHalfBlock(qrStr, qrterminal.L, w)
 }
 
-func uploadHandler(w http.ResponseWriter, r *http.Request, uploadDir string) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+func (srv *Server) uploadHandler(w http.ResponseWriter, r *http.Request, uploadDir string) {
+	srv.auth.setCORSHeaders(w, r, "GET")
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
+	sess := srv.requestSession(r)
+	if sess == nil || !sess.cli.IsLoggedIn() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
 	err := r.ParseMultipartForm(10 << 20)
 	if err != nil {
-		handleError(w, http.StatusBadRequest, "Failed to parse multipart form", err)
+		srv.handleError(w, http.StatusBadRequest, "Failed to parse multipart form", err)
 		return
 	}
 
 	file, handler, err := r.FormFile("file")
 	if err != nil {
-		handleError(w, http.StatusBadRequest, "Failed to retrieve file from request", err)
+		srv.handleError(w, http.StatusBadRequest, "Failed to retrieve file from request", err)
 		return
 	}
 	defer file.Close()
 
 	JID := r.FormValue("jid")
 	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if identity := requestIdentity(r); identity != nil {
+		userID, err = identity.UserID, nil
+	}
 	if err != nil {
-		handleError(w, http.StatusBadRequest, "Invalid user ID", err)
+		srv.handleError(w, http.StatusBadRequest, "Invalid user ID", err)
 		return
 	}
 
@@ -265,51 +294,65 @@ func uploadHandler(w http.ResponseWriter, r *http.Request, uploadDir string) {
 
 	data, err := io.ReadAll(file)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, "Failed to read file data", err)
+		srv.handleError(w, http.StatusInternalServerError, "Failed to read file data", err)
 		return
 	}
 
-	mimeType := http.DetectContentType(data)
+	mimeType := detectMimeType(data, handler.Filename)
 
 	extAsImage := []string{"image/jpeg", "image/png"}
 	if stringContains(extAsImage, mimeType) {
-		err = handleSendImage(JID, userID, data, captionMsg)
+		err = sess.handleSendImage(JID, userID, data, captionMsg)
+		if err != nil {
+			srv.handleError(w, http.StatusInternalServerError, "Failed to handle image upload", err)
+			return
+		}
+	} else if isMediaUpload(r, mimeType) {
+		upload, err := newMediaUpload(r, data, mimeType, captionMsg)
 		if err != nil {
-			handleError(w, http.StatusInternalServerError, "Failed to handle image upload", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err = sess.sendMedia([]string{JID}, userID, upload); err != nil {
+			srv.handleError(w, http.StatusInternalServerError, "Failed to handle "+upload.kind()+" upload", err)
 			return
 		}
 	} else {
-		err = handleSendDocument(JID, handler.Filename, userID, data, captionMsg)
+		err = sess.handleSendDocument(JID, handler.Filename, userID, data, captionMsg)
 		if err != nil {
-			handleError(w, http.StatusInternalServerError, "Failed to handle document upload", err)
+			srv.handleError(w, http.StatusInternalServerError, "Failed to handle document upload", err)
 			return
 		}
 	}
 
-	log.Infof("Uploaded file %s to %s, mimetype: %s", handler.Filename, JID, mimeType)
+	srv.log.Infof("Uploaded file %s to %s, mimetype: %s", handler.Filename, JID, mimeType)
 	w.WriteHeader(http.StatusOK)
 }
 
-func newUploadHandler(w http.ResponseWriter, r *http.Request, uploadDir string) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+func (srv *Server) newUploadHandler(w http.ResponseWriter, r *http.Request, uploadDir string) {
+	srv.auth.setCORSHeaders(w, r, "POST")
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
+	sess := srv.requestSession(r)
+	if sess == nil || !sess.cli.IsLoggedIn() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
 	err := r.ParseMultipartForm(10 << 20)
 	if err != nil {
-		handleError(w, http.StatusBadRequest, "Failed to parse multipart form", err)
+		srv.handleError(w, http.StatusBadRequest, "Failed to parse multipart form", err)
 		return
 	}
 
 	// Get the files
 	files, ok := r.MultipartForm.File["file"]
 	if !ok || len(files) == 0 {
-		handleError(w, http.StatusBadRequest, "No files found in the request", nil)
+		srv.handleError(w, http.StatusBadRequest, "No files found in the request", nil)
 		return
 	}
 
@@ -322,7 +365,7 @@ func newUploadHandler(w http.ResponseWriter, r *http.Request, uploadDir string)
 		// Open the file
 		file, err := handler.Open()
 		if err != nil {
-			handleError(w, http.StatusInternalServerError, "Failed to open file", err)
+			srv.handleError(w, http.StatusInternalServerError, "Failed to open file", err)
 			return
 		}
 		defer file.Close()
@@ -330,26 +373,33 @@ func newUploadHandler(w http.ResponseWriter, r *http.Request, uploadDir string)
 		// Read the file data
 		data, err := io.ReadAll(file)
 		if err != nil {
-			handleError(w, http.StatusInternalServerError, "Failed to read file data", err)
+			srv.handleError(w, http.StatusInternalServerError, "Failed to read file data", err)
 			return
 		}
 
 		sliceJID, err := validateStringArrayAsStringArray(JID)
 		if err != nil {
-			handleError(w, http.StatusInternalServerError, "Something went wrong with parameter jid", err)
+			srv.handleError(w, http.StatusInternalServerError, "Something went wrong with parameter jid", err)
 			return
 		}
 
 		var uploadResp []Message
-		mimeType := http.DetectContentType(data)
+		mimeType := detectMimeType(data, handler.Filename)
 		if isImage(mimeType) {
-			uploadResp, err = newHandleSendImage(sliceJID, data, captionMsg)
+			uploadResp, err = sess.newHandleSendImage(sliceJID, data, captionMsg)
+		} else if isMediaUpload(r, mimeType) {
+			var upload *mediaUpload
+			if upload, err = newMediaUpload(r, data, mimeType, captionMsg); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			uploadResp, err = sess.sendMedia(sliceJID, boundUserID(requestIdentity(r), -1), upload)
 		} else {
-			uploadResp, err = newHandleSendDocument(sliceJID, handler.Filename, data, captionMsg)
+			uploadResp, err = sess.newHandleSendDocument(sliceJID, handler.Filename, data, captionMsg)
 		}
 
 		if err != nil {
-			handleError(w, http.StatusInternalServerError, "Failed to handle file upload", err)
+			srv.handleError(w, http.StatusInternalServerError, "Failed to handle file upload", err)
 			return
 		}
 
@@ -367,11 +417,22 @@ func newUploadHandler(w http.ResponseWriter, r *http.Request, uploadDir string)
 	w.Write(jsonResponse)
 }
 
-func handleError(w http.ResponseWriter, statusCode int, message string, err error) {
-	log.Errorf("%s: %v", message, err)
+func (srv *Server) handleError(w http.ResponseWriter, statusCode int, message string, err error) {
+	srv.log.Errorf("%s: %v", message, err)
 	http.Error(w, message, statusCode)
 }
 
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	jsonResponse, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(jsonResponse)
+}
+
 func isImage(mimeType string) bool {
 	extAsImage := []string{
 		imageJPEG,
@@ -0,0 +1,301 @@
+package whatsappws
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSend(t *testing.T) {
+	srv, fake := newTestServer(t, nil)
+
+	var queued OutboxMessage
+	w := serveJSON(t, srv, http.MethodPost, "/send", map[string]string{"recipient": "+905550000000", "message": "Hello"})
+	decodeResponse(t, w, http.StatusAccepted, &queued)
+	if queued.MessageID == "" || queued.Recipient != "905550000000@s.whatsapp.net" || queued.Status != outboxQueued {
+		t.Errorf("queued message is %+v", queued)
+	}
+	if sent := fake.SentMessages(); len(sent) != 0 {
+		t.Fatalf("message was sent before the outbox was processed: %+v", sent)
+	}
+
+	srv.processOutbox()
+	sent := fake.SentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	if sent[0].ID != queued.MessageID || sent[0].To.String() != queued.Recipient || sent[0].Message.GetConversation() != "Hello" {
+		t.Errorf("sent message is %+v", sent[0])
+	}
+
+	var outbox OutboxMessage
+	decodeResponse(t, serve(srv, http.MethodGet, "/outbox/"+queued.MessageID, "", nil), http.StatusOK, &outbox)
+	if outbox.Status != outboxSent || outbox.Attempts != 1 || outbox.SentAt == nil {
+		t.Errorf("outbox entry after sending is %+v", outbox)
+	}
+}
+
+func TestSendWithoutSession(t *testing.T) {
+	srv, fake := newTestServer(t, nil)
+	queue := func() string {
+		t.Helper()
+		var queued OutboxMessage
+		w := serveJSON(t, srv, http.MethodPost, "/send", map[string]string{"recipient": "905550000000", "message": "Hello"})
+		decodeResponse(t, w, http.StatusAccepted, &queued)
+		return queued.MessageID
+	}
+	status := func(id string) OutboxMessage {
+		t.Helper()
+		var outbox OutboxMessage
+		decodeResponse(t, serve(srv, http.MethodGet, "/outbox/"+id, "", nil), http.StatusOK, &outbox)
+		return outbox
+	}
+
+	// A message of an offline session waits for it to reconnect.
+	offline := queue()
+	fake.SetConnected(false, true)
+	srv.processOutbox()
+	if outbox := status(offline); outbox.Status != outboxQueued || outbox.Attempts != 0 || outbox.LastError != "" {
+		t.Errorf("outbox entry of an offline session is %+v", outbox)
+	}
+	fake.SetConnected(true, true)
+
+	// A message of a removed session fails.
+	removed := queue()
+	if _, err := srv.db.Exec(`UPDATE outbox SET device_jid = $1 WHERE message_id = $2`, "905559998877@s.whatsapp.net", removed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.db.Exec(`UPDATE outbox SET next_attempt_at = $1`, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	srv.processOutbox()
+	if outbox := status(removed); outbox.Status != outboxFailed || outbox.LastError != errSessionNotFound.Error() {
+		t.Errorf("outbox entry of a removed session is %+v", outbox)
+	}
+	if sent := fake.SentMessages(); len(sent) != 1 || sent[0].ID != offline {
+		t.Errorf("sent messages are %+v", sent)
+	}
+}
+
+func TestSendQuoted(t *testing.T) {
+	srv, fake := newTestServer(t, nil)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	fake.Emit(receivedMessage("TEXT", testChat, testChat, start, &waProto.Message{Conversation: proto.String("Hello")}))
+	fake.Emit(receivedMessage("IMAGE", testChat, testChat, start, &waProto.Message{ImageMessage: &waProto.ImageMessage{
+		Caption:  proto.String("Photo"),
+		Mimetype: proto.String("image/jpeg"),
+	}}))
+
+	for _, id := range []string{"TEXT", "IMAGE"} {
+		w := serveJSON(t, srv, http.MethodPost, "/send", map[string]string{"recipient": "905550000000", "message": "Reply", "quoted_message_id": id})
+		decodeResponse(t, w, http.StatusAccepted, nil)
+	}
+	srv.processOutbox()
+	sent := fake.SentMessages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(sent))
+	}
+	text := sent[0].Message.GetExtendedTextMessage().GetContextInfo()
+	if text.GetStanzaId() != "TEXT" || text.GetParticipant() != testChat.String() || text.GetQuotedMessage().GetConversation() != "Hello" {
+		t.Errorf("context of the reply to the text is %v", text)
+	}
+	image := sent[1].Message.GetExtendedTextMessage().GetContextInfo()
+	if quoted := image.GetQuotedMessage().GetImageMessage(); image.GetStanzaId() != "IMAGE" || quoted.GetCaption() != "Photo" || quoted.GetMimetype() != "image/jpeg" {
+		t.Errorf("context of the reply to the image is %v", image)
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	srv, fake := newTestServer(t, nil)
+
+	for _, test := range []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"invalid JSON", `{"recipient":`, http.StatusBadRequest},
+		{"empty recipient", `{"recipient":"","message":"Hello"}`, http.StatusBadRequest},
+		{"invalid mention", `{"recipient":"905550000000","message":"Hello","mentions":["@"]}`, http.StatusBadRequest},
+		{"unknown quoted message", `{"recipient":"905550000000","message":"Hello","quoted_message_id":"MISSING"}`, http.StatusBadRequest},
+	} {
+		if w := serveJSON(t, srv, http.MethodPost, "/send", test.body); w.Code != test.status {
+			t.Errorf("%s: status is %d, want %d: %s", test.name, w.Code, test.status, w.Body.String())
+		}
+	}
+
+	fake.SetConnected(true, false)
+	if w := serveJSON(t, srv, http.MethodPost, "/send", `{"recipient":"905550000000","message":"Hello"}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status when logged out is %d, want 503", w.Code)
+	}
+	srv.processOutbox()
+	if sent := fake.SentMessages(); len(sent) != 0 {
+		t.Errorf("sent rejected messages: %+v", sent)
+	}
+}
+
+func TestSendScopedToSession(t *testing.T) {
+	srv, fake := newTestServer(t, nil)
+	other := &FakeClient{}
+	srv.Sessions().Attach("905559998877", NewFakeDevice("905559998877"), other)
+
+	var queued OutboxMessage
+	w := serveJSON(t, srv, http.MethodPost, "/sessions/905559998877/send", map[string]string{"recipient": "905550000000", "message": "Hello"})
+	decodeResponse(t, w, http.StatusAccepted, &queued)
+	if queued.DeviceJID != "905559998877@s.whatsapp.net" {
+		t.Errorf("message was queued for %s", queued.DeviceJID)
+	}
+	srv.processOutbox()
+	if sent := other.SentMessages(); len(sent) != 1 {
+		t.Errorf("session sent %d messages, want 1", len(sent))
+	}
+	if sent := fake.SentMessages(); len(sent) != 0 {
+		t.Errorf("default session sent %d messages, want 0", len(sent))
+	}
+}
+
+// multipartBody encodes the fields and files of a multipart form.
+func multipartBody(t *testing.T, fields map[string]string, files map[string][]byte) (string, *bytes.Buffer) {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(files[name])
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writer.FormDataContentType(), body
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadNew(t *testing.T) {
+	srv, fake := newTestServer(t, nil)
+	files := map[string][]byte{"photo.png": testPNG(t), "report.txt": []byte("Quarterly report")}
+	contentType, body := multipartBody(t, map[string]string{"jid": "905550000000, 905550000001", "caption": "See attached"}, files)
+
+	var messages []Message
+	decodeResponse(t, serve(srv, http.MethodPost, "/upload-new", contentType, body), http.StatusOK, &messages)
+	if len(messages) != 4 {
+		t.Fatalf("response has %d messages, want 4: %+v", len(messages), messages)
+	}
+	for _, m := range messages {
+		if !m.Sent || m.Type != "media" || m.Session != testPhone {
+			t.Errorf("response message is %+v", m)
+		}
+	}
+	if uploads := fake.Uploads(); len(uploads) != 4 {
+		t.Errorf("uploaded %d files, want 4", len(uploads))
+	}
+
+	images := make(map[types.JID]int)
+	documents := make(map[types.JID]int)
+	for _, sent := range fake.SentMessages() {
+		switch {
+		case sent.Message.GetImageMessage() != nil:
+			if img := sent.Message.GetImageMessage(); img.GetMimetype() != "image/png" || img.GetCaption() != "See attached" {
+				t.Errorf("sent image is %v", img)
+			}
+			images[sent.To]++
+		case sent.Message.GetDocumentMessage() != nil:
+			if doc := sent.Message.GetDocumentMessage(); doc.GetFileName() != "report.txt" || doc.GetFileLength() != uint64(len(files["report.txt"])) {
+				t.Errorf("sent document is %v", doc)
+			}
+			documents[sent.To]++
+		default:
+			t.Errorf("sent unexpected message %v", sent.Message)
+		}
+	}
+	for _, phone := range []string{"905550000000", "905550000001"} {
+		jid := types.NewJID(phone, types.DefaultUserServer)
+		if images[jid] != 1 || documents[jid] != 1 {
+			t.Errorf("%s got %d images and %d documents, want 1 of each", phone, images[jid], documents[jid])
+		}
+	}
+}
+
+func TestUploadNewErrors(t *testing.T) {
+	srv, fake := newTestServer(t, nil)
+
+	contentType, body := multipartBody(t, map[string]string{"jid": "905550000000"}, nil)
+	if w := serve(srv, http.MethodPost, "/upload-new", contentType, body); w.Code != http.StatusBadRequest {
+		t.Errorf("status without files is %d, want 400", w.Code)
+	}
+	if w := serve(srv, http.MethodPost, "/upload-new", "text/plain", bytes.NewBufferString("file")); w.Code != http.StatusBadRequest {
+		t.Errorf("status without a multipart form is %d, want 400", w.Code)
+	}
+
+	fake.SetConnected(true, false)
+	contentType, body = multipartBody(t, map[string]string{"jid": "905550000000"}, map[string][]byte{"photo.png": testPNG(t)})
+	if w := serve(srv, http.MethodPost, "/upload-new", contentType, body); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status when logged out is %d, want 503", w.Code)
+	}
+	if sent := fake.SentMessages(); len(sent) != 0 {
+		t.Errorf("sent %d messages, want 0", len(sent))
+	}
+}
+
+func TestCheckUser(t *testing.T) {
+	srv, fake := newTestServer(t, nil)
+	fake.IsOnWhatsAppFunc = func(phones []string) ([]types.IsOnWhatsAppResponse, error) {
+		resp := make([]types.IsOnWhatsAppResponse, len(phones))
+		for i, phone := range phones {
+			resp[i] = types.IsOnWhatsAppResponse{Query: phone, IsIn: phone != "+905550000001"}
+			if resp[i].IsIn {
+				resp[i].JID = types.NewJID("905550000000", types.DefaultUserServer)
+			}
+		}
+		return resp, nil
+	}
+
+	var users []types.IsOnWhatsAppResponse
+	w := serveJSON(t, srv, http.MethodPost, "/check-user", map[string][]string{"recipient": {"+905550000000", "+905550000001"}})
+	decodeResponse(t, w, http.StatusOK, &users)
+	if len(users) != 2 {
+		t.Fatalf("response has %d users, want 2: %s", len(users), w.Body.String())
+	}
+	if users[0].Query != "+905550000000" || !users[0].IsIn || users[0].JID.String() != "905550000000@s.whatsapp.net" {
+		t.Errorf("registered user is %+v", users[0])
+	}
+	if users[1].Query != "+905550000001" || users[1].IsIn {
+		t.Errorf("unregistered user is %+v", users[1])
+	}
+
+	if w := serveJSON(t, srv, http.MethodPost, "/check-user", `{"recipient": "+905550000000"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("status with a string recipient is %d, want 400", w.Code)
+	}
+	fake.SetConnected(true, false)
+	if w := serveJSON(t, srv, http.MethodPost, "/check-user", `{"recipient": ["+905550000000"]}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status when logged out is %d, want 503", w.Code)
+	}
+}